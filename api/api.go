@@ -0,0 +1,288 @@
+// Package api serves the jail package over HTTP, for cmd/jaild.
+// Requests are authorized by the credentials of the peer of the Unix
+// socket the API is served on (see ConnContext).
+//
+//	GET    /v1/jails              living jails (all jails with ?all=1)
+//	POST   /v1/jails              create a jail from a jail.Spec
+//	GET    /v1/jails/{id}         a jail, by ID or by name
+//	PATCH  /v1/jails/{id}         set parameters of a jail
+//	DELETE /v1/jails/{id}         remove a jail
+//	POST   /v1/jails/{id}/exec    run a command in a jail
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"golang.org/x/sys/unix"
+)
+
+// ExitStatusHeader is the trailer that carries the exit status of a
+// command run through exec, or -1 when it did not exit normally
+const ExitStatusHeader = "X-Exit-Status"
+
+// ExecRequest is the body of an exec request
+type ExecRequest struct {
+	// Command is the name of the command, and its arguments
+	Command []string `json:"command"`
+}
+
+// Error is the body of a response that failed
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// Handler serves the API. The zero value allows root only, and runs
+// commands through jail.Command.
+type Handler struct {
+	// Policy decides which peers may use the API
+	Policy Policy
+	// Command returns a command that runs inside a jail
+	// (jail.Command when nil)
+	Command func(ctx context.Context, jid int32, name string, arg ...string) *exec.Cmd
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(h.routes)
+	cred, ok := CredFromContext(r.Context())
+	switch {
+	case !ok:
+		writeError(w, &Error{http.StatusForbidden, "peer credentials are unknown"})
+	case !h.Policy.Allows(cred):
+		writeError(w, &Error{http.StatusForbidden, fmt.Sprintf("uid %d is not allowed", cred.UID)})
+	default:
+		h.mux.ServeHTTP(w, r)
+	}
+}
+
+func (h *Handler) routes() {
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc("GET /v1/jails", h.list)
+	h.mux.HandleFunc("POST /v1/jails", h.create)
+	h.mux.HandleFunc("GET /v1/jails/{id}", h.get)
+	h.mux.HandleFunc("PATCH /v1/jails/{id}", h.update)
+	h.mux.HandleFunc("DELETE /v1/jails/{id}", h.remove)
+	h.mux.HandleFunc("POST /v1/jails/{id}/exec", h.exec)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	find := jail.Living
+	if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); all {
+		find = jail.All
+	}
+	jails, err := find()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if jails == nil {
+		jails = []*jail.Jail{}
+	}
+	writeJSON(w, http.StatusOK, jails)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	j, err := find(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// create reads a Spec over the defaults of jail.NewSpec, as
+// jail.Import does
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	s := jail.NewSpec("", "")
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		writeError(w, &Error{http.StatusBadRequest, err.Error()})
+		return
+	}
+	if err := s.Validate(); err != nil {
+		writeError(w, &Error{http.StatusBadRequest, err.Error()})
+		return
+	}
+	j, err := jail.Create(s)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, j)
+}
+
+// update sets the parameters of a JSON object in a single
+// jail_set(2) call. Numbers are sent as int32, as every numeric
+// parameter is.
+func (h *Handler) update(w http.ResponseWriter, r *http.Request) {
+	j, err := find(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, &Error{http.StatusBadRequest, err.Error()})
+		return
+	}
+	params := jail.NewParams()
+	for name, v := range body {
+		pv, err := paramValue(name, v)
+		if err != nil {
+			writeError(w, &Error{http.StatusBadRequest, err.Error()})
+			return
+		}
+		params.Add(name, pv)
+	}
+	if err := j.SetParams(params); err != nil {
+		writeError(w, err)
+		return
+	}
+	if j, err = jail.FindByID(j.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (h *Handler) remove(w http.ResponseWriter, r *http.Request) {
+	j, err := find(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := jail.Remove(j.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exec streams the combined output of a command as it is written,
+// and reports its exit status in the ExitStatusHeader trailer
+func (h *Handler) exec(w http.ResponseWriter, r *http.Request) {
+	j, err := find(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, &Error{http.StatusBadRequest, err.Error()})
+		return
+	} else if len(req.Command) == 0 || req.Command[0] == "" {
+		writeError(w, &Error{http.StatusBadRequest, "missing command"})
+		return
+	}
+	command := h.Command
+	if command == nil {
+		command = jail.Command
+	}
+	cmd := command(r.Context(), j.ID, req.Command[0], req.Command[1:]...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Trailer", ExitStatusHeader)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 4096)
+	for {
+		n, err := out.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+			rc.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+	cmd.Wait()
+	code := -1
+	if cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	}
+	w.Header().Set(ExitStatusHeader, strconv.Itoa(code))
+}
+
+// find returns a jail by ID, or by name when id is not numeric
+func find(id string) (*jail.Jail, error) {
+	if jid, err := strconv.ParseInt(id, 10, 32); err == nil {
+		return jail.FindByID(int32(jid))
+	}
+	return jail.FindByName(id)
+}
+
+// paramValue converts a value decoded from JSON to a value that
+// jail.Params can encode
+func paramValue(name string, v any) (any, error) {
+	switch v := v.(type) {
+	case string, bool:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("%s: not a 32-bit integer: %v", name, v)
+		}
+		return int32(v), nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported value: %v", name, v)
+	}
+}
+
+// status returns the HTTP status of an error returned by the jail
+// package
+func status(err error) int {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Status
+	case errors.Is(err, jail.ErrImmutable), errors.Is(err, unix.EEXIST):
+		return http.StatusConflict
+	case errors.Is(err, unix.ENOENT), errors.Is(err, unix.ESRCH):
+		return http.StatusNotFound
+	case errors.Is(err, unix.EINVAL):
+		return http.StatusBadRequest
+	case errors.Is(err, unix.EPERM):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := status(err)
+	msg := err.Error()
+	var e *Error
+	if errors.As(err, &e) {
+		msg = e.Message
+	}
+	writeJSON(w, code, &Error{Status: code, Message: msg})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net"
+	"slices"
+)
+
+// Cred is the credentials of the process at the other end of a
+// Unix socket
+type Cred struct {
+	UID    uint32
+	Groups []uint32
+}
+
+// Policy decides which peers may use the API. Root is always
+// allowed.
+type Policy struct {
+	// UIDs are the users that are allowed, besides root
+	UIDs []uint32
+	// GIDs are the groups whose members are allowed
+	GIDs []uint32
+}
+
+type credKey struct{}
+
+// Returns a context that carries the credentials of the peer of a
+// connection, for http.Server.ConnContext. A connection that is not a
+// Unix socket, or whose credentials cannot be read, carries none and
+// is refused by the Handler.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := PeerCred(uc)
+	if err != nil {
+		return ctx
+	}
+	return WithCred(ctx, cred)
+}
+
+// Returns a context that carries the credentials of a peer
+func WithCred(ctx context.Context, cred Cred) context.Context {
+	return context.WithValue(ctx, credKey{}, cred)
+}
+
+// Returns the credentials carried by a context
+func CredFromContext(ctx context.Context) (Cred, bool) {
+	cred, ok := ctx.Value(credKey{}).(Cred)
+	return cred, ok
+}
+
+// Reports whether a peer is allowed by the policy
+func (p Policy) Allows(cred Cred) bool {
+	if cred.UID == 0 || slices.Contains(p.UIDs, cred.UID) {
+		return true
+	}
+	for _, gid := range cred.Groups {
+		if slices.Contains(p.GIDs, gid) {
+			return true
+		}
+	}
+	return false
+}
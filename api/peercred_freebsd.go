@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the credentials of the peer of a Unix socket, through the
+// LOCAL_PEERCRED socket option
+func PeerCred(c *net.UnixConn) (Cred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var (
+		xu   *unix.Xucred
+		xerr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		xu, xerr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return Cred{}, err
+	} else if xerr != nil {
+		return Cred{}, xerr
+	}
+	cred := Cred{UID: xu.Uid}
+	for i := 0; i < int(xu.Ngroups) && i < len(xu.Groups); i++ {
+		cred.Groups = append(cred.Groups, xu.Groups[i])
+	}
+	return cred, nil
+}
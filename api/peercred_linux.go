@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the credentials of the peer of a Unix socket, through the
+// SO_PEERCRED socket option. Only the primary group of the peer is
+// reported.
+func PeerCred(c *net.UnixConn) (Cred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return Cred{}, err
+	}
+	var (
+		uc   *unix.Ucred
+		uerr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		uc, uerr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return Cred{}, err
+	} else if uerr != nil {
+		return Cred{}, uerr
+	}
+	return Cred{UID: uc.Uid, Groups: []uint32{uc.Gid}}, nil
+}
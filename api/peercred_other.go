@@ -0,0 +1,14 @@
+//go:build !freebsd && !linux
+
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+// Returns an error: peer credentials are not supported on this
+// platform
+func PeerCred(c *net.UnixConn) (Cred, error) {
+	return Cred{}, errors.New("api: peer credentials are not supported")
+}
@@ -0,0 +1,150 @@
+// Package client is a client of the API served by cmd/jaild (see
+// package api)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/api"
+)
+
+// DefaultSocket is the socket cmd/jaild listens on by default
+const DefaultSocket = "/var/run/jaild.sock"
+
+// Client talks to jaild over a Unix socket. A jail is referred to
+// by its ID, or by its name. An error reported by jaild is an
+// *api.Error.
+type Client struct {
+	HTTP *http.Client
+}
+
+// Returns a Client that connects to a Unix socket
+func New(socket string) *Client {
+	var d net.Dialer
+	return &Client{HTTP: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// Returns the living jails, or every jail when all is true
+func (c *Client) List(ctx context.Context, all bool) ([]*jail.Jail, error) {
+	path := "/v1/jails"
+	if all {
+		path += "?all=1"
+	}
+	var jails []*jail.Jail
+	return jails, c.do(ctx, http.MethodGet, path, nil, &jails)
+}
+
+// Returns a jail
+func (c *Client) Get(ctx context.Context, id string) (*jail.Jail, error) {
+	var j jail.Jail
+	if err := c.do(ctx, http.MethodGet, jailPath(id), nil, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Creates a jail from a Spec
+func (c *Client) Create(ctx context.Context, s jail.Spec) (*jail.Jail, error) {
+	var j jail.Jail
+	if err := c.do(ctx, http.MethodPost, "/v1/jails", s, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Sets parameters of a jail, and returns the updated jail. Values
+// are strings, booleans or integers.
+func (c *Client) Update(ctx context.Context, id string, params map[string]any) (*jail.Jail, error) {
+	var j jail.Jail
+	if err := c.do(ctx, http.MethodPatch, jailPath(id), params, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Removes a jail
+func (c *Client) Remove(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, jailPath(id), nil, nil)
+}
+
+// Runs a command in a jail, copies its combined output to w as it is
+// written, and returns its exit status
+func (c *Client) Exec(ctx context.Context, id string, w io.Writer, name string, arg ...string) (int, error) {
+	res, err := c.request(ctx, http.MethodPost, jailPath(id)+"/exec", api.ExecRequest{Command: append([]string{name}, arg...)})
+	if err != nil {
+		return -1, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return -1, readError(res)
+	}
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return -1, err
+	}
+	status, err := strconv.Atoi(res.Trailer.Get(api.ExitStatusHeader))
+	if err != nil {
+		return -1, fmt.Errorf("client: missing exit status")
+	}
+	return status, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	res, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return readError(res)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (c *Client) request(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://jaild"+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.HTTP.Do(req)
+}
+
+func readError(res *http.Response) error {
+	e := &api.Error{Status: res.StatusCode}
+	if err := json.NewDecoder(res.Body).Decode(e); err != nil || e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
+
+func jailPath(id string) string {
+	return "/v1/jails/" + url.PathEscape(id)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"git.hardenedbsd.org/0x1eef/jail/exporter"
+)
+
+var (
+	listen string
+	path   string
+)
+
+func main() {
+	mux := http.NewServeMux()
+	mux.Handle(path, &exporter.Exporter{})
+	log.Printf("jail_exporter: listening on %s%s", listen, path)
+	log.Fatal(http.ListenAndServe(listen, mux))
+}
+
+func init() {
+	log.SetFlags(0)
+	flag.StringVar(&listen, "l", "127.0.0.1:9452", "The address to listen on")
+	flag.StringVar(&path, "p", "/metrics", "The path metrics are served on")
+	flag.Parse()
+}
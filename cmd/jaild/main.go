@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"git.hardenedbsd.org/0x1eef/jail/api"
+	"git.hardenedbsd.org/0x1eef/jail/client"
+)
+
+var (
+	socket string
+	uids   string
+	gids   string
+)
+
+func main() {
+	policy, err := parsePolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Access is decided by the credentials of the peer, rather than
+	// by the mode of the socket
+	if err := os.Chmod(socket, 0o666); err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler:     &api.Handler{Policy: policy},
+		ConnContext: api.ConnContext,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("jaild: listening on %s", socket)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+func parsePolicy() (api.Policy, error) {
+	var (
+		p   api.Policy
+		err error
+	)
+	if p.UIDs, err = parseIDs(uids); err != nil {
+		return p, err
+	}
+	p.GIDs, err = parseIDs(gids)
+	return p, err
+}
+
+func parseIDs(s string) ([]uint32, error) {
+	var ids []uint32
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' }) {
+		id, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+func init() {
+	log.SetFlags(0)
+	flag.StringVar(&socket, "s", client.DefaultSocket, "The Unix socket to listen on")
+	flag.StringVar(&uids, "u", "", "A comma-separated list of uids that are allowed besides root")
+	flag.StringVar(&gids, "g", "", "A comma-separated list of gids whose members are allowed")
+	flag.Parse()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"git.hardenedbsd.org/0x1eef/jail"
 )
@@ -18,6 +19,7 @@ var (
 	jid   int
 	check bool
 	dying bool
+	tree  bool
 	help  bool
 )
 
@@ -32,6 +34,15 @@ func main() {
 	if check && jid == -1 {
 		fatalf("jls: -j jail to check must be provided for -c")
 	}
+	if tree {
+		nodes, err := jail.Tree()
+		if err != nil {
+			fatalf("jls: %s", err)
+		}
+		printf(header, "JID", "Name", "Hostname", "Path")
+		printJailTree(nodes, 0)
+		return
+	}
 	if dying {
 		jails, err = jail.All()
 	} else {
@@ -97,6 +108,14 @@ func printJailTable(jails []*jail.Jail) {
 	}
 }
 
+func printJailTree(nodes []*jail.Node, depth int) {
+	for _, n := range nodes {
+		name := strings.Repeat("  ", depth) + n.Name
+		printf(row, n.ID, name, n.Hostname, n.Path)
+		printJailTree(n.Children, depth+1)
+	}
+}
+
 func filterByJID(jails []*jail.Jail, jid int) []*jail.Jail {
 	if jid == -1 {
 		return jails
@@ -115,6 +134,7 @@ func init() {
 	flag.IntVar(&jid, "j", -1, "The jid of the jail to list")
 	flag.BoolVar(&check, "c", false, "Only check for the jail's existence")
 	flag.BoolVar(&dying, "d", false, "List dying as well as active jails")
+	flag.BoolVar(&tree, "tree", false, "List jails as a tree of parents and children")
 	flag.BoolVar(&help, "h", false, "Show help")
 	flag.Parse()
 }
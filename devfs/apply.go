@@ -0,0 +1,78 @@
+package devfs
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// Loads a ruleset into the kernel through devfs(8), replacing the
+// rules it had. The rulesets it includes are loaded first.
+func (r *Rules) Apply(number int, run runner.Runner) error {
+	rs, ok := r.Ruleset(number)
+	if !ok {
+		return fmt.Errorf("devfs ruleset %d is not declared", number)
+	}
+	order, err := r.order(rs, nil)
+	if err != nil {
+		return err
+	}
+	for _, rs := range order {
+		if err := r.load(rs, runner.Or(run)); err != nil {
+			return fmt.Errorf("ruleset %s: %w", rs.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rules) load(rs Ruleset, run runner.Runner) error {
+	set := strconv.Itoa(rs.Number)
+	if _, err := run.Run(exec.Command("devfs", "rule", "-s", set, "delset")); err != nil {
+		return err
+	}
+	for _, rule := range rs.Rules {
+		args, err := r.args(rule)
+		if err != nil {
+			return err
+		}
+		if _, err := run.Run(exec.Command("devfs", append([]string{"rule", "-s", set}, args...)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// args returns the arguments of "devfs rule" for a rule, with an
+// included ruleset resolved to its number
+func (r *Rules) args(rule Rule) ([]string, error) {
+	args := []string{"add"}
+	if rule.Num != 0 {
+		args = append(args, strconv.Itoa(rule.Num))
+	}
+	if rule.Include != "" {
+		inc, ok := r.Lookup(rule.Include)
+		if !ok {
+			return nil, fmt.Errorf("unknown ruleset %s", rule.Include)
+		}
+		return append(args, "include", strconv.Itoa(inc.Number)), nil
+	}
+	for _, kv := range [][2]string{{"path", rule.Path}, {"type", rule.Type}} {
+		if kv[1] != "" {
+			args = append(args, kv[0], kv[1])
+		}
+	}
+	if rule.Hide {
+		args = append(args, "hide")
+	}
+	if rule.Unhide {
+		args = append(args, "unhide")
+	}
+	for _, kv := range [][2]string{{"user", rule.User}, {"group", rule.Group}, {"mode", rule.Mode}} {
+		if kv[1] != "" {
+			args = append(args, kv[0], kv[1])
+		}
+	}
+	return args, nil
+}
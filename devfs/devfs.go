@@ -0,0 +1,342 @@
+// Package devfs reads devfs.rules(5) files and loads their rulesets
+// into the kernel through devfs(8), so that the devfs_ruleset
+// parameter of a jail refers to a ruleset that exists.
+package devfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+// DefaultFiles are the files rc(8) reads rulesets from
+var DefaultFiles = []string{"/etc/defaults/devfs.rules", "/etc/devfs.rules"}
+
+// maxRuleset is the largest ruleset number (devfs_rsnum is a uint16)
+const maxRuleset = 65535
+
+// Rules is a list of rulesets, which are looked up by name or number
+type Rules struct {
+	Rulesets []Ruleset
+}
+
+// Ruleset is a named and numbered list of rules, as declared by a
+// "[name=number]" line
+type Ruleset struct {
+	Name   string
+	Number int
+	Rules  []Rule
+}
+
+// Rule is a single "add" line of a ruleset. A rule either includes
+// another ruleset, or matches devices by path and type and applies
+// actions to them.
+type Rule struct {
+	// Num is the rule number, or 0 when devfs(8) assigns one
+	Num int
+	// Include is the name or number of an included ruleset
+	Include string
+	// Path is a glob(3) pattern matched against device names
+	Path string
+	// Type is a device type: disk, mem, tape or tty
+	Type   string
+	Hide   bool
+	Unhide bool
+	User   string
+	Group  string
+	// Mode is an octal file mode (eg "0660")
+	Mode string
+}
+
+// Reads and merges devfs.rules(5) files. A missing file is skipped,
+// as rc(8) does.
+func ReadFiles(paths ...string) (*Rules, error) {
+	rules := &Rules{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		r, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rules.Rulesets = append(rules.Rulesets, r.Rulesets...)
+	}
+	return rules, rules.Validate()
+}
+
+// Parses the devfs.rules(5) format. Includes are not resolved: see
+// Validate.
+func Parse(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "["):
+			rs, err := parseHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rules.Rulesets = append(rules.Rulesets, rs)
+		case len(rules.Rulesets) == 0:
+			return nil, fmt.Errorf("line %d: rule outside of a ruleset", n)
+		default:
+			rule, err := ParseRule(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rs := &rules.Rulesets[len(rules.Rulesets)-1]
+			rs.Rules = append(rs.Rules, rule)
+		}
+	}
+	return rules, s.Err()
+}
+
+// Parses an "add" line of a ruleset (eg "add path 'bpf*' unhide")
+func ParseRule(line string) (Rule, error) {
+	var r Rule
+	words, err := split(line)
+	if err != nil {
+		return r, err
+	}
+	if len(words) == 0 || words[0] != "add" {
+		return r, fmt.Errorf("expected \"add\": %q", line)
+	}
+	words = words[1:]
+	if len(words) > 0 {
+		if n, err := strconv.Atoi(words[0]); err == nil {
+			if n <= 0 {
+				return r, fmt.Errorf("invalid rule number: %d", n)
+			}
+			r.Num, words = n, words[1:]
+		}
+	}
+	arg := func(i int) (string, error) {
+		if i+1 >= len(words) {
+			return "", fmt.Errorf("missing value for %q: %q", words[i], line)
+		}
+		return words[i+1], nil
+	}
+	for i := 0; i < len(words); i++ {
+		var v string
+		switch words[i] {
+		case "hide":
+			r.Hide = true
+			continue
+		case "unhide":
+			r.Unhide = true
+			continue
+		case "include", "path", "type", "user", "group", "mode":
+			if v, err = arg(i); err != nil {
+				return r, err
+			}
+		default:
+			return r, fmt.Errorf("unknown keyword %q: %q", words[i], line)
+		}
+		switch words[i] {
+		case "include":
+			r.Include = strings.TrimPrefix(v, "$")
+		case "path":
+			r.Path = v
+		case "type":
+			switch v {
+			case "disk", "mem", "tape", "tty":
+			default:
+				return r, fmt.Errorf("unknown device type: %q", v)
+			}
+			r.Type = v
+		case "user":
+			r.User = v
+		case "group":
+			r.Group = v
+		case "mode":
+			if _, err := strconv.ParseUint(v, 8, 32); err != nil {
+				return r, fmt.Errorf("invalid mode: %q", v)
+			}
+			r.Mode = v
+		}
+		i++
+	}
+	switch {
+	case r.Include != "" && (r.Path != "" || r.Type != "" || r.hasAction()):
+		return r, fmt.Errorf("include cannot be combined with other keywords: %q", line)
+	case r.Include == "" && !r.hasAction():
+		return r, fmt.Errorf("rule without an action: %q", line)
+	case r.Hide && r.Unhide:
+		return r, fmt.Errorf("rule both hides and unhides: %q", line)
+	}
+	return r, nil
+}
+
+// Returns a ruleset by number
+func (r *Rules) Ruleset(number int) (Ruleset, bool) {
+	for _, rs := range r.Rulesets {
+		if rs.Number == number {
+			return rs, true
+		}
+	}
+	return Ruleset{}, false
+}
+
+// Returns a ruleset by name, or by number when name is numeric
+func (r *Rules) Lookup(name string) (Ruleset, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return r.Ruleset(n)
+	}
+	for _, rs := range r.Rulesets {
+		if rs.Name == name {
+			return rs, true
+		}
+	}
+	return Ruleset{}, false
+}
+
+// Reports the first problem found with the rulesets: a ruleset
+// number out of range, a name or number declared twice, or an
+// include of an unknown ruleset or of a ruleset that includes itself
+func (r *Rules) Validate() error {
+	names := make(map[string]bool, len(r.Rulesets))
+	numbers := make(map[int]bool, len(r.Rulesets))
+	for _, rs := range r.Rulesets {
+		switch {
+		case rs.Number < 1 || rs.Number > maxRuleset:
+			return fmt.Errorf("ruleset %s: number must be between 1 and %d: %d", rs.Name, maxRuleset, rs.Number)
+		case names[rs.Name]:
+			return fmt.Errorf("ruleset %s is declared more than once", rs.Name)
+		case numbers[rs.Number]:
+			return fmt.Errorf("ruleset number %d is declared more than once", rs.Number)
+		}
+		names[rs.Name], numbers[rs.Number] = true, true
+	}
+	for _, rs := range r.Rulesets {
+		if _, err := r.order(rs, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports an error when a devfs_ruleset value does not refer to a
+// declared ruleset. Ruleset 0 (no ruleset) is always valid.
+func (r *Rules) Check(number int32) error {
+	if number == 0 {
+		return nil
+	}
+	if _, ok := r.Ruleset(int(number)); !ok {
+		return fmt.Errorf("devfs ruleset %d is not declared", number)
+	}
+	return nil
+}
+
+// Reports an error when the devfs_ruleset of a jail does not refer
+// to a declared ruleset
+func (r *Rules) CheckJail(j *jail.Jail) error {
+	if err := r.Check(j.DevFSRuleset); err != nil {
+		return fmt.Errorf("jail %q: %w", j.Name, err)
+	}
+	return nil
+}
+
+// order returns a ruleset and the rulesets it includes, with each
+// included ruleset before the ruleset that includes it
+func (r *Rules) order(rs Ruleset, seen []string) ([]Ruleset, error) {
+	if slices.Contains(seen, rs.Name) {
+		return nil, fmt.Errorf("ruleset %s includes itself: %s", rs.Name, strings.Join(append(seen, rs.Name), " -> "))
+	}
+	var order []Ruleset
+	for _, rule := range rs.Rules {
+		if rule.Include == "" {
+			continue
+		}
+		inc, ok := r.Lookup(rule.Include)
+		if !ok {
+			return nil, fmt.Errorf("ruleset %s includes unknown ruleset %s", rs.Name, rule.Include)
+		}
+		incs, err := r.order(inc, append(seen, rs.Name))
+		if err != nil {
+			return nil, err
+		}
+		for _, inc := range incs {
+			if !slices.ContainsFunc(order, func(rs Ruleset) bool { return rs.Number == inc.Number }) {
+				order = append(order, inc)
+			}
+		}
+	}
+	return append(order, rs), nil
+}
+
+func (r Rule) hasAction() bool {
+	return r.Hide || r.Unhide || r.User != "" || r.Group != "" || r.Mode != ""
+}
+
+// parseHeader parses a "[name=number]" line
+func parseHeader(line string) (Ruleset, error) {
+	var rs Ruleset
+	if !strings.HasSuffix(line, "]") {
+		return rs, fmt.Errorf("unterminated ruleset header: %q", line)
+	}
+	name, number, ok := strings.Cut(line[1:len(line)-1], "=")
+	rs.Name = strings.TrimSpace(name)
+	if !ok || rs.Name == "" {
+		return rs, fmt.Errorf("expected [name=number]: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil {
+		return rs, fmt.Errorf("invalid ruleset number: %q", line)
+	}
+	rs.Number = n
+	return rs, nil
+}
+
+// split splits a line into words, with single or double quotes
+// around words that contain spaces or glob characters
+func split(line string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		quote byte
+		in    bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote: %q", line)
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/netip"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+func main() {
+	s := jail.NewSpec("tmp", "/tmp/jail")
+	s.Hostname = "tmp.local"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.5")}
+	s.SecureLevel = 3
+	s.Perms.AllowRoot = false
+	if _, err := jail.Create(s); err != nil {
+		panic(err)
+	}
+}
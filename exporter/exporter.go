@@ -0,0 +1,161 @@
+// Package exporter renders the state and resource usage of jails in
+// the Prometheus text format, for cmd/jail_exporter.
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Exporter collects metrics on every scrape. The zero value reads
+// jails through jail.All and their resource usage through rctl(8).
+type Exporter struct {
+	// Jails returns the jails to report (jail.All when nil)
+	Jails func() ([]*jail.Jail, error)
+	// Usage returns the rctl(8) usage of a jail, keyed by resource
+	// (Jail.Usage when nil)
+	Usage func(j *jail.Jail) (map[string]int64, error)
+}
+
+// metric is a metric family, and its samples
+type metric struct {
+	name    string
+	kind    string
+	help    string
+	samples []string
+}
+
+// usageMetrics maps rctl(8) resources to the metrics they are
+// reported as
+var usageMetrics = []struct {
+	resource string
+	metric   metric
+}{
+	{rctl.MemoryUse, metric{name: "jail_memory_bytes", kind: "gauge", help: "Resident memory used by the processes of the jail."}},
+	{rctl.CPUTime, metric{name: "jail_cpu_seconds_total", kind: "counter", help: "CPU time used by the processes of the jail."}},
+	{rctl.PCPU, metric{name: "jail_cpu_percent", kind: "gauge", help: "Percentage of a CPU used by the processes of the jail."}},
+	{rctl.MaxProc, metric{name: "jail_processes", kind: "gauge", help: "Number of processes in the jail."}},
+	{rctl.OpenFiles, metric{name: "jail_open_files", kind: "gauge", help: "Number of files opened by the processes of the jail."}},
+}
+
+// Writes the metrics of every jail in the Prometheus text format
+func (e *Exporter) Write(w io.Writer) error {
+	jails, err := e.jails()
+	if err != nil {
+		return err
+	}
+	sort.Slice(jails, func(a, b int) bool { return jails[a].ID < jails[b].ID })
+	var (
+		living, dying int
+		rctlUp        = 1
+		state         = metric{name: "jail_dying", kind: "gauge", help: "Whether the jail is dying (1) or living (0)."}
+		securelevel   = metric{name: "jail_securelevel", kind: "gauge", help: "The securelevel of the jail."}
+		childrenCur   = metric{name: "jail_children_current", kind: "gauge", help: "Number of child jails of the jail."}
+		childrenMax   = metric{name: "jail_children_max", kind: "gauge", help: "Maximum number of child jails of the jail."}
+		usage         = make([]metric, len(usageMetrics))
+	)
+	for i, u := range usageMetrics {
+		usage[i] = u.metric
+	}
+	for _, j := range jails {
+		l := labels(j)
+		if j.Dying {
+			dying++
+		} else {
+			living++
+		}
+		state.add(l, boolValue(j.Dying))
+		securelevel.add(l, int64(j.SecureLevel))
+		childrenCur.add(l, int64(j.ChildrenCur))
+		childrenMax.add(l, int64(j.ChildrenMax))
+		if j.Dying {
+			continue
+		}
+		used, err := e.usage(j)
+		if err != nil {
+			rctlUp = 0
+			continue
+		}
+		for i, u := range usageMetrics {
+			if v, ok := used[u.resource]; ok {
+				usage[i].add(l, v)
+			}
+		}
+	}
+	count := metric{name: "jail_count", kind: "gauge", help: "Number of jails, by state."}
+	count.samples = []string{
+		fmt.Sprintf("{state=\"living\"} %d", living),
+		fmt.Sprintf("{state=\"dying\"} %d", dying),
+	}
+	up := metric{name: "jail_rctl_up", kind: "gauge", help: "Whether the resource usage of every living jail could be read."}
+	up.samples = []string{" " + strconv.Itoa(rctlUp)}
+	bw := bufio.NewWriter(w)
+	for _, m := range append([]metric{count, state, securelevel, childrenCur, childrenMax, up}, usage...) {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Serves the metrics over HTTP
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var sb strings.Builder
+	if err := e.Write(&sb); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	io.WriteString(w, sb.String())
+}
+
+func (e *Exporter) jails() ([]*jail.Jail, error) {
+	if e.Jails == nil {
+		return jail.All()
+	}
+	return e.Jails()
+}
+
+func (e *Exporter) usage(j *jail.Jail) (map[string]int64, error) {
+	if e.Usage == nil {
+		return j.Usage()
+	}
+	return e.Usage(j)
+}
+
+func (m *metric) add(labels string, v int64) {
+	m.samples = append(m.samples, labels+" "+strconv.FormatInt(v, 10))
+}
+
+func (m metric) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, s := range m.samples {
+		fmt.Fprintf(w, "%s%s\n", m.name, s)
+	}
+}
+
+// labels returns the labels that identify a jail
+func labels(j *jail.Jail) string {
+	return fmt.Sprintf("{jid=\"%d\",name=\"%s\",hostname=\"%s\"}", j.ID, escape(j.Name), escape(j.Hostname))
+}
+
+// escape escapes a label value
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+// Package fsutil copies and removes directory trees for the packages
+// that build jail roots
+package fsutil
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CopyTree copies a directory tree with the modes and, when run as
+// root, the owners of its files. Symbolic links are copied, and
+// never followed. The tree does not cross mount points: a directory
+// on another filesystem than src (eg a devfs or a nullfs mount) is
+// copied empty, and other files on another filesystem are left out.
+func CopyTree(src, dst string) error {
+	type dirMode struct {
+		path string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+	owner := os.Geteuid() == 0
+	root, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		mounted := !SameDevice(root, fi)
+		switch {
+		case fi.IsDir():
+			if err := os.Mkdir(target, 0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMode{target, fi.Mode()})
+		case mounted:
+			return nil
+		case fi.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		if owner {
+			if err := lchown(target, fi); err != nil {
+				return err
+			}
+		}
+		// chown(2) clears the setuid and setgid bits: the mode is
+		// set after the owner
+		switch {
+		case fi.Mode().IsRegular():
+			return os.Chmod(target, perm(fi.Mode()))
+		case mounted:
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Directories are set last, as their mode could forbid creating
+	// their entries
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, perm(dirs[i].mode)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// SameDevice reports whether two files are on the same filesystem.
+// Files whose device is not known are taken to be on the same one.
+func SameDevice(a, b fs.FileInfo) bool {
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	sb, ok := b.Sys().(*syscall.Stat_t)
+	return !ok || sa.Dev == sb.Dev
+}
+
+func lchown(path string, fi fs.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Lchown(path, int(st.Uid), int(st.Gid))
+}
+
+// perm returns the permission bits of a mode, including the setuid,
+// setgid and sticky bits
+func perm(mode fs.FileMode) fs.FileMode {
+	return mode & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// RemoveAll removes a directory tree, including directories whose
+// mode forbids removing their entries (eg var/empty)
+func RemoveAll(path string) error {
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(p, 0o700)
+		}
+		return nil
+	})
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,217 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// IPAlias is an address of a jail that is added as an alias to a
+// host interface when the jail is created, and removed again when it
+// is destroyed. jail.conf(5) writes it as "iface|addr/prefix" in the
+// ip4.addr and ip6.addr parameters.
+type IPAlias struct {
+	// Interface is the host interface (eg em0). When empty, the
+	// Interface of the Spec is used.
+	Interface string       `json:"interface,omitempty"`
+	Prefix    netip.Prefix `json:"prefix"`
+}
+
+// Parses an address in the "[iface|]addr[/prefix]" syntax of jail.conf(5).
+// Without a prefix length, the address is a host address (/32 or /128).
+func ParseIPAlias(s string) (IPAlias, error) {
+	var a IPAlias
+	if i := strings.IndexByte(s, '|'); i != -1 {
+		a.Interface, s = s[:i], s[i+1:]
+		if a.Interface == "" {
+			return a, fmt.Errorf("missing interface: %q", s)
+		}
+	}
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return a, err
+		}
+		a.Prefix = p
+		return a, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return a, err
+	}
+	a.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+	return a, nil
+}
+
+// Returns the alias in the "[iface|]addr[/prefix]" syntax
+func (a IPAlias) String() string {
+	s := a.Prefix.String()
+	if a.Prefix.IsSingleIP() {
+		s = a.Prefix.Addr().String()
+	}
+	if a.Interface != "" {
+		return a.Interface + "|" + s
+	}
+	return s
+}
+
+// AliasDriver adds and removes the IP aliases of jails on host
+// interfaces. The default AliasDriver is IfconfigAliases, and it can
+// be replaced through SetAliasDriver.
+type AliasDriver interface {
+	AddAlias(iface string, addr netip.Prefix) error
+	RemoveAlias(iface string, addr netip.Prefix) error
+}
+
+var aliasDriver AliasDriver = IfconfigAliases{}
+
+// AliasLister is an AliasDriver that can list the aliases of the host
+// interfaces. NewPlan reads the aliases of existing jails through it.
+// Without it, the aliases of a jail are assumed to be those of its
+// Spec, for the addresses the jail has.
+type AliasLister interface {
+	Aliases() ([]IPAlias, error)
+}
+
+// Replace the AliasDriver used by the package, and return the previous one
+func SetAliasDriver(d AliasDriver) AliasDriver {
+	prev := aliasDriver
+	aliasDriver = d
+	return prev
+}
+
+// IfconfigAliases implements AliasDriver through ifconfig(8)
+type IfconfigAliases struct {
+	// Runner runs ifconfig(8) (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+func (i IfconfigAliases) AddAlias(iface string, addr netip.Prefix) error {
+	return i.ifconfig(iface, addr, "alias")
+}
+
+func (i IfconfigAliases) RemoveAlias(iface string, addr netip.Prefix) error {
+	return i.ifconfig(iface, addr, "-alias")
+}
+
+// Aliases reads the addresses of every interface from ifconfig(8)
+func (i IfconfigAliases) Aliases() ([]IPAlias, error) {
+	out, err := runner.Or(i.Runner).Run(exec.Command("ifconfig"))
+	if err != nil {
+		return nil, err
+	}
+	return parseIfconfig(string(out))
+}
+
+// parseIfconfig parses the output of ifconfig(8): a line that starts
+// an interface ("em0: flags=..."), followed by indented lines such as
+// "inet 10.0.0.4 netmask 0xffffff00" and "inet6 fd00::4 prefixlen 64"
+func parseIfconfig(out string) ([]IPAlias, error) {
+	var (
+		aliases []IPAlias
+		iface   string
+	)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		} else if line[0] != ' ' && line[0] != '\t' {
+			iface, _, _ = strings.Cut(line, ":")
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 4 || (f[0] != "inet" && f[0] != "inet6") {
+			continue
+		}
+		addr, err := netip.ParseAddr(f[1])
+		if err != nil {
+			return nil, fmt.Errorf("ifconfig: %s: %w", iface, err)
+		}
+		var ones int
+		switch {
+		case f[0] == "inet" && f[2] == "netmask":
+			mask, err := strconv.ParseUint(strings.TrimPrefix(f[3], "0x"), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("ifconfig: %s: invalid netmask: %s", iface, f[3])
+			}
+			ones = bits.OnesCount32(uint32(mask))
+		case f[0] == "inet6" && f[2] == "prefixlen":
+			if ones, err = strconv.Atoi(f[3]); err != nil {
+				return nil, fmt.Errorf("ifconfig: %s: invalid prefixlen: %s", iface, f[3])
+			}
+		default:
+			continue
+		}
+		prefix := netip.PrefixFrom(addr.WithZone(""), ones)
+		if !prefix.IsValid() {
+			return nil, fmt.Errorf("ifconfig: %s: invalid prefix length: %d", iface, ones)
+		}
+		aliases = append(aliases, IPAlias{Interface: iface, Prefix: prefix})
+	}
+	return aliases, nil
+}
+
+func (i IfconfigAliases) ifconfig(iface string, addr netip.Prefix, op string) error {
+	family := "inet"
+	if addr.Addr().Is6() {
+		family = "inet6"
+	}
+	_, err := runner.Or(i.Runner).Run(exec.Command("ifconfig", iface, family, addr.String(), op))
+	return err
+}
+
+// aliases returns the aliases of a Spec, with their interface
+// resolved: each entry of Aliases, and when the Spec has an
+// Interface, each address of IP4 and IP6 as a host address
+func (s Spec) aliases() []IPAlias {
+	var aliases []IPAlias
+	if s.Interface != "" {
+		for _, addr := range append(append([]netip.Addr{}, s.IP4...), s.IP6...) {
+			aliases = append(aliases, IPAlias{Interface: s.Interface, Prefix: netip.PrefixFrom(addr, addr.BitLen())})
+		}
+	}
+	for _, a := range s.Aliases {
+		if a.Interface == "" {
+			a.Interface = s.Interface
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases
+}
+
+// addAliases adds the aliases of a Spec. When an alias cannot be
+// added, the aliases added so far are removed again.
+func (s Spec) addAliases() error {
+	aliases := s.aliases()
+	for i, a := range aliases {
+		if err := aliasDriver.AddAlias(a.Interface, a.Prefix); err != nil {
+			return errors.Join(err, removeAliases(aliases[:i]))
+		}
+	}
+	return nil
+}
+
+// removeAliases removes aliases, and reports every failure
+func removeAliases(aliases []IPAlias) error {
+	var errs []error
+	for _, a := range aliases {
+		if err := aliasDriver.RemoveAlias(a.Interface, a.Prefix); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Removes a jail that was created from a Spec, along with the IP
+// aliases that were added for it and the base of its Template
+func Destroy(j *Jail, s Spec) error {
+	if err := j.Remove(); err != nil {
+		return err
+	}
+	return errors.Join(removeAliases(s.aliases()), unmount(s.templateMounts()))
+}
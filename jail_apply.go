@@ -0,0 +1,481 @@
+package jail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// Action is the kind of change a Step makes
+type Action int
+
+const (
+	// Create a jail that does not exist yet
+	ActionCreate Action = iota
+	// Update the parameters of a jail in place
+	ActionUpdate
+	// Remove a jail and create it again, because a parameter
+	// that cannot be updated has changed
+	ActionRecreate
+	// Remove a jail that is not part of the desired state
+	ActionRemove
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionCreate:
+		return "create"
+	case ActionUpdate:
+		return "update"
+	case ActionRecreate:
+		return "recreate"
+	case ActionRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("Action(%d)", int(a))
+	}
+}
+
+// Step is a single change within a Plan
+type Step struct {
+	Action Action
+	Name   string
+	// JID is the ID of the existing jail (not set for ActionCreate)
+	JID int32
+	// Dying is true when the existing jail is dying. It is not
+	// removed again, but what was set up for it on the host is.
+	Dying bool
+	// Spec is the desired state (not set for ActionRemove)
+	Spec    Spec
+	Changes []Change
+	// host is what is set up on the host for the existing jail
+	host jailHost
+}
+
+// Plan is the set of steps that bring the jails on a system to a
+// desired state. A Plan can be printed as a human-readable diff
+// before it is applied.
+type Plan struct {
+	Steps []Step
+}
+
+// Compares the desired jails with the jails reported by the kernel
+// and applies the difference. With prune, jails that are not part of
+// the desired state are removed, and left alone otherwise (see
+// NewPlan).
+func Apply(ctx context.Context, desired []Spec, prune bool) (Plan, error) {
+	p, err := NewPlan(desired, prune)
+	if err != nil {
+		return p, err
+	}
+	return p, p.Apply(ctx)
+}
+
+// Compares the desired jails with the jails reported by the kernel
+// and the host, and returns the steps needed to reach the desired
+// state without taking them. With prune, jails that are not part of
+// the desired state are removed. Jails are matched by name, and a
+// living jail takes a name over a dying one: a dying jail is
+// recreated, or removed, once what was set up for it on the host is
+// torn down.
+//
+// Besides the parameters of the kernel, the pseudo-parameters of a
+// Spec are compared with the host: its CPUSet (when it is not empty),
+// Limits, aliases (see AliasLister), Template and Mounts. Mounts in
+// the root of a jail that its Spec does not list are left alone, as
+// those of the jail itself (eg tmpfs on /tmp) cannot be told apart.
+// A jail whose securelevel would be lowered is recreated. Specs are
+// resolved first (see Spec.Resolve).
+func NewPlan(desired []Spec, prune bool) (Plan, error) {
+	var p Plan
+	jails, err := All()
+	if err != nil {
+		return p, err
+	}
+	current := make(map[string]*Jail, len(jails))
+	for _, j := range jails {
+		if c, ok := current[j.Name]; !ok || c.Dying {
+			current[j.Name] = j
+		}
+	}
+	h, err := readHost()
+	if err != nil {
+		return p, err
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, s := range desired {
+		if s.Name == "" {
+			return p, errors.New("spec without a name")
+		} else if err := s.Validate(); err != nil {
+			return p, fmt.Errorf("jail %q: %w", s.Name, err)
+		} else if wanted[s.Name] {
+			return p, fmt.Errorf("jail %q is specified more than once", s.Name)
+		}
+		wanted[s.Name] = true
+		rs, err := s.Resolve(context.Background(), nil)
+		if err != nil {
+			return p, fmt.Errorf("jail %q: %w", s.Name, err)
+		}
+		j, ok := current[s.Name]
+		if !ok {
+			p.Steps = append(p.Steps, Step{Action: ActionCreate, Name: s.Name, Spec: rs})
+			continue
+		}
+		jh, err := h.jail(j, rs)
+		if err != nil {
+			return p, fmt.Errorf("jail %q: %w", s.Name, err)
+		}
+		changes := append(DiffSpecs(j.Spec(), rs), jh.diff(rs)...)
+		if len(changes) == 0 && !j.Dying {
+			continue
+		}
+		step := Step{Action: ActionUpdate, Name: s.Name, JID: j.ID, Dying: j.Dying, Spec: rs, Changes: changes, host: jh}
+		if j.Dying {
+			step.Action = ActionRecreate
+		}
+		for i, c := range changes {
+			// The securelevel of a jail is only ever raised
+			if c.Param == "securelevel" && rs.SecureLevel < j.SecureLevel {
+				changes[i].Restart = true
+			}
+			if changes[i].Restart {
+				step.Action = ActionRecreate
+			}
+		}
+		p.Steps = append(p.Steps, step)
+	}
+	if prune {
+		var removals []Step
+		for _, j := range jails {
+			if wanted[j.Name] || current[j.Name] != j {
+				continue
+			}
+			jh, err := h.jail(j, Spec{})
+			if err != nil {
+				return p, fmt.Errorf("jail %q: %w", j.Name, err)
+			}
+			removals = append(removals, Step{Action: ActionRemove, Name: j.Name, JID: j.ID, Dying: j.Dying, host: jh})
+		}
+		// Children go first, since removing a jail removes its children
+		sort.SliceStable(removals, func(a, b int) bool {
+			return strings.Count(removals[a].Name, ".") > strings.Count(removals[b].Name, ".")
+		})
+		p.Steps = append(p.Steps, removals...)
+	}
+	return p, nil
+}
+
+// Takes the steps of a Plan. A failed step does not prevent the
+// remaining steps from being taken. The returned error is a BulkError
+// when one or more steps failed.
+func (p Plan) Apply(ctx context.Context) error {
+	errs := BulkError{}
+	for _, step := range p.Steps {
+		if err := ctx.Err(); err != nil {
+			errs[step.Name] = err
+			continue
+		}
+		if err := step.apply(); err != nil {
+			errs[step.Name] = err
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (s Step) apply() error {
+	switch s.Action {
+	case ActionCreate:
+		return s.create()
+	case ActionUpdate:
+		return s.update()
+	case ActionRecreate:
+		if err := s.remove(); err != nil {
+			return err
+		}
+		return s.create()
+	case ActionRemove:
+		return s.remove()
+	default:
+		return fmt.Errorf("unknown action: %v", s.Action)
+	}
+}
+
+// create creates the jail of the Spec, and mounts its Mounts in its
+// root
+func (s Step) create() error {
+	j, err := Create(s.Spec)
+	if err != nil {
+		return err
+	}
+	if err := mount(s.Spec.Mounts); err != nil {
+		if len(s.Spec.Limits) > 0 {
+			err = errors.Join(err, rctl.Remove(s.Spec.subject()))
+		}
+		return errors.Join(err, Destroy(j, s.Spec))
+	}
+	return nil
+}
+
+// update updates the parameters of a living jail, and what is set up
+// for it on the host
+func (s Step) update() error {
+	changed := make(map[string]bool, len(s.Changes))
+	for _, c := range s.Changes {
+		changed[c.Param] = true
+	}
+	if params := s.Spec.changed(s.JID, s.Changes); len(params) > 1 {
+		if _, err := Set(params, UpdateFlag); err != nil {
+			return err
+		}
+	}
+	if changed["cpuset"] {
+		j := &Jail{ID: s.JID, Name: s.Name}
+		if err := j.SetCPUSet(s.Spec.CPUSet); err != nil {
+			return err
+		}
+	}
+	if changed["rctl"] {
+		j := &Jail{ID: s.JID, Name: s.Name}
+		if err := j.SetLimits(s.Spec.Limits); err != nil {
+			return err
+		}
+	}
+	if changed["alias"] {
+		want := s.Spec.aliases()
+		if err := removeAliases(missing(s.host.aliases, want)); err != nil {
+			return err
+		}
+		for _, a := range missing(want, s.host.aliases) {
+			if err := aliasDriver.AddAlias(a.Interface, a.Prefix); err != nil {
+				return err
+			}
+		}
+	}
+	if changed["mount"] {
+		return mount(missing(s.Spec.Mounts, s.host.mounts))
+	}
+	return nil
+}
+
+// remove removes the existing jail, unless it is dying, and tears
+// down what was set up for it on the host
+func (s Step) remove() error {
+	if !s.Dying {
+		if err := Remove(s.JID); err != nil {
+			return err
+		}
+	}
+	return s.host.tearDown(s.Name)
+}
+
+// jailHost is what is set up on the host for an existing jail
+type jailHost struct {
+	cpuset []int
+	limits []rctl.Rule
+	// aliases are the aliases of the addresses of the jail
+	aliases []IPAlias
+	// template is the template whose base is mounted in the root,
+	// and templateMounts are its mounts
+	template       string
+	templateMounts []Mount
+	// mounts are the Mounts of the Spec that are mounted
+	mounts []Mount
+}
+
+// hostState is the state of the host, read once for a Plan
+type hostState struct {
+	mounts []Mount
+	// aliases are the aliases of the host interfaces, or nil when
+	// the AliasDriver is not an AliasLister
+	aliases []IPAlias
+	listed  bool
+}
+
+func readHost() (hostState, error) {
+	var (
+		h   hostState
+		err error
+	)
+	if h.mounts, err = mountDriver.Mounts(); err != nil {
+		return h, err
+	}
+	if l, ok := aliasDriver.(AliasLister); ok {
+		if h.aliases, err = l.Aliases(); err != nil {
+			return h, err
+		}
+		h.listed = true
+	}
+	return h, nil
+}
+
+// jail reads what is set up on the host for a jail. s is the desired
+// state of the jail, or the zero Spec when it is to be removed.
+func (h hostState) jail(j *Jail, s Spec) (jailHost, error) {
+	var (
+		jh  jailHost
+		err error
+	)
+	if len(s.CPUSet) > 0 && !j.Dying {
+		if jh.cpuset, err = j.CPUSet(); err != nil {
+			return jh, err
+		}
+	}
+	// rctl(8) fails when RACCT is disabled, and no rule can then
+	// exist: it is only required by a Spec with Limits
+	if jh.limits, err = j.Limits(); err != nil && len(s.Limits) > 0 {
+		return jh, err
+	}
+	addrs := append(slices.Clone(j.IP4), j.IP6...)
+	candidates := h.aliases
+	if !h.listed {
+		candidates = s.aliases()
+	}
+	for _, a := range candidates {
+		if slices.Contains(addrs, a.Prefix.Addr()) {
+			jh.aliases = append(jh.aliases, a)
+		}
+	}
+	if j.Path != "" {
+		jh.template = h.template(j.Path)
+		jh.templateMounts = Spec{Path: j.Path, Template: jh.template}.templateMounts()
+	}
+	for _, m := range s.Mounts {
+		if h.mounted(m) {
+			jh.mounts = append(jh.mounts, m)
+		}
+	}
+	return jh, nil
+}
+
+// template returns the template whose base is mounted in a root, or
+// "" when there is none
+func (h hostState) template(root string) string {
+	for _, m := range h.mounts {
+		rel, err := filepath.Rel(TemplateDir, m.Source)
+		if m.FSType != "nullfs" || err != nil || !within(m.Source, TemplateDir) {
+			continue
+		}
+		name, dir, _ := strings.Cut(rel, string(filepath.Separator))
+		if slices.Contains(TemplateBase, dir) && filepath.Clean(m.Target) == filepath.Join(root, dir) {
+			return name
+		}
+	}
+	return ""
+}
+
+// mounted reports whether a filesystem is mounted
+func (h hostState) mounted(m Mount) bool {
+	return slices.ContainsFunc(h.mounts, func(hm Mount) bool {
+		return hm.Source == m.Source && hm.FSType == m.FSType && filepath.Clean(hm.Target) == filepath.Clean(m.Target)
+	})
+}
+
+// diff returns the pseudo-parameters of a Spec that differ from what
+// is set up on the host
+func (jh jailHost) diff(s Spec) []Change {
+	var changes []Change
+	if len(s.CPUSet) > 0 {
+		if old, cur := FormatCPUList(jh.cpuset), FormatCPUList(s.CPUSet); old != cur {
+			changes = append(changes, Change{Kind: Changed, Param: "cpuset", Old: old, New: cur})
+		}
+	}
+	changes = append(changes, diffList("rctl", jh.limits, s.limits())...)
+	changes = append(changes, diffList("alias", jh.aliases, s.aliases())...)
+	if jh.template != s.Template {
+		c := Change{Kind: Changed, Param: "template", Old: jh.template, New: s.Template, Restart: true}
+		if jh.template == "" {
+			c.Kind = Added
+		} else if s.Template == "" {
+			c.Kind = Removed
+		}
+		changes = append(changes, c)
+	}
+	return append(changes, diffList("mount", jh.mounts, s.Mounts)...)
+}
+
+// diffList compares two lists element by element
+func diffList[T fmt.Stringer](name string, a, b []T) []Change {
+	var changes []Change
+	for _, v := range a {
+		if !slices.ContainsFunc(b, func(w T) bool { return w.String() == v.String() }) {
+			changes = append(changes, Change{Kind: Removed, Param: name, Old: v.String()})
+		}
+	}
+	for _, v := range b {
+		if !slices.ContainsFunc(a, func(w T) bool { return w.String() == v.String() }) {
+			changes = append(changes, Change{Kind: Added, Param: name, New: v.String()})
+		}
+	}
+	return changes
+}
+
+// missing returns the elements of a that are not in b
+func missing[T comparable](a, b []T) []T {
+	var m []T
+	for _, v := range a {
+		if !slices.Contains(b, v) {
+			m = append(m, v)
+		}
+	}
+	return m
+}
+
+// tearDown removes what was set up on the host for a jail that has
+// been removed, and reports every failure
+func (jh jailHost) tearDown(name string) error {
+	var errs []error
+	if len(jh.limits) > 0 {
+		errs = append(errs, rctl.Remove(Spec{Name: name}.subject()))
+	}
+	errs = append(errs, removeAliases(jh.aliases), unmount(jh.mounts), unmount(jh.templateMounts))
+	return errors.Join(errs...)
+}
+
+// Returns the Plan as a human-readable diff
+func (p Plan) String() string {
+	var sb strings.Builder
+	for _, step := range p.Steps {
+		switch step.Action {
+		case ActionCreate:
+			fmt.Fprintf(&sb, "+ %s (create)\n", step.Name)
+			for _, param := range step.Spec.params() {
+				if param.value != nil && !param.implied && param.text != "" && param.name != "name" {
+					fmt.Fprintf(&sb, "    + %s = %q\n", param.name, param.text)
+				}
+			}
+		case ActionUpdate, ActionRecreate:
+			mark := "~"
+			if step.Action == ActionRecreate {
+				mark = "-/+"
+			}
+			state := ""
+			if step.Dying {
+				state = ", dying"
+			}
+			fmt.Fprintf(&sb, "%s %s (%s, jid %d%s)\n", mark, step.Name, step.Action, step.JID, state)
+			for _, c := range step.Changes {
+				sb.WriteString("    " + c.String())
+				if c.Restart {
+					sb.WriteString(" (forces recreate)")
+				}
+				sb.WriteString("\n")
+			}
+		case ActionRemove:
+			state := ""
+			if step.Dying {
+				state = ", dying"
+			}
+			fmt.Fprintf(&sb, "- %s (remove, jid %d%s)\n", step.Name, step.JID, state)
+		}
+	}
+	return sb.String()
+}
@@ -8,6 +8,11 @@ import (
 
 // Attach the current proccess to a jail
 func Attach(jid int32) error {
+	return kernel.Attach(jid)
+}
+
+// jail_attach(2)
+func attach(jid int32) error {
 	_, _, e1 := unix.Syscall(uintptr(sysJailAttach), uintptr(jid), 0, 0)
 	if e1 != 0 {
 		switch int(e1) {
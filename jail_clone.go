@@ -0,0 +1,153 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"git.hardenedbsd.org/0x1eef/jail/internal/fsutil"
+)
+
+// RootDriver copies the root of a jail for Clone: the root of the
+// source jail is snapshotted, and the root of the clone is created
+// from the snapshot. Roots are named after their jail. storage.ZFS
+// and storage.Dir implement RootDriver, and it can be set through
+// SetRootDriver. When none is set, Clone copies the root directory.
+type RootDriver interface {
+	Snapshot(name, snapshot string) error
+	// DestroySnapshot destroys a snapshot that no root is cloned from
+	DestroySnapshot(name, snapshot string) error
+	// Clone returns the path of the new root
+	Clone(src, snapshot, name string) (string, error)
+	Destroy(name string) error
+}
+
+var rootDriver RootDriver
+
+// Replace the RootDriver used by Clone, and return the previous one
+func SetRootDriver(d RootDriver) RootDriver {
+	prev := rootDriver
+	rootDriver = d
+	return prev
+}
+
+// Creates a copy of a jail. The clone has the parameters of src,
+// with the Name, Hostname, addresses and pseudo-parameters of
+// overrides: the Hostname defaults to the Name, and the clone has no
+// address unless overrides has some. The root of src is copied to
+// the Path of overrides (a sibling of the root of src when empty),
+// or cloned through the RootDriver, whose path is then used instead.
+// A thin jail is cloned as a thin jail of the same Template. The
+// copy of the root is removed when the clone cannot be created.
+func Clone(src *Jail, overrides Spec) (*Jail, error) {
+	if overrides.Name == "" {
+		return nil, errors.New("clone: a name is required")
+	} else if overrides.Name == src.Name {
+		return nil, fmt.Errorf("clone: the clone must not be named after its source: %s", src.Name)
+	}
+	s := src.Spec()
+	s.Name = overrides.Name
+	s.Hostname = overrides.Hostname
+	if s.Hostname == "" {
+		s.Hostname = overrides.Name
+	}
+	s.IP4, s.IP6 = overrides.IP4, overrides.IP6
+	s.Interface, s.Aliases, s.IPHostname = overrides.Interface, overrides.Aliases, overrides.IPHostname
+	s.CPUSet, s.Limits = overrides.CPUSet, overrides.Limits
+	s.Mounts, s.Datasets, s.Exec = overrides.Mounts, overrides.Datasets, overrides.Exec
+	s.Template = overrides.Template
+	if s.Template == "" {
+		template, err := templateOf(src)
+		if err != nil {
+			return nil, err
+		}
+		s.Template = template
+	}
+	path, cleanup, err := cloneRoot(src, s.Name, overrides.Path, s.Template != "")
+	if err != nil {
+		return nil, err
+	}
+	s.Path = path
+	j, err := Create(s)
+	if err != nil {
+		return nil, errors.Join(err, cleanup())
+	}
+	return j, nil
+}
+
+// cloneRoot copies the root of a jail, and returns its path with a
+// function that removes it again, along with the snapshot it was
+// cloned from. The base of a thin jail and the filesystems mounted in
+// the root are left out of the copy, and replaced by empty mount
+// points.
+func cloneRoot(src *Jail, name, path string, thin bool) (string, func() error, error) {
+	if rootDriver != nil {
+		if err := rootDriver.Snapshot(src.Name, name); err != nil {
+			return "", nil, err
+		}
+		p, err := rootDriver.Clone(src.Name, name, name)
+		if err != nil {
+			return "", nil, errors.Join(err, rootDriver.DestroySnapshot(src.Name, name))
+		}
+		return p, func() error {
+			if err := rootDriver.Destroy(name); err != nil {
+				return err
+			}
+			return rootDriver.DestroySnapshot(src.Name, name)
+		}, nil
+	}
+	if path == "" {
+		path = filepath.Join(filepath.Dir(src.Path), name)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", nil, fmt.Errorf("clone: root already exists: %s", path)
+	}
+	cleanup := func() error { return fsutil.RemoveAll(path) }
+	entries, err := os.ReadDir(src.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	fi, err := os.Stat(src.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(path, fi.Mode().Perm()); err != nil {
+		return "", nil, err
+	}
+	for _, e := range entries {
+		target := filepath.Join(path, e.Name())
+		efi, err := os.Lstat(filepath.Join(src.Path, e.Name()))
+		if err != nil {
+			return "", nil, errors.Join(err, cleanup())
+		}
+		switch {
+		case thin && slices.Contains(TemplateBase, e.Name()), efi.IsDir() && !fsutil.SameDevice(fi, efi):
+			err = os.Mkdir(target, 0o755)
+		case !fsutil.SameDevice(fi, efi):
+			continue
+		default:
+			err = fsutil.CopyTree(filepath.Join(src.Path, e.Name()), target)
+		}
+		if err != nil {
+			return "", nil, errors.Join(err, cleanup())
+		}
+	}
+	return path, cleanup, nil
+}
+
+// templateOf returns the name of the template whose base is mounted
+// in the root of a jail, or "" for a jail that is not thin
+func templateOf(j *Jail) (string, error) {
+	templates, err := Templates()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range templates {
+		if slices.Contains(t.Jails, j.Name) {
+			return t.Name, nil
+		}
+	}
+	return "", nil
+}
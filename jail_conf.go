@@ -0,0 +1,512 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Config represents the jails described by a jail.conf(5) file.
+// Parameters set outside of a jail block (or within a "*" block)
+// are merged into every jail, and variables are expanded.
+type Config struct {
+	Jails []ConfigJail
+}
+
+// ConfigJail represents a single jail block
+type ConfigJail struct {
+	Name   string
+	Params []ConfigParam
+}
+
+// ConfigParam represents a single parameter. A parameter without
+// values is a boolean parameter that has been set (eg "persist;").
+type ConfigParam struct {
+	Name   string
+	Values []string
+}
+
+// Read and parse a jail.conf(5) file
+func ReadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// Parse the jail.conf(5) format
+func ParseConfig(r io.Reader) (*Config, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &confParser{src: string(b), line: 1}
+	return p.parse()
+}
+
+// Returns a jail by name
+func (c *Config) Jail(name string) (ConfigJail, bool) {
+	for _, j := range c.Jails {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return ConfigJail{}, false
+}
+
+// Returns the values of a parameter
+func (j ConfigJail) Values(name string) []string {
+	for _, p := range j.Params {
+		if p.Name == name {
+			return p.Values
+		}
+	}
+	return nil
+}
+
+// Returns the value of a parameter, with list values joined by a comma
+func (j ConfigJail) Get(name string) (string, bool) {
+	for _, p := range j.Params {
+		if p.Name == name {
+			return strings.Join(p.Values, ","), true
+		}
+	}
+	return "", false
+}
+
+// Returns the names of the jails a jail depends on
+func (j ConfigJail) Depend() []string {
+	var deps []string
+	for _, v := range j.Values("depend") {
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				deps = append(deps, d)
+			}
+		}
+	}
+	return deps
+}
+
+// Writes the config in the jail.conf(5) format, with a block for
+// each jail. Values are quoted when needed.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	for i, j := range c.Jails {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "%s {\n", quoteConf(j.Name))
+		for _, p := range j.Params {
+			sb.WriteString("\t" + p.Name)
+			for n, v := range p.Values {
+				if n == 0 {
+					sb.WriteString(" = ")
+				} else {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(quoteConf(v))
+			}
+			sb.WriteString(";\n")
+		}
+		sb.WriteString("}\n")
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// quoteConf quotes a value for jail.conf(5) when it is empty or
+// contains characters the parser would treat specially. Single
+// quotes are preferred for values with a "$", since they are not
+// subject to variable expansion.
+func quoteConf(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\r\n{};,=\"'#$\\+") && !strings.HasPrefix(v, "/*") && !strings.HasPrefix(v, "//") {
+		return v
+	}
+	if strings.Contains(v, "$") && !strings.Contains(v, "'") {
+		return "'" + v + "'"
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "$", "\\$")
+	return "\"" + r.Replace(v) + "\""
+}
+
+// set assigns (or with add, appends to) a parameter
+func (j *ConfigJail) set(name string, values []confValue, add bool) {
+	vs := make([]string, 0, len(values))
+	for _, v := range values {
+		vs = append(vs, v.s)
+	}
+	for i, p := range j.Params {
+		if p.Name == name {
+			if add {
+				j.Params[i].Values = append(append([]string{}, p.Values...), vs...)
+			} else {
+				j.Params[i].Values = vs
+			}
+			return
+		}
+	}
+	j.Params = append(j.Params, ConfigParam{Name: name, Values: vs})
+}
+
+// confValue is a value as it appears in the file. Single-quoted
+// values are not subject to variable expansion. In other values, a
+// "$" or "\\" that was escaped is kept behind a backslash, so that
+// expandVars does not take it for the start of a variable.
+type confValue struct {
+	s      string
+	expand bool
+}
+
+// text returns the value with its escapes removed, for names that
+// are not subject to variable expansion
+func (v confValue) text() string {
+	if !v.expand || !strings.Contains(v.s, "\\") {
+		return v.s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v.s); i++ {
+		if v.s[i] == '\\' && i+1 < len(v.s) {
+			i++
+		}
+		sb.WriteByte(v.s[i])
+	}
+	return sb.String()
+}
+
+// writeEscaped writes a character of a value subject to variable
+// expansion, keeping a "$" or "\\" behind a backslash (see confValue)
+func writeEscaped(sb *strings.Builder, c byte) {
+	if c == '$' || c == '\\' {
+		sb.WriteByte('\\')
+	}
+	sb.WriteByte(c)
+}
+
+type confStmt struct {
+	name   string
+	values []confValue
+	add    bool
+}
+
+type confBlock struct {
+	name  string
+	stmts []confStmt
+}
+
+type confParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *confParser) parse() (*Config, error) {
+	var (
+		globals []confStmt
+		blocks  []confBlock
+	)
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == nil {
+			break
+		}
+		if tok.punct != 0 {
+			return nil, p.errorf("unexpected %q", string(tok.punct))
+		}
+		nt, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if nt != nil && nt.punct == '{' {
+			stmts, err := p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+			if name := tok.value.text(); name == "*" {
+				globals = append(globals, stmts...)
+			} else {
+				blocks = append(blocks, confBlock{name: name, stmts: stmts})
+			}
+			continue
+		}
+		stmt, err := p.parseStmt(tok, nt)
+		if err != nil {
+			return nil, err
+		}
+		globals = append(globals, stmt)
+	}
+	c := &Config{Jails: make([]ConfigJail, 0, len(blocks))}
+	for _, b := range blocks {
+		j := ConfigJail{Name: b.name}
+		stmts := append(append([]confStmt{}, globals...), b.stmts...)
+		if err := j.expand(stmts); err != nil {
+			return nil, err
+		}
+		c.Jails = append(c.Jails, j)
+	}
+	return c, nil
+}
+
+func (p *confParser) parseBlock() ([]confStmt, error) {
+	var stmts []confStmt
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == nil {
+			return nil, p.errorf("unexpected end of file, expected \"}\"")
+		}
+		if tok.punct == '}' {
+			return stmts, nil
+		}
+		if tok.punct != 0 {
+			return nil, p.errorf("unexpected %q", string(tok.punct))
+		}
+		nt, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := p.parseStmt(tok, nt)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+// parseStmt parses "name;", "name = v[, v...];" and "name += v[, v...];"
+// with name and the token that follows it already consumed
+func (p *confParser) parseStmt(name, tok *confToken) (confStmt, error) {
+	stmt := confStmt{name: name.value.text()}
+	if tok == nil {
+		return stmt, p.errorf("unexpected end of file, expected \";\"")
+	}
+	switch {
+	case tok.punct == ';':
+		return stmt, nil
+	case tok.punct == '=':
+	case tok.punct == '+':
+		p.pos++
+		stmt.add = true
+	default:
+		return stmt, p.errorf("expected \"=\" or \";\" after %q", stmt.name)
+	}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return stmt, err
+		}
+		if tok == nil || tok.punct != 0 {
+			return stmt, p.errorf("expected a value for %q", stmt.name)
+		}
+		stmt.values = append(stmt.values, tok.value)
+		tok, err = p.next()
+		if err != nil {
+			return stmt, err
+		}
+		if tok == nil {
+			return stmt, p.errorf("unexpected end of file, expected \";\"")
+		}
+		switch tok.punct {
+		case ';':
+			return stmt, nil
+		case ',':
+			continue
+		default:
+			return stmt, p.errorf("expected \",\" or \";\" after value of %q", stmt.name)
+		}
+	}
+}
+
+type confToken struct {
+	punct byte
+	value confValue
+}
+
+// next returns the next token, or nil at the end of input
+func (p *confParser) next() (*confToken, error) {
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.src) {
+		return nil, nil
+	}
+	c := p.src[p.pos]
+	switch c {
+	case '{', '}', ';', ',', '=':
+		p.pos++
+		return &confToken{punct: c}, nil
+	case '+':
+		if p.pos+1 < len(p.src) && p.src[p.pos+1] == '=' {
+			p.pos++
+			return &confToken{punct: c}, nil
+		}
+	case '"', '\'':
+		return p.quoted(c)
+	}
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if isSpace(c) || strings.IndexByte("{};,=\"'", c) >= 0 {
+			break
+		}
+		if c == '+' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '=' {
+			break
+		}
+		p.pos++
+		if c == '\\' && p.pos < len(p.src) {
+			writeEscaped(&sb, p.src[p.pos])
+			p.pos++
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return &confToken{value: confValue{s: sb.String(), expand: true}}, nil
+}
+
+func (p *confParser) quoted(q byte) (*confToken, error) {
+	var sb strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == q:
+			return &confToken{value: confValue{s: sb.String(), expand: q == '"'}}, nil
+		case c == '\n':
+			p.line++
+		case c == '\\' && q == '"' && p.pos < len(p.src):
+			c = p.src[p.pos]
+			p.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case '\n':
+				p.line++
+				continue
+			}
+			writeEscaped(&sb, c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+// skip skips whitespace and comments
+func (p *confParser) skip() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case isSpace(c):
+			p.pos++
+		case c == '#' || strings.HasPrefix(p.src[p.pos:], "//"):
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end == -1 {
+				return p.errorf("unterminated comment")
+			}
+			p.line += strings.Count(p.src[p.pos:p.pos+2+end], "\n")
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *confParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("jail.conf: line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// expand expands $param and ${param} references in the values of a
+// jail. The values are re-derived from the statements because only
+// they know which values were single-quoted. A reference resolves to
+// the expanded value of the parameter, as set by the statements that
+// come before it.
+func (j *ConfigJail) expand(stmts []confStmt) error {
+	expanded := ConfigJail{Name: j.Name}
+	lookup := func(name string) (string, error) {
+		if name == "name" {
+			return j.Name, nil
+		}
+		if v, ok := expanded.Get(name); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("jail.conf: jail %q: unknown variable %q", j.Name, name)
+	}
+	for _, s := range stmts {
+		values := make([]confValue, 0, len(s.values))
+		for _, v := range s.values {
+			if v.expand {
+				s, err := expandVars(v.s, lookup)
+				if err != nil {
+					return err
+				}
+				v.s = s
+			}
+			values = append(values, v)
+		}
+		expanded.set(s.name, values, s.add)
+	}
+	j.Params = expanded.Params
+	return nil
+}
+
+func expandVars(s string, lookup func(string) (string, error)) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			sb.WriteByte(s[i])
+			continue
+		}
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		var name string
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return "", errors.New("jail.conf: unterminated variable in " + s)
+			}
+			name, i = s[i+2:i+end], i+end
+		} else {
+			n := i + 1
+			for n < len(s) && isVarChar(s[n]) {
+				n++
+			}
+			name, i = s[i+1:n], n-1
+		}
+		v, err := lookup(name)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(v)
+	}
+	return sb.String(), nil
+}
+
+func isVarChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
@@ -0,0 +1,155 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cpuMask is a cpuset_t of cpuSetSize CPUs
+type cpuMask [cpuSetSize / 64]uint64
+
+// CPUSetKernel is implemented by a Kernel that can restrict jails to
+// a set of CPUs. The default Kernel implements it; with a Kernel that
+// does not (see SetKernel), the CPUs of a jail cannot be read or set.
+type CPUSetKernel interface {
+	// cpuset_getaffinity(2), for the CPUs of a jail
+	GetCPUSet(jid int32) ([]int, error)
+	// cpuset_setaffinity(2), for the CPUs of a jail
+	SetCPUSet(jid int32, cpus []int) error
+}
+
+// Returns the CPUs the jail may run on
+func (j *Jail) CPUSet() ([]int, error) {
+	k, err := cpuSetKernel()
+	if err != nil {
+		return nil, err
+	}
+	return k.GetCPUSet(j.ID)
+}
+
+// Restricts the jail to a set of CPUs, as cpuset(1) does with -j
+func (j *Jail) SetCPUSet(cpus []int) error {
+	if err := validateCPUs(cpus); err != nil {
+		return err
+	}
+	k, err := cpuSetKernel()
+	if err != nil {
+		return err
+	}
+	return k.SetCPUSet(j.ID, cpus)
+}
+
+// cpuSetKernel returns the Kernel as a CPUSetKernel
+func cpuSetKernel() (CPUSetKernel, error) {
+	if k, ok := kernel.(CPUSetKernel); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("cpuset: %w by the kernel", errors.ErrUnsupported)
+}
+
+// Parses a list of CPUs in the syntax of cpuset(1) (eg "0-3,6").
+// The returned CPUs are sorted, without duplicates.
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil || lo < 0 {
+			return nil, fmt.Errorf("invalid CPU list: %q", s)
+		}
+		hi := lo
+		if isRange {
+			hi, err = strconv.Atoi(last)
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid CPU list: %q", s)
+			}
+		}
+		if hi >= cpuSetSize {
+			return nil, fmt.Errorf("CPU %d is out of range", hi)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+// Formats a list of CPUs in the syntax of cpuset(1), with
+// consecutive CPUs written as a range (eg "0-3,6")
+func FormatCPUList(cpus []int) string {
+	cpus = slices.Compact(slices.Sorted(slices.Values(cpus)))
+	var parts []string
+	for i := 0; i < len(cpus); {
+		n := i
+		for n+1 < len(cpus) && cpus[n+1] == cpus[n]+1 {
+			n++
+		}
+		if n == i {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[n]))
+		}
+		i = n + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func validateCPUs(cpus []int) error {
+	if len(cpus) == 0 {
+		return errors.New("cpuset: at least one CPU is required")
+	}
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= cpuSetSize {
+			return fmt.Errorf("cpuset: CPU %d is out of range", cpu)
+		}
+	}
+	return nil
+}
+
+func (sysKernel) GetCPUSet(jid int32) ([]int, error) {
+	return getAffinity(jid)
+}
+
+func (sysKernel) SetCPUSet(jid int32, cpus []int) error {
+	return setAffinity(jid, cpus)
+}
+
+// cpuset_getaffinity(2)
+func getAffinity(jid int32) ([]int, error) {
+	var mask cpuMask
+	_, _, e1 := unix.Syscall6(uintptr(sysCpusetGetAffinity), cpuLevelWhich, cpuWhichJail, uintptr(jid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)), 0)
+	runtime.KeepAlive(&mask)
+	if e1 != 0 {
+		return nil, fmt.Errorf("cpuset_getaffinity: %w", e1)
+	}
+	var cpus []int
+	for cpu := 0; cpu < cpuSetSize; cpu++ {
+		if mask[cpu/64]&(1<<(cpu%64)) != 0 {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// cpuset_setaffinity(2)
+func setAffinity(jid int32, cpus []int) error {
+	var mask cpuMask
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, e1 := unix.Syscall6(uintptr(sysCpusetSetAffinity), cpuLevelWhich, cpuWhichJail, uintptr(jid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)), 0)
+	runtime.KeepAlive(&mask)
+	if e1 != 0 {
+		return fmt.Errorf("cpuset_setaffinity: %w", e1)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package jail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// maxHostnameLen is MAXHOSTNAMELEN from sys/param.h, minus the
+// terminating NUL
+const maxHostnameLen = 255
+
+// maxOSReleaseLen is OSRELEASELEN from sys/jail.h, minus the
+// terminating NUL
+const maxOSReleaseLen = 31
+
+// maxHostUUIDLen is HOSTUUIDLEN from sys/sysctl.h, minus the
+// terminating NUL
+const maxHostUUIDLen = 63
+
+// maxDevFSRuleset is the largest devfs ruleset number
+const maxDevFSRuleset = 65535
+
+// Creates a fully configured jail through a single jail_set(2) call,
+// so the jail never exists in a half-configured state. The Spec is
+// resolved first: with IPHostname, the jail also has the addresses
+// its hostname resolves to (see Spec.Resolve).
+func Create(s Spec) (*Jail, error) {
+	return create(s, false)
+}
+
+// Creates a fully configured jail through a single jail_set(2) call,
+// and attaches the current process to it. The process is attached
+// last, once the CPUs and limits of the jail are set and the jail is
+// read back: from within the jail, the jail itself cannot be read.
+func CreateAndAttach(s Spec) (*Jail, error) {
+	return create(s, true)
+}
+
+func create(s Spec, attach bool) (*Jail, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	s, err := s.Resolve(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	mounts := s.templateMounts()
+	if err := mount(mounts); err != nil {
+		return nil, err
+	}
+	if err := s.addAliases(); err != nil {
+		return nil, errors.Join(err, unmount(mounts))
+	}
+	jid, err := Set(s.Params(), CreateFlag)
+	if err != nil {
+		return nil, errors.Join(err, removeAliases(s.aliases()), unmount(mounts))
+	}
+	if len(s.CPUSet) > 0 {
+		j := &Jail{ID: jid, Name: s.Name}
+		if err := j.SetCPUSet(s.CPUSet); err != nil {
+			return nil, errors.Join(err, Remove(jid), removeAliases(s.aliases()), unmount(mounts))
+		}
+	}
+	limits := s.limits()
+	for i, r := range limits {
+		if err := rctl.Add(r); err != nil {
+			if i > 0 {
+				err = errors.Join(err, rctl.Remove(rctl.Rule{Subject: r.Subject, SubjectID: r.SubjectID}))
+			}
+			return nil, errors.Join(err, Remove(jid), removeAliases(s.aliases()), unmount(mounts))
+		}
+	}
+	j, err := FindByID(jid)
+	if err == nil && attach {
+		err = Attach(jid)
+	}
+	if err != nil {
+		if len(limits) > 0 {
+			err = errors.Join(err, rctl.Remove(rctl.Rule{Subject: limits[0].Subject, SubjectID: limits[0].SubjectID}))
+		}
+		return nil, errors.Join(err, Remove(jid), removeAliases(s.aliases()), unmount(mounts))
+	}
+	return j, nil
+}
+
+// Validate reports the first problem found with a Spec, before it is
+// sent to the kernel
+func (s Spec) Validate() error {
+	switch {
+	case s.Path == "":
+		return errors.New("spec: path is required")
+	case !filepath.IsAbs(s.Path):
+		return fmt.Errorf("spec: path must be absolute: %s", s.Path)
+	case len(s.Hostname) > maxHostnameLen:
+		return fmt.Errorf("spec: hostname is longer than %d bytes", maxHostnameLen)
+	case s.SecureLevel < -1 || s.SecureLevel > 3:
+		return fmt.Errorf("spec: securelevel must be between -1 and 3: %d", s.SecureLevel)
+	case s.EnforceStatFS < 0 || s.EnforceStatFS > 2:
+		return fmt.Errorf("spec: enforce_statfs must be between 0 and 2: %d", s.EnforceStatFS)
+	case s.DevFSRuleset < 0 || s.DevFSRuleset > maxDevFSRuleset:
+		return fmt.Errorf("spec: devfs_ruleset must be between 0 and %d: %d", maxDevFSRuleset, s.DevFSRuleset)
+	case s.ChildrenMax < 0 || int64(s.ChildrenMax) > MaxChildJails:
+		return fmt.Errorf("spec: children.max must be between 0 and %d: %d", MaxChildJails, s.ChildrenMax)
+	case len(s.OSRelease) > maxOSReleaseLen:
+		return fmt.Errorf("spec: osrelease is longer than %d bytes", maxOSReleaseLen)
+	case s.OSRelDate < 0:
+		return fmt.Errorf("spec: osreldate must not be negative: %d", s.OSRelDate)
+	case len(s.Domainname) > maxHostnameLen:
+		return fmt.Errorf("spec: domainname is longer than %d bytes", maxHostnameLen)
+	case len(s.HostUUID) > maxHostUUIDLen:
+		return fmt.Errorf("spec: hostuuid is longer than %d bytes", maxHostUUIDLen)
+	case s.IP4Inherit && len(s.ip4()) > 0:
+		return errors.New("spec: ip4 cannot inherit and have addresses")
+	case s.IP6Inherit && len(s.ip6()) > 0:
+		return errors.New("spec: ip6 cannot inherit and have addresses")
+	}
+	for _, p := range s.params() {
+		if strings.HasPrefix(p.name, "sysv") && p.value == int32(-1) {
+			return fmt.Errorf("spec: %s must be disable, new or inherit: %s", p.name, p.text)
+		}
+	}
+	// A numeric name is reserved for a jail whose jid is that number
+	if _, err := strconv.Atoi(s.Name); err == nil {
+		return fmt.Errorf("spec: name must not be numeric: %s", s.Name)
+	}
+	for _, addr := range s.IP4 {
+		if !addr.Is4() {
+			return fmt.Errorf("spec: not an IPv4 address: %s", addr)
+		}
+	}
+	for _, a := range s.Aliases {
+		if a.Interface == "" && s.Interface == "" {
+			return fmt.Errorf("spec: no interface for alias %s", a)
+		} else if !a.Prefix.IsValid() {
+			return fmt.Errorf("spec: invalid alias: %s", a)
+		}
+	}
+	for _, addr := range s.IP6 {
+		if !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("spec: not an IPv6 address: %s", addr)
+		}
+	}
+	for i, r := range s.limits() {
+		if sr := s.Limits[i]; sr.Subject != "" && (sr.Subject != r.Subject || sr.SubjectID != r.SubjectID) {
+			return fmt.Errorf("spec: rule %s does not apply to jail %q", sr, s.Name)
+		} else if err := r.Validate(); err != nil {
+			return fmt.Errorf("spec: %w", err)
+		}
+	}
+	for _, m := range s.Mounts {
+		if m.Source == "" || m.FSType == "" || m.Options == "" || !filepath.IsAbs(m.Target) {
+			return fmt.Errorf("spec: invalid mount: %s", m)
+		}
+	}
+	if s.Template != "" {
+		if err := validateTemplate(s.Template); err != nil {
+			return fmt.Errorf("spec: %w", err)
+		}
+	}
+	if len(s.CPUSet) > 0 {
+		return validateCPUs(s.CPUSet)
+	}
+	return nil
+}
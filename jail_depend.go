@@ -0,0 +1,228 @@
+package jail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrSkipped is reported for a jail that was not started (or stopped)
+// because a jail it is ordered against has failed
+var ErrSkipped = errors.New("skipped because a related jail failed")
+
+// BulkError reports the jails that failed during a bulk operation,
+// keyed by jail name
+type BulkError map[string]error
+
+func (e BulkError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("jail %s: %v", name, e[name]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Graph orders jails by their "depend" parameter. It is a directed
+// acyclic graph: a jail is started after the jails it depends on,
+// and stopped before them.
+type Graph struct {
+	names      []string
+	deps       map[string][]string
+	dependents map[string][]string
+}
+
+// Returns a dependency graph for the jails in a config
+func (c *Config) Graph() (*Graph, error) {
+	return NewGraph(c.Jails)
+}
+
+// Builds a dependency graph from the "depend" parameter of each jail.
+// An error is returned when a jail depends on an unknown jail, or when
+// the dependencies form a cycle.
+func NewGraph(jails []ConfigJail) (*Graph, error) {
+	g := &Graph{
+		names:      make([]string, 0, len(jails)),
+		deps:       make(map[string][]string, len(jails)),
+		dependents: make(map[string][]string, len(jails)),
+	}
+	for _, j := range jails {
+		if _, ok := g.deps[j.Name]; ok {
+			return nil, fmt.Errorf("jail %q is defined more than once", j.Name)
+		}
+		g.names = append(g.names, j.Name)
+		g.deps[j.Name] = j.Depend()
+	}
+	for _, name := range g.names {
+		for _, dep := range g.deps[name] {
+			if _, ok := g.deps[dep]; !ok {
+				return nil, fmt.Errorf("jail %q depends on unknown jail %q", name, dep)
+			}
+			g.dependents[dep] = append(g.dependents[dep], name)
+		}
+	}
+	if cycle := g.cycle(); cycle != nil {
+		return nil, fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return g, nil
+}
+
+// Returns the jails a jail depends on
+func (g *Graph) Depends(name string) []string {
+	return g.deps[name]
+}
+
+// Returns the jails in the order they would be started when run
+// one at a time. Jails without an ordering between them keep the
+// order they were declared in.
+func (g *Graph) Order() []string {
+	order := make([]string, 0, len(g.names))
+	pending, queue := g.roots(g.deps)
+	for len(queue) > 0 {
+		name := queue[0]
+		order = append(order, name)
+		queue = g.complete(name, g.dependents, pending, queue[1:])
+	}
+	return order
+}
+
+// Start jails in dependency order, running at most parallel start
+// functions at the same time (or without a limit when parallel is
+// less than 1). A jail whose dependency failed is not started. The
+// returned error is a BulkError when one or more jails failed.
+func (g *Graph) Start(ctx context.Context, parallel int, start func(context.Context, string) error) error {
+	return g.run(ctx, parallel, g.deps, g.dependents, start)
+}
+
+// Stop jails in reverse dependency order: a jail is stopped after
+// every jail that depends on it. A jail is not stopped when one of
+// its dependents failed to stop, since the dependent still relies
+// on it. The returned error is a BulkError when one or more jails
+// failed.
+func (g *Graph) Stop(ctx context.Context, parallel int, stop func(context.Context, string) error) error {
+	return g.run(ctx, parallel, g.dependents, g.deps, stop)
+}
+
+// run calls fn for every jail once fn has returned for all jails
+// in before[name]
+func (g *Graph) run(ctx context.Context, parallel int, before, after map[string][]string, fn func(context.Context, string) error) error {
+	type result struct {
+		name string
+		err  error
+	}
+	var (
+		errs     = BulkError{}
+		done     = make(chan result)
+		inflight int
+	)
+	skip := func(name string) error {
+		for _, dep := range before[name] {
+			if _, ok := errs[dep]; ok {
+				return fmt.Errorf("%w: %s", ErrSkipped, dep)
+			}
+		}
+		return ctx.Err()
+	}
+	pending, queue := g.roots(before)
+	for len(queue) > 0 || inflight > 0 {
+		for len(queue) > 0 && (parallel < 1 || inflight < parallel) {
+			name := queue[0]
+			queue = queue[1:]
+			if err := skip(name); err != nil {
+				errs[name] = err
+				queue = g.complete(name, after, pending, queue)
+				continue
+			}
+			inflight++
+			go func() {
+				done <- result{name, fn(ctx, name)}
+			}()
+		}
+		if inflight > 0 {
+			r := <-done
+			inflight--
+			if r.err != nil {
+				errs[r.name] = r.err
+			}
+			queue = g.complete(r.name, after, pending, queue)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// roots returns the number of predecessors of each jail, and the
+// jails without predecessors
+func (g *Graph) roots(before map[string][]string) (map[string]int, []string) {
+	pending := make(map[string]int, len(g.names))
+	queue := make([]string, 0, len(g.names))
+	for _, name := range g.names {
+		pending[name] = len(before[name])
+		if pending[name] == 0 {
+			queue = append(queue, name)
+		}
+	}
+	return pending, queue
+}
+
+// complete marks a jail as done, and queues the jails that were
+// only waiting on it
+func (g *Graph) complete(name string, after map[string][]string, pending map[string]int, queue []string) []string {
+	for _, next := range after[name] {
+		if pending[next]--; pending[next] == 0 {
+			queue = append(queue, next)
+		}
+	}
+	return queue
+}
+
+// cycle returns the jails that form a dependency cycle, or nil
+func (g *Graph) cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.names))
+	var (
+		stack []string
+		found []string
+		visit func(string) bool
+	)
+	visit = func(name string) bool {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dep := range g.deps[name] {
+			switch state[dep] {
+			case visiting:
+				for i, n := range stack {
+					if n == dep {
+						found = append(append([]string{}, stack[i:]...), dep)
+					}
+				}
+				return true
+			case unvisited:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return false
+	}
+	for _, name := range g.names {
+		if state[name] == unvisited && visit(name) {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package jail
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// ChangeKind is the kind of a Change
+type ChangeKind int
+
+const (
+	// A parameter has a different value
+	Changed ChangeKind = iota
+	// A parameter (or an element of a list) was added
+	Added
+	// A parameter (or an element of a list) was removed
+	Removed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Changed:
+		return "changed"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change describes a parameter that differs between two jails.
+// Lists of IP addresses are compared element by element, and a
+// Change is reported for each address that was added or removed.
+type Change struct {
+	Kind  ChangeKind `json:"kind"`
+	Param string     `json:"param"`
+	Old   string     `json:"old,omitempty"`
+	New   string     `json:"new,omitempty"`
+	// Restart is true when the parameter cannot be changed on a
+	// live jail with UpdateFlag, and the jail must be recreated
+	Restart bool `json:"restart"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %q", c.Param, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s: %q", c.Param, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %q => %q", c.Param, c.Old, c.New)
+	}
+}
+
+// Returns the parameters that differ between two jails, in a
+// stable order. Each allow.* parameter is compared individually.
+func Diff(a, b *Jail) []Change {
+	return DiffSpecs(a.Spec(), b.Spec())
+}
+
+// inherited lists the parameters that a new jail inherits from the
+// host when they are not set
+var inherited = map[string]bool{
+	"osrelease":       true,
+	"osreldate":       true,
+	"host.domainname": true,
+	"host.hostuuid":   true,
+	"host.hostid":     true,
+}
+
+// Returns the parameters that differ between two specs
+func DiffSpecs(a, b Spec) []Change {
+	var changes []Change
+	pb := b.params()
+	for i, p := range a.params() {
+		name, old, cur := p.name, p.text, pb[i].text
+		if old == cur {
+			continue
+		}
+		// An unset parameter is inherited from the host when the
+		// jail is created, and is not a change
+		if (old == "" || cur == "") && inherited[name] {
+			continue
+		}
+		// Addresses inherited from the parent are compared as a whole
+		switch {
+		case name == "ip4.addr" && !a.IP4Inherit && !b.IP4Inherit:
+			changes = append(changes, diffAddrs(name, a.ip4(), b.ip4())...)
+		case name == "ip6.addr" && !a.IP6Inherit && !b.IP6Inherit:
+			changes = append(changes, diffAddrs(name, a.ip6(), b.ip6())...)
+		default:
+			c := Change{Kind: Changed, Param: name, Old: old, New: cur, Restart: immutable[name]}
+			if old == "" {
+				c.Kind = Added
+			} else if cur == "" {
+				c.Kind = Removed
+			}
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// diffAddrs compares two lists of addresses. When both lists hold
+// the same addresses, the primary (first) address has changed.
+func diffAddrs(name string, a, b []netip.Addr) []Change {
+	var changes []Change
+	for _, addr := range a {
+		if !slices.Contains(b, addr) {
+			changes = append(changes, Change{Kind: Removed, Param: name, Old: addr.String()})
+		}
+	}
+	for _, addr := range b {
+		if !slices.Contains(a, addr) {
+			changes = append(changes, Change{Kind: Added, Param: name, New: addr.String()})
+		}
+	}
+	if len(changes) == 0 {
+		changes = append(changes, Change{Kind: Changed, Param: name, Old: addrText(a), New: addrText(b)})
+	}
+	return changes
+}
+
+// changed returns the Params that update a jail to the spec, given
+// the changes between them
+func (s Spec) changed(jid int32, changes []Change) Params {
+	params := NewParams()
+	params.Add("jid", jid)
+	for _, c := range changes {
+		if p, _ := s.param(c.Param); p.value != nil {
+			params.Add(p.key, p.value)
+		}
+	}
+	return params
+}
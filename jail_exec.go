@@ -0,0 +1,18 @@
+package jail
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+)
+
+// Returns a command that runs inside a jail through jexec(8)
+func Command(ctx context.Context, jid int32, name string, arg ...string) *exec.Cmd {
+	args := append([]string{strconv.Itoa(int(jid)), name}, arg...)
+	return exec.CommandContext(ctx, "jexec", args...)
+}
+
+// Returns a command that runs inside the jail through jexec(8)
+func (j *Jail) Command(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	return Command(ctx, j.ID, name, arg...)
+}
@@ -0,0 +1,58 @@
+package jail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ExportVersion is the version of the format written by Export. An
+// Import of a newer version fails rather than dropping parameters it
+// does not know about.
+const ExportVersion = 1
+
+// export is the format of Export: a version, and a Spec
+type export struct {
+	Version int  `json:"version"`
+	Jail    Spec `json:"jail"`
+}
+
+// Returns the parameters of a jail as versioned JSON, to be read
+// back by Import (eg on another host). Pseudo-parameters are not
+// known to the kernel: see ExportSpec to export them too.
+func Export(j *Jail) ([]byte, error) {
+	return ExportSpec(j.Spec())
+}
+
+// Returns a Spec as versioned JSON, including its pseudo-parameters.
+// JSON is also valid YAML, so the result can be read by YAML tools,
+// but Import only reads JSON.
+func ExportSpec(s Spec) ([]byte, error) {
+	return json.MarshalIndent(export{Version: ExportVersion, Jail: s}, "", "  ")
+}
+
+// Reads a Spec written by Export or ExportSpec. Parameters that are
+// missing have the defaults of NewSpec (eg enforce_statfs=2), and the
+// Spec is validated before it is returned.
+func Import(b []byte) (Spec, error) {
+	var e struct {
+		Version *int            `json:"version"`
+		Jail    json.RawMessage `json:"jail"`
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return Spec{}, err
+	}
+	switch {
+	case e.Version == nil:
+		return Spec{}, errors.New("import: missing version")
+	case *e.Version < 1 || *e.Version > ExportVersion:
+		return Spec{}, fmt.Errorf("import: unsupported version: %d", *e.Version)
+	case e.Jail == nil:
+		return Spec{}, errors.New("import: missing jail")
+	}
+	s := NewSpec("", "")
+	if err := json.Unmarshal(e.Jail, &s); err != nil {
+		return Spec{}, err
+	}
+	return s, s.Validate()
+}
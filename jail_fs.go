@@ -0,0 +1,293 @@
+package jail
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrEscape is returned when a path of an FS would resolve outside
+// of its root, such as through a symbolic link to /etc/master.passwd
+var ErrEscape = errors.New("path escapes the root of the jail")
+
+// FS is a filesystem rooted at the root of a jail. Paths are resolved
+// beneath the root by the kernel (openat2(2) with RESOLVE_BENEATH on
+// Linux, and O_RESOLVE_BENEATH on FreeBSD): a symbolic link that
+// points outside of the root, or an absolute symbolic link, cannot be
+// followed, and ErrEscape is returned. Names are slash-separated and
+// relative to the root, as with fs.FS.
+type FS struct {
+	Root string
+}
+
+var (
+	_ fs.ReadFileFS = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+)
+
+// Returns a filesystem rooted at the path of the jail
+func (j *Jail) FS() *FS {
+	return &FS{Root: j.Path}
+}
+
+// Returns a filesystem rooted at root (eg the Path of a Spec, before
+// the jail is created)
+func NewFS(root string) *FS {
+	return &FS{Root: root}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Opens a file with the flags of os.OpenFile
+func (fsys *FS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	fd, err := fsys.open("open", name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(fsys.Root, filepath.FromSlash(name))), nil
+}
+
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Writes a file, which is created with perm when it does not exist,
+// and truncated otherwise
+func (fsys *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns the entries of a directory, sorted by name
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, err
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.stat("stat", name, oPath)
+}
+
+// Returns the FileInfo of a file, without following a final symbolic
+// link
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	return fsys.stat("lstat", name, oPath|unix.O_NOFOLLOW)
+}
+
+func (fsys *FS) Mkdir(name string, perm fs.FileMode) error {
+	return fsys.at("mkdir", name, func(dirfd int, base string) error {
+		return unix.Mkdirat(dirfd, base, syscallMode(perm))
+	})
+}
+
+// Creates a directory and the parents it needs
+func (fsys *FS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		err := fsys.Mkdir(dir, perm)
+		if err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		if fi, serr := fsys.Stat(dir); serr != nil {
+			return serr
+		} else if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: unix.ENOTDIR}
+		}
+	}
+	return nil
+}
+
+// Removes a file or an empty directory
+func (fsys *FS) Remove(name string) error {
+	return fsys.at("remove", name, func(dirfd int, base string) error {
+		err := unix.Unlinkat(dirfd, base, 0)
+		if err == nil {
+			return nil
+		}
+		if derr := unix.Unlinkat(dirfd, base, unix.AT_REMOVEDIR); derr == nil {
+			return nil
+		} else if derr != unix.ENOTDIR {
+			return derr
+		}
+		return err
+	})
+}
+
+func (fsys *FS) Rename(oldname, newname string) error {
+	olddirfd, oldbase, err := fsys.parent("rename", oldname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(olddirfd)
+	newdirfd, newbase, err := fsys.parent("rename", newname)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(newdirfd)
+	if err := unix.Renameat(olddirfd, oldbase, newdirfd, newbase); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Creates a symbolic link. The target is not resolved: a link that
+// escapes the root can be created, but cannot be followed through the
+// FS.
+func (fsys *FS) Symlink(target, name string) error {
+	return fsys.at("symlink", name, func(dirfd int, base string) error {
+		return unix.Symlinkat(target, dirfd, base)
+	})
+}
+
+func (fsys *FS) Readlink(name string) (string, error) {
+	var link string
+	err := fsys.at("readlink", name, func(dirfd int, base string) error {
+		for size := 256; ; size *= 2 {
+			b := make([]byte, size)
+			n, err := unix.Readlinkat(dirfd, base, b)
+			if err != nil {
+				return err
+			} else if n < size {
+				link = string(b[:n])
+				return nil
+			}
+		}
+	})
+	return link, err
+}
+
+func (fsys *FS) Chmod(name string, mode fs.FileMode) error {
+	f, err := fsys.OpenFile(name, os.O_RDONLY|unix.O_NONBLOCK|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Chmod(mode)
+}
+
+func (fsys *FS) Chown(name string, uid, gid int) error {
+	f, err := fsys.OpenFile(name, os.O_RDONLY|unix.O_NONBLOCK|unix.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Chown(uid, gid)
+}
+
+// open opens a file beneath the root, and returns its descriptor
+func (fsys *FS) open(op, name string, flag int, perm fs.FileMode) (int, error) {
+	if !fs.ValidPath(name) {
+		return -1, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := os.Open(fsys.Root)
+	if err != nil {
+		return -1, err
+	}
+	defer root.Close()
+	fd, err := openBeneath(int(root.Fd()), name, flag|unix.O_CLOEXEC, syscallMode(perm))
+	if err != nil {
+		return -1, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fd, nil
+}
+
+func (fsys *FS) stat(op, name string, flag int) (fs.FileInfo, error) {
+	fd, err := fsys.open(op, name, flag, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+	return f.Stat()
+}
+
+// at calls fn with the directory that holds name and the last
+// element of name (see parent)
+func (fsys *FS) at(op, name string, fn func(dirfd int, base string) error) error {
+	fd, base, err := fsys.parent(op, name)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if err := fn(fd, base); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// parent opens the directory that holds name beneath the root, and
+// returns it with the last element of name. The last element is not
+// followed, so that a symbolic link is acted on rather than its
+// target.
+func (fsys *FS) parent(op, name string) (int, string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return -1, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = "."
+	}
+	fd, err := fsys.open(op, dir, os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, "", err
+	}
+	return fd, base, nil
+}
+
+// syscallMode returns the bits of a mode that open(2) and mkdir(2)
+// take
+func syscallMode(mode fs.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		m |= unix.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= unix.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= unix.S_ISVTX
+	}
+	return m
+}
@@ -0,0 +1,26 @@
+package jail
+
+import "golang.org/x/sys/unix"
+
+// oPath is O_PATH from sys/fcntl.h (FreeBSD 14): a file is opened
+// for fstat(2) only, without reading it
+const oPath = 0x00400000
+
+// openBeneath opens a file through openat(2) with O_RESOLVE_BENEATH,
+// which fails with ENOTCAPABLE when the path would resolve outside
+// of dirfd
+func openBeneath(dirfd int, name string, flag int, perm uint32) (int, error) {
+	for {
+		fd, err := unix.Openat(dirfd, name, flag|unix.O_RESOLVE_BENEATH, perm)
+		switch err {
+		case nil:
+			return fd, nil
+		case unix.EINTR:
+			continue
+		case unix.ENOTCAPABLE:
+			return -1, ErrEscape
+		default:
+			return -1, err
+		}
+	}
+}
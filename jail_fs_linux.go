@@ -0,0 +1,34 @@
+package jail
+
+import "golang.org/x/sys/unix"
+
+// oPath opens a file for fstat(2) only, without reading it
+const oPath = unix.O_PATH
+
+// openBeneath opens a file through openat2(2), which fails with
+// EXDEV when the path would resolve outside of dirfd. Magic links
+// (eg /proc/self/root) are not followed either.
+func openBeneath(dirfd int, name string, flag int, perm uint32) (int, error) {
+	how := unix.OpenHow{
+		Flags:   uint64(flag),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	if flag&unix.O_CREAT != 0 {
+		how.Mode = uint64(perm)
+	}
+	for {
+		fd, err := unix.Openat2(dirfd, name, &how)
+		switch err {
+		case nil:
+			return fd, nil
+		case unix.EINTR, unix.EAGAIN:
+			// EAGAIN is returned when a rename raced with the
+			// resolution of the path
+			continue
+		case unix.EXDEV:
+			return -1, ErrEscape
+		default:
+			return -1, err
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !freebsd && !linux
+
+package jail
+
+import "errors"
+
+const oPath = 0
+
+// Returns an error: paths cannot be resolved beneath a directory on
+// this platform
+func openBeneath(dirfd int, name string, flag int, perm uint32) (int, error) {
+	return -1, errors.New("resolving beneath a directory is not supported")
+}
@@ -10,11 +10,7 @@ import (
 
 // jail_get(2) wrapper
 func Get(params Params, flags uintptr) (int32, error) {
-	iov, keep, err := params.buildIovec()
-	if err != nil {
-		return 0, err
-	}
-	return get(iov, keep, flags)
+	return kernel.Get(params, flags)
 }
 
 // jail_get(2)
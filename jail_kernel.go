@@ -0,0 +1,51 @@
+package jail
+
+// Kernel is the interface through which the package makes the jail
+// system calls. The default Kernel calls into the FreeBSD kernel, and
+// it can be replaced through SetKernel (eg with a fake in tests).
+type Kernel interface {
+	// jail_get(2)
+	Get(params Params, flags uintptr) (int32, error)
+	// jail_set(2)
+	Set(params Params, flags uintptr) (int32, error)
+	// jail_remove(2)
+	Remove(jid int32) error
+	// jail_attach(2)
+	Attach(jid int32) error
+}
+
+var kernel Kernel = sysKernel{}
+
+// Replace the Kernel used by the package, and return the previous one
+func SetKernel(k Kernel) Kernel {
+	prev := kernel
+	kernel = k
+	return prev
+}
+
+// sysKernel makes the jail system calls
+type sysKernel struct{}
+
+func (sysKernel) Get(params Params, flags uintptr) (int32, error) {
+	iov, keep, err := params.buildIovec()
+	if err != nil {
+		return 0, err
+	}
+	return get(iov, keep, flags)
+}
+
+func (sysKernel) Set(params Params, flags uintptr) (int32, error) {
+	iov, keep, err := params.buildIovec()
+	if err != nil {
+		return 0, err
+	}
+	return set(iov, keep, flags)
+}
+
+func (sysKernel) Remove(jid int32) error {
+	return remove(jid)
+}
+
+func (sysKernel) Attach(jid int32) error {
+	return attach(jid)
+}
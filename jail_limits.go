@@ -0,0 +1,69 @@
+package jail
+
+import (
+	"fmt"
+
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// Returns the rctl(8) rules of the jail
+func (j *Jail) Limits() ([]rctl.Rule, error) {
+	return rctl.Rules(j.subject())
+}
+
+// Replaces the rctl(8) rules of the jail. The subject of each rule
+// is set to the jail, and a rule with another subject is an error.
+// Rules are validated before any existing rule is removed.
+func (j *Jail) SetLimits(rules []rctl.Rule) error {
+	subject := j.subject()
+	want := make([]rctl.Rule, 0, len(rules))
+	for _, r := range rules {
+		if r.Subject != "" && (r.Subject != subject.Subject || r.SubjectID != subject.SubjectID) {
+			return fmt.Errorf("rule %s does not apply to jail %q", r, j.Name)
+		}
+		r.Subject, r.SubjectID = subject.Subject, subject.SubjectID
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		want = append(want, r)
+	}
+	// rctl(8) reports an error when a filter matches no rule
+	if current, err := j.Limits(); err != nil {
+		return err
+	} else if len(current) > 0 {
+		if err := rctl.Remove(subject); err != nil {
+			return err
+		}
+	}
+	for _, r := range want {
+		if err := rctl.Add(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the resource usage of the jail, keyed by resource
+func (j *Jail) Usage() (map[string]int64, error) {
+	return rctl.Usage(j.subject())
+}
+
+func (j *Jail) subject() rctl.Rule {
+	return rctl.Rule{Subject: rctl.SubjectJail, SubjectID: j.Name}
+}
+
+func (s Spec) subject() rctl.Rule {
+	return rctl.Rule{Subject: rctl.SubjectJail, SubjectID: s.Name}
+}
+
+// limits returns the Limits of a Spec with their subject set to
+// the jail
+func (s Spec) limits() []rctl.Rule {
+	rules := make([]rctl.Rule, 0, len(s.Limits))
+	subject := s.subject()
+	for _, r := range s.Limits {
+		r.Subject, r.SubjectID = subject.Subject, subject.SubjectID
+		rules = append(rules, r)
+	}
+	return rules
+}
@@ -0,0 +1,89 @@
+package jail
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// MountDriver mounts and unmounts filesystems on the host, such as
+// the base of a template in the root of a thin jail. The default
+// MountDriver is MountCommands, and it can be replaced through
+// SetMountDriver.
+type MountDriver interface {
+	Mount(m Mount) error
+	Unmount(target string) error
+	// Mounts returns the mounted filesystems
+	Mounts() ([]Mount, error)
+}
+
+var mountDriver MountDriver = MountCommands{}
+
+// Replace the MountDriver used by the package, and return the previous one
+func SetMountDriver(d MountDriver) MountDriver {
+	prev := mountDriver
+	mountDriver = d
+	return prev
+}
+
+// MountCommands implements MountDriver through mount(8) and umount(8)
+type MountCommands struct {
+	// Runner runs mount(8) and umount(8) (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+func (c MountCommands) Mount(m Mount) error {
+	_, err := runner.Or(c.Runner).Run(exec.Command("mount", "-t", m.FSType, "-o", m.Options, m.Source, m.Target))
+	return err
+}
+
+func (c MountCommands) Unmount(target string) error {
+	_, err := runner.Or(c.Runner).Run(exec.Command("umount", target))
+	return err
+}
+
+// Mounts reads the output of mount -p, which is in the fstab(5)
+// format
+func (c MountCommands) Mounts() ([]Mount, error) {
+	out, err := runner.Or(c.Runner).Run(exec.Command("mount", "-p"))
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m, err := ParseMount(line)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// mount mounts filesystems in order. When a filesystem cannot be
+// mounted, those mounted so far are unmounted again.
+func mount(mounts []Mount) error {
+	for i, m := range mounts {
+		if err := mountDriver.Mount(m); err != nil {
+			return errors.Join(err, unmount(mounts[:i]))
+		}
+	}
+	return nil
+}
+
+// unmount unmounts filesystems in reverse order, and reports every
+// failure
+func unmount(mounts []Mount) error {
+	var errs []error
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if err := mountDriver.Unmount(mounts[i].Target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
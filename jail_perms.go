@@ -0,0 +1,55 @@
+package jail
+
+import "strings"
+
+// perms maps the allow.* parameters onto the fields of Perms.
+// Optional parameters are not known to every kernel (eg they
+// depend on a kernel module being loaded).
+var perms = []struct {
+	name     string
+	optional bool
+	field    func(*Perms) *bool
+}{
+	{"allow.set_hostname", false, func(p *Perms) *bool { return &p.AllowSetHostname }},
+	{"allow.reserved_ports", false, func(p *Perms) *bool { return &p.AllowReservedPorts }},
+	{"allow.suser", false, func(p *Perms) *bool { return &p.AllowRoot }},
+	{"allow.chflags", false, func(p *Perms) *bool { return &p.AllowChflags }},
+	{"allow.raw_sockets", false, func(p *Perms) *bool { return &p.AllowRawSockets }},
+	{"allow.mount", false, func(p *Perms) *bool { return &p.AllowMount }},
+	{"allow.mount.devfs", false, func(p *Perms) *bool { return &p.AllowMountDevfs }},
+	{"allow.mlock", false, func(p *Perms) *bool { return &p.AllowMlock }},
+	{"allow.read_msgbuf", false, func(p *Perms) *bool { return &p.AllowReadMsgbuf }},
+	{"allow.socket_af", false, func(p *Perms) *bool { return &p.AllowSocketAF }},
+	{"allow.quotas", false, func(p *Perms) *bool { return &p.AllowQuotas }},
+	{"allow.extattr", true, func(p *Perms) *bool { return &p.AllowExtattr }},
+	{"allow.routing", true, func(p *Perms) *bool { return &p.AllowRouting }},
+	{"allow.unprivileged_proc_debug", false, func(p *Perms) *bool { return &p.AllowUnprivilegedProcDebug }},
+	{"allow.settime", true, func(p *Perms) *bool { return &p.AllowSetTime }},
+	{"allow.adjtime", true, func(p *Perms) *bool { return &p.AllowAdjTime }},
+	{"allow.setaudit", true, func(p *Perms) *bool { return &p.AllowSetAudit }},
+	{"allow.unprivileged_parent_tampering", true, func(p *Perms) *bool { return &p.AllowUnprivilegedParentTampering }},
+	{"allow.mount.procfs", true, func(p *Perms) *bool { return &p.AllowMountProcfs }},
+	{"allow.mount.tmpfs", true, func(p *Perms) *bool { return &p.AllowMountTmpfs }},
+	{"allow.mount.nullfs", true, func(p *Perms) *bool { return &p.AllowMountNullfs }},
+	{"allow.mount.zfs", true, func(p *Perms) *bool { return &p.AllowMountZfs }},
+	{"allow.vmm", true, func(p *Perms) *bool { return &p.AllowVMM }},
+}
+
+// noParam returns the "no" form of a boolean parameter
+// (eg allow.mount.devfs becomes allow.nomount.devfs)
+func noParam(name string) string {
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		return name[:i+1] + "no" + name[i+1:]
+	}
+	return "no" + name
+}
+
+// baseParam returns the name of a boolean parameter without its
+// "no" prefix (eg allow.nomount.devfs becomes allow.mount.devfs)
+func baseParam(name string) string {
+	i := strings.IndexByte(name, '.') + 1
+	if strings.HasPrefix(name[i:], "no") {
+		return name[:i] + name[i+2:]
+	}
+	return name
+}
@@ -0,0 +1,192 @@
+package jail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/user"
+	"slices"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Process is a process, as reported by the kern.proc sysctl
+type Process struct {
+	PID  int32
+	PPID int32
+	// JID is the jail the process runs in (0 for the host)
+	JID int32
+	// UID is the effective user ID of the process
+	UID uint32
+	// User is the name of UID on the host, as ps(1) reports it, or
+	// UID when it has no name
+	User    string
+	Command string
+	Start   time.Time
+}
+
+// Offsets of the fields of a kinfo_proc (sys/user.h) on amd64 and
+// arm64
+const (
+	kiStructSize = 0
+	kiPID        = 72
+	kiPPID       = 76
+	kiUID        = 168
+	kiStart      = 336
+	kiComm       = 447
+	kiCommLen    = 20
+	kiJID        = 592
+)
+
+// ProcKernel is implemented by a Kernel that can list and signal
+// processes. The default Kernel implements it; with a Kernel that
+// does not (see SetKernel), the processes of a jail cannot be listed
+// or signalled.
+type ProcKernel interface {
+	// sysctl(3) kern.proc.proc: the kinfo_proc of every process
+	Procs() ([]byte, error)
+	// kill(2)
+	Kill(pid int32, sig unix.Signal) error
+}
+
+func (sysKernel) Procs() ([]byte, error) {
+	return procs()
+}
+
+func (sysKernel) Kill(pid int32, sig unix.Signal) error {
+	return unix.Kill(int(pid), sig)
+}
+
+// procKernel returns the Kernel as a ProcKernel
+func procKernel() (ProcKernel, error) {
+	if k, ok := kernel.(ProcKernel); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("processes: %w by the kernel", errors.ErrUnsupported)
+}
+
+// Returns the processes that run in the jail, ordered by PID
+func (j *Jail) Processes() ([]Process, error) {
+	k, err := procKernel()
+	if err != nil {
+		return nil, err
+	}
+	b, err := k.Procs()
+	if err != nil {
+		return nil, err
+	}
+	all, err := ParseKinfoProc(b)
+	if err != nil {
+		return nil, err
+	}
+	var procs []Process
+	for _, p := range all {
+		if p.JID == j.ID {
+			p.User = username(p.UID)
+			procs = append(procs, p)
+		}
+	}
+	slices.SortFunc(procs, func(a, b Process) int { return int(a.PID - b.PID) })
+	return procs, nil
+}
+
+// Sends a signal to every process of the jail. A process that exits
+// before it is signalled is not an error.
+func (j *Jail) Signal(sig unix.Signal) error {
+	procs, err := j.Processes()
+	if err != nil {
+		return err
+	}
+	k, err := procKernel()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range procs {
+		if err := k.Kill(p.PID, sig); err != nil && !errors.Is(err, unix.ESRCH) {
+			errs = append(errs, fmt.Errorf("kill %d: %w", p.PID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Kills every process of the jail with SIGKILL
+func (j *Jail) KillAll() error {
+	return j.Signal(unix.SIGKILL)
+}
+
+// Decodes the kinfo_proc records returned by the kern.proc sysctl
+// (eg a capture of it). User is left empty.
+func ParseKinfoProc(b []byte) ([]Process, error) {
+	var procs []Process
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("kinfo_proc: truncated record: %d bytes", len(b))
+		}
+		size := int(binary.LittleEndian.Uint32(b[kiStructSize:]))
+		switch {
+		case size != kinfoProcSize:
+			return nil, fmt.Errorf("kinfo_proc: unsupported size: %d", size)
+		case len(b) < size:
+			return nil, fmt.Errorf("kinfo_proc: truncated record: %d bytes", len(b))
+		}
+		comm := b[kiComm : kiComm+kiCommLen]
+		if i := bytes.IndexByte(comm, 0); i >= 0 {
+			comm = comm[:i]
+		}
+		sec := int64(binary.LittleEndian.Uint64(b[kiStart:]))
+		usec := int64(binary.LittleEndian.Uint64(b[kiStart+8:]))
+		procs = append(procs, Process{
+			PID:     int32(binary.LittleEndian.Uint32(b[kiPID:])),
+			PPID:    int32(binary.LittleEndian.Uint32(b[kiPPID:])),
+			JID:     int32(binary.LittleEndian.Uint32(b[kiJID:])),
+			UID:     binary.LittleEndian.Uint32(b[kiUID:]),
+			Command: string(comm),
+			Start:   time.Unix(sec, usec*1000),
+		})
+		b = b[size:]
+	}
+	return procs, nil
+}
+
+// username returns the name of a uid on the host
+func username(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+// sysctl(3) kern.proc.proc
+func procs() ([]byte, error) {
+	mib := [3]int32{ctlKern, kernProc, kernProcProc}
+	for {
+		var n uintptr
+		if err := sysctl(mib[:], nil, &n); err != nil {
+			return nil, err
+		}
+		// Leave room for processes created between the two calls
+		n += n / 8
+		b := make([]byte, n)
+		err := sysctl(mib[:], &b[0], &n)
+		if errors.Is(err, unix.ENOMEM) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+
+func sysctl(mib []int32, old *byte, oldlen *uintptr) error {
+	_, _, e1 := unix.Syscall6(uintptr(sysSysctl), uintptr(unsafe.Pointer(&mib[0])), uintptr(len(mib)), uintptr(unsafe.Pointer(old)), uintptr(unsafe.Pointer(oldlen)), 0, 0)
+	if e1 != 0 {
+		return fmt.Errorf("sysctl kern.proc.proc: %w", e1)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package jail
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Profile is a named combination of securelevel, enforce_statfs,
+// devfs_ruleset and allow.* parameters that is known to be safe for
+// a kind of workload
+type Profile struct {
+	Name          string
+	Description   string
+	SecureLevel   int32
+	EnforceStatFS int32
+	DevFSRuleset  int32
+	Perms         Perms
+}
+
+// devfsRulesetJail is the devfsrules_jail ruleset of
+// /etc/defaults/devfs.rules
+const devfsRulesetJail = 4
+
+// The built-in profiles are only handed out as copies (see Profiles
+// and LookupProfile), so that a caller cannot change them for others
+var (
+	// profileMinimal only lets root within the jail act as root
+	profileMinimal = Profile{
+		Name:          "minimal",
+		Description:   "a jail that needs no privileges beyond its own root",
+		SecureLevel:   3,
+		EnforceStatFS: 2,
+		DevFSRuleset:  devfsRulesetJail,
+		Perms:         Perms{AllowRoot: true},
+	}
+	// profileWebService also lets the jail bind to ports below 1024
+	profileWebService = Profile{
+		Name:          "web-service",
+		Description:   "a network service that binds to reserved ports",
+		SecureLevel:   2,
+		EnforceStatFS: 2,
+		DevFSRuleset:  devfsRulesetJail,
+		Perms:         Perms{AllowRoot: true, AllowReservedPorts: true},
+	}
+	// profileBuild lets the jail mount the filesystems that building
+	// and installing software needs, and set file flags
+	profileBuild = Profile{
+		Name:          "build",
+		Description:   "a build environment that mounts filesystems and sets file flags",
+		SecureLevel:   1,
+		EnforceStatFS: 1,
+		DevFSRuleset:  devfsRulesetJail,
+		Perms: Perms{
+			AllowRoot:          true,
+			AllowChflags:       true,
+			AllowMount:         true,
+			AllowMountDevfs:    true,
+			AllowMountNullfs:   true,
+			AllowMountProcfs:   true,
+			AllowMountTmpfs:    true,
+			AllowSetHostname:   true,
+			AllowReservedPorts: true,
+		},
+	}
+)
+
+// Returns copies of the built-in profiles
+func Profiles() []Profile {
+	return []Profile{profileMinimal, profileWebService, profileBuild}
+}
+
+// Returns a copy of a built-in profile by name
+func LookupProfile(name string) (Profile, error) {
+	for _, p := range Profiles() {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown profile: %s", name)
+}
+
+// Returns a Spec with the parameters of the profile. Every allow.*
+// parameter is replaced, including those the profile leaves off.
+func (p Profile) Apply(s Spec) Spec {
+	s.SecureLevel = p.SecureLevel
+	s.EnforceStatFS = p.EnforceStatFS
+	s.DevFSRuleset = p.DevFSRuleset
+	s.Perms = p.Perms
+	return s
+}
+
+// Severity is how much a Finding weakens the isolation of a jail
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityLow:
+		return "low"
+	case SeverityMedium:
+		return "medium"
+	case SeverityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// Finding is a risky parameter, or combination of parameters,
+// reported by Lint
+type Finding struct {
+	Severity Severity
+	// Params are the parameters involved (eg allow.raw_sockets)
+	Params []string
+	// Message explains the risk
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, strings.Join(f.Params, ", "), f.Message)
+}
+
+// lintRule reports a Finding when check is true for a Spec
+type lintRule struct {
+	severity Severity
+	params   []string
+	message  string
+	check    func(Spec) bool
+}
+
+var lintRules = []lintRule{
+	{
+		SeverityHigh, []string{"enforce_statfs"},
+		"enforce_statfs=0 lets the jail see every mount point of the host, including paths outside of its root",
+		func(s Spec) bool { return s.EnforceStatFS == 0 },
+	},
+	{
+		SeverityHigh, []string{"allow.raw_sockets", "allow.mount"},
+		"root in the jail can forge IP packets from the addresses of the jail (eg spoofed ICMP, or TCP resets to connections of other hosts) and mount file systems over its own tree (eg a tmpfs over /bin) that hide the files it changes from scans of its root on the host",
+		func(s Spec) bool { return s.Perms.AllowRawSockets && s.Perms.AllowMount },
+	},
+	{
+		SeverityHigh, []string{"allow.vmm", "vnet"},
+		"vmm(4) exposes the hypervisor to the jail, and without VNET its guests are networked through the stack of the host",
+		func(s Spec) bool { return s.Perms.AllowVMM && !s.Vnet },
+	},
+	{
+		SeverityHigh, []string{"allow.routing", "vnet"},
+		"allow.routing on a jail that shares the network stack of the host lets it change the routing table of the host",
+		func(s Spec) bool { return s.Perms.AllowRouting && !s.Vnet },
+	},
+	{
+		SeverityHigh, []string{"allow.mount.devfs", "devfs_ruleset"},
+		"a devfs mounted without a ruleset exposes every device of the host, including raw disks and /dev/mem",
+		func(s Spec) bool { return s.Perms.AllowMountDevfs && s.DevFSRuleset == 0 },
+	},
+	{
+		SeverityMedium, []string{"securelevel"},
+		"below securelevel 1, root in the jail can remove immutable and append-only file flags",
+		func(s Spec) bool { return s.SecureLevel < 1 },
+	},
+	{
+		SeverityMedium, []string{"allow.settime", "allow.adjtime"},
+		"the jail can change the clock of the host",
+		func(s Spec) bool { return s.Perms.AllowSetTime || s.Perms.AllowAdjTime },
+	},
+	{
+		SeverityMedium, []string{"allow.unprivileged_parent_tampering"},
+		"unprivileged processes in the jail can tamper with processes of the host that share their uid",
+		func(s Spec) bool { return s.Perms.AllowUnprivilegedParentTampering },
+	},
+	{
+		SeverityLow, []string{"allow.raw_sockets"},
+		"raw sockets let the jail craft arbitrary packets of any IP protocol (eg ICMP, or custom IP payloads), though only from its own addresses",
+		func(s Spec) bool { return s.Perms.AllowRawSockets && !s.Perms.AllowMount },
+	},
+	{
+		SeverityLow, []string{"allow.socket_af"},
+		"the jail can create sockets of any protocol family, not only local, IPv4, IPv6 and routing sockets",
+		func(s Spec) bool { return s.Perms.AllowSocketAF },
+	},
+	{
+		SeverityLow, []string{"allow.read_msgbuf"},
+		"the kernel message buffer can reveal activity of the host and of other jails",
+		func(s Spec) bool { return s.Perms.AllowReadMsgbuf },
+	},
+	{
+		SeverityInfo, []string{"children.max"},
+		"the jail can create child jails, which inherit at most its own privileges",
+		func(s Spec) bool { return s.ChildrenMax > 0 },
+	},
+}
+
+// Reports risky parameters, and risky combinations of parameters,
+// of a jail. Findings are ordered by severity, most severe first.
+func Lint(j *Jail) []Finding {
+	return j.Spec().Lint()
+}
+
+// Reports risky parameters, and risky combinations of parameters,
+// of a Spec before it is created (see Lint)
+func (s Spec) Lint() []Finding {
+	var findings []Finding
+	for _, r := range lintRules {
+		if r.check(s) {
+			findings = append(findings, Finding{Severity: r.severity, Params: slices.Clone(r.params), Message: r.message})
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b Finding) int { return int(b.Severity - a.Severity) })
+	return findings
+}
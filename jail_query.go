@@ -2,11 +2,12 @@ package jail
 
 import (
 	"errors"
+	"fmt"
 
 	"golang.org/x/sys/unix"
 )
 
-// Find a jail by ID.
+// Find a jail by ID. The jail may be dying.
 func FindByID(jid int32) (*Jail, error) {
 	j := &Jail{ID: jid}
 	setBool := func(target *bool, mib string) error {
@@ -28,6 +29,22 @@ func FindByID(jid int32) (*Jail, error) {
 		*target = v
 		return nil
 	}
+	// The System V IPC and address family parameters are missing
+	// from kernels built without the matching option, which is taken
+	// as "disable"
+	setSys := func(target *string, mib string) error {
+		v, err := j.GetInt32(mib)
+		switch {
+		case errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOENT):
+			v = jailSysDisable
+		case err != nil:
+			return err
+		case v < 0 || int(v) >= len(jailSysNames):
+			return fmt.Errorf("%s: unknown value: %d", mib, v)
+		}
+		*target = jailSysNames[v]
+		return nil
+	}
 	var err error
 	if j.Name, err = j.GetString("name"); err != nil {
 		return nil, err
@@ -56,6 +73,18 @@ func FindByID(jid int32) (*Jail, error) {
 	if j.DevFSRuleset, err = j.GetInt32("devfs_ruleset"); err != nil {
 		return nil, err
 	}
+	if j.ChildrenMax, err = j.GetInt32("children.max"); err != nil {
+		return nil, err
+	}
+	if j.ChildrenCur, err = j.GetInt32("children.cur"); err != nil {
+		return nil, err
+	}
+	if j.IP4, err = j.getAddrs("ip4.addr", 4); err != nil {
+		return nil, err
+	}
+	if j.IP6, err = j.getAddrs("ip6.addr", 16); err != nil {
+		return nil, err
+	}
 	if j.Vnet, err = j.GetBool("vnet"); err != nil {
 		return nil, err
 	}
@@ -65,6 +94,39 @@ func FindByID(jid int32) (*Jail, error) {
 	if j.Persist, err = j.GetBool("persist"); err != nil {
 		return nil, err
 	}
+	if j.Domainname, err = j.GetString("host.domainname"); err != nil {
+		return nil, err
+	}
+	if j.HostUUID, err = j.GetString("host.hostuuid"); err != nil {
+		return nil, err
+	}
+	if j.HostID, err = j.GetInt64("host.hostid"); err != nil {
+		return nil, err
+	}
+	if err := setSys(&j.SysVMsg, "sysvmsg"); err != nil {
+		return nil, err
+	}
+	if err := setSys(&j.SysVSem, "sysvsem"); err != nil {
+		return nil, err
+	}
+	if err := setSys(&j.SysVShm, "sysvshm"); err != nil {
+		return nil, err
+	}
+	if err := setBoolOptional(&j.IP4SAddrSel, "ip4.saddrsel"); err != nil {
+		return nil, err
+	}
+	if err := setBoolOptional(&j.IP6SAddrSel, "ip6.saddrsel"); err != nil {
+		return nil, err
+	}
+	var ip4, ip6 string
+	if err := setSys(&ip4, "ip4"); err != nil {
+		return nil, err
+	}
+	if err := setSys(&ip6, "ip6"); err != nil {
+		return nil, err
+	}
+	j.IP4Inherit = ip4 == jailSysNames[jailSysInherit]
+	j.IP6Inherit = ip6 == jailSysNames[jailSysInherit]
 	if err := setBool(&j.Perms.AllowSetHostname, "allow.set_hostname"); err != nil {
 		return nil, err
 	}
@@ -137,6 +199,17 @@ func FindByID(jid int32) (*Jail, error) {
 	return j, nil
 }
 
+// Find a jail by name
+func FindByName(name string) (*Jail, error) {
+	params := NewParams()
+	params.Add("name", name)
+	jid, err := Get(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	return FindByID(jid)
+}
+
 // Returns all living jails
 func Living() ([]*Jail, error) {
 	return filterByDying(false)
@@ -174,7 +247,7 @@ func AllByID() ([]int32, error) {
 	for {
 		params := NewParams()
 		params.Add("lastjid", jid)
-		if jid, err = Get(params, 0); err != nil {
+		if jid, err = Get(params, DyingFlag); err != nil {
 			if errors.Is(err, unix.ENOENT) {
 				return jids, nil
 			}
@@ -8,6 +8,11 @@ import (
 
 // Removes a jail
 func Remove(jid int32) error {
+	return kernel.Remove(jid)
+}
+
+// jail_remove(2)
+func remove(jid int32) error {
 	_, _, e1 := unix.Syscall(uintptr(sysJailRemove), uintptr(jid), 0, 0)
 	if e1 != 0 {
 		switch int(e1) {
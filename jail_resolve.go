@@ -0,0 +1,399 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// Resolver resolves hostnames to addresses. *net.Resolver implements
+// it, and tests can provide a fake.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+var resolver Resolver = net.DefaultResolver
+
+// Replace the Resolver used when none is given (net.DefaultResolver
+// by default), and return the previous one
+func SetResolver(r Resolver) Resolver {
+	prev := resolver
+	resolver = r
+	return prev
+}
+
+// Returns a copy of the Spec with its pseudo-parameters resolved.
+// With IPHostname, the addresses the hostname resolves to are added
+// to IP4 and IP6, as the ip_hostname parameter of jail(8) does. When
+// r is nil, the Resolver set by SetResolver is used. Create resolves
+// the Spec it is given.
+func (s Spec) Resolve(ctx context.Context, r Resolver) (Spec, error) {
+	if !s.IPHostname {
+		return s, nil
+	}
+	if s.Hostname == "" {
+		return s, fmt.Errorf("spec: ip_hostname is set without a hostname")
+	}
+	addrs, err := lookup(ctx, r, s.Hostname)
+	if err != nil {
+		return s, err
+	}
+	s.IP4, s.IP6 = slices.Clone(s.IP4), slices.Clone(s.IP6)
+	for _, addr := range addrs {
+		if addr.Is4() && !slices.Contains(s.IP4, addr) {
+			s.IP4 = append(s.IP4, addr)
+		} else if addr.Is6() && !slices.Contains(s.IP6, addr) {
+			s.IP6 = append(s.IP6, addr)
+		}
+	}
+	return s, nil
+}
+
+// Set the jail hostname, and keep the addresses of a jail that has
+// IPHostname set consistent with it: the addresses the old hostname
+// resolves to are replaced by the addresses the new hostname resolves
+// to, through a single jail_set(2) call. It is meant for such jails
+// only, as a static address that the old hostname resolves to is
+// removed too. Nothing is changed when either hostname does not
+// resolve. When r is nil, the Resolver set by SetResolver is used.
+func (j *Jail) SetHostnameIP(ctx context.Context, name string, r Resolver) error {
+	var old []netip.Addr
+	if j.Hostname != "" {
+		var err error
+		if old, err = lookup(ctx, r, j.Hostname); err != nil {
+			return err
+		}
+	}
+	addrs, err := lookup(ctx, r, name)
+	if err != nil {
+		return err
+	}
+	desired := *j
+	desired.Hostname, desired.IP4, desired.IP6 = name, nil, nil
+	for _, addr := range append(append(slices.Clone(j.IP4), j.IP6...), addrs...) {
+		if slices.Contains(old, addr) && !slices.Contains(addrs, addr) {
+			continue
+		} else if addr.Is4() && !slices.Contains(desired.IP4, addr) {
+			desired.IP4 = append(desired.IP4, addr)
+		} else if addr.Is6() && !slices.Contains(desired.IP6, addr) {
+			desired.IP6 = append(desired.IP6, addr)
+		}
+	}
+	return j.Update(desired)
+}
+
+func lookup(ctx context.Context, r Resolver, host string) ([]netip.Addr, error) {
+	if r == nil {
+		r = resolver
+	}
+	addrs, err := r.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for i, addr := range addrs {
+		addrs[i] = addr.Unmap()
+	}
+	return addrs, nil
+}
+
+// Returns the Spec of a jail.conf(5) jail. Parameters a Spec does not
+// cover (eg exec.timeout and mount.devfs) are ignored, and
+// pseudo-parameters such as ip_hostname are left for Spec.Resolve.
+func (j ConfigJail) Spec() (Spec, error) {
+	path, _ := j.Get("path")
+	s := confDefaults(j.Name, path)
+	for _, p := range j.Params {
+		if err := s.setConfigParam(p); err != nil {
+			return s, fmt.Errorf("jail %q: %s: %w", j.Name, p.Name, err)
+		}
+	}
+	return s, nil
+}
+
+// confDefaults returns a Spec with the parameters jail(8) gives a jail
+// that jail.conf(5) leaves them unset for. Unlike NewSpec, the jail is
+// not persistent.
+func confDefaults(name, path string) Spec {
+	s := NewSpec(name, path)
+	s.Persist = false
+	return s
+}
+
+func (s *Spec) setConfigParam(p ConfigParam) error {
+	value := strings.Join(p.Values, ",")
+	num := func(target *int32) error {
+		n, err := strconv.ParseInt(value, 10, 32)
+		*target = int32(n)
+		return err
+	}
+	name, on := baseParam(p.Name), true
+	if name != p.Name {
+		on = false
+	}
+	switch name {
+	case "host.hostname":
+		s.Hostname = value
+	case "interface":
+		s.Interface = value
+	case "ip4.addr", "ip6.addr":
+		for _, v := range p.Values {
+			a, err := ParseIPAlias(strings.TrimSpace(v))
+			if err != nil {
+				return err
+			}
+			addr := a.Prefix.Addr()
+			if (name == "ip4.addr") != addr.Is4() {
+				return fmt.Errorf("wrong address family: %s", v)
+			}
+			switch {
+			case a.Interface != "":
+				s.Aliases = append(s.Aliases, a)
+			case addr.Is4():
+				s.IP4 = append(s.IP4, addr)
+			default:
+				s.IP6 = append(s.IP6, addr)
+			}
+		}
+	case "securelevel":
+		return num(&s.SecureLevel)
+	case "enforce_statfs":
+		return num(&s.EnforceStatFS)
+	case "devfs_ruleset":
+		return num(&s.DevFSRuleset)
+	case "children.max":
+		return num(&s.ChildrenMax)
+	case "osrelease":
+		s.OSRelease = value
+	case "osreldate":
+		return num(&s.OSRelDate)
+	case "host.domainname":
+		s.Domainname = value
+	case "host.hostuuid":
+		s.HostUUID = value
+	case "host.hostid":
+		n, err := strconv.ParseInt(value, 0, 64)
+		s.HostID = n
+		return err
+	case "sysvmsg", "sysvsem", "sysvshm":
+		if !slices.Contains(jailSysNames, value) {
+			return fmt.Errorf("not disable, new or inherit: %q", value)
+		}
+		switch name {
+		case "sysvmsg":
+			s.SysVMsg = value
+		case "sysvsem":
+			s.SysVSem = value
+		default:
+			s.SysVShm = value
+		}
+	case "ip4", "ip6":
+		if !slices.Contains(jailSysNames, value) {
+			return fmt.Errorf("not disable, new or inherit: %q", value)
+		}
+		if name == "ip4" {
+			s.IP4Inherit = value == jailSysNames[jailSysInherit]
+		} else {
+			s.IP6Inherit = value == jailSysNames[jailSysInherit]
+		}
+	case "ip4.saddrsel", "ip6.saddrsel":
+		b, err := parseConfigBool(value)
+		if name == "ip4.saddrsel" {
+			s.IP4SAddrSel = on && b
+		} else {
+			s.IP6SAddrSel = on && b
+		}
+		return err
+	case "mount":
+		for _, v := range p.Values {
+			m, err := ParseMount(v)
+			if err != nil {
+				return err
+			}
+			s.Mounts = append(s.Mounts, m)
+		}
+	case "zfs.dataset":
+		s.Datasets = append(s.Datasets, p.Values...)
+	case "vnet":
+		switch value {
+		case "new":
+			s.Vnet = on
+		case "inherit":
+			s.Vnet = false
+		default:
+			b, err := parseConfigBool(value)
+			s.Vnet = on && b
+			return err
+		}
+	case "persist", "ip_hostname":
+		b, err := parseConfigBool(value)
+		if name == "persist" {
+			s.Persist = on && b
+		} else {
+			s.IPHostname = on && b
+		}
+		return err
+	default:
+		for _, perm := range perms {
+			if perm.name == name {
+				b, err := parseConfigBool(value)
+				*perm.field(&s.Perms) = on && b
+				return err
+			}
+		}
+		for _, hook := range s.Exec.hooks() {
+			if hook.name == name {
+				s.setExecHook(name, hook.field, p.Values)
+			}
+		}
+	}
+	return nil
+}
+
+// setExecHook sets an exec.* parameter. The cpuset(1) and rctl(8)
+// commands that ConfigJail writes for the CPUSet and Limits of a Spec
+// are read back as CPUSet and Limits.
+func (s *Spec) setExecHook(name string, field *[]string, values []string) {
+	*field = nil
+	for _, v := range values {
+		switch {
+		case name == "exec.created" && strings.HasPrefix(v, "cpuset -l "):
+			list, jail, _ := strings.Cut(strings.TrimPrefix(v, "cpuset -l "), " -j ")
+			cpus, err := ParseCPUList(list)
+			if err == nil && (jail == s.Name || jail == "$name" || jail == "${name}") {
+				s.CPUSet = cpus
+				continue
+			}
+		case name == "exec.prestart" && strings.HasPrefix(v, "rctl -a "):
+			r, err := rctl.ParseRule(strings.TrimPrefix(v, "rctl -a "))
+			if err == nil && r.Subject == rctl.SubjectJail && r.SubjectID == s.Name {
+				r.Subject, r.SubjectID = "", ""
+				s.Limits = append(s.Limits, r)
+				continue
+			}
+		case name == "exec.poststop" && v == "rctl -r "+s.subject().Filter():
+			continue
+		}
+		*field = append(*field, v)
+	}
+}
+
+// Returns the Spec as a jail.conf(5) jail, which ConfigJail.Spec
+// reads back. Parameters that have the defaults of jail(8) are left
+// out, other than path and host.hostname: unlike NewSpec, jail(8)
+// does not make a jail persistent. CPUSet is written as a cpuset(1)
+// command run by exec.created, and Limits as rctl(8) commands:
+// exec.prestart adds them, and exec.poststop removes them.
+func (s Spec) ConfigJail() ConfigJail {
+	j := ConfigJail{Name: s.Name}
+	add := func(name string, values ...string) {
+		j.Params = append(j.Params, ConfigParam{Name: name, Values: values})
+	}
+	defaults := confDefaults(s.Name, s.Path)
+	for _, p := range s.params() {
+		switch p.name {
+		case "name":
+			continue
+		case "path", "host.hostname":
+			add(p.name, p.text)
+			continue
+		case "ip4.addr", "ip6.addr":
+			s.addrParams(p.name, add)
+			continue
+		}
+		if d, _ := defaults.param(p.name); d.text == p.text {
+			continue
+		}
+		switch {
+		case p.name == "vnet":
+			add("vnet", "new")
+		case p.text == "true":
+			add(p.name)
+		case p.text == "false":
+			add(noParam(p.name))
+		default:
+			add(p.name, p.text)
+		}
+	}
+	if s.IPHostname {
+		add("ip_hostname")
+	}
+	if all := append(s.templateMounts(), s.Mounts...); len(all) > 0 {
+		mounts := make([]string, 0, len(all))
+		for _, m := range all {
+			mounts = append(mounts, m.String())
+		}
+		add("mount", mounts...)
+	}
+	if len(s.Datasets) > 0 {
+		add("zfs.dataset", s.Datasets...)
+	}
+	exec := s.Exec
+	if limits := s.limits(); len(limits) > 0 {
+		prestart := make([]string, 0, len(limits)+len(exec.PreStart))
+		for _, r := range limits {
+			prestart = append(prestart, "rctl -a "+r.String())
+		}
+		exec.PreStart = append(prestart, exec.PreStart...)
+		exec.PostStop = append(slices.Clone(exec.PostStop), "rctl -r "+s.subject().Filter())
+	}
+	if len(s.CPUSet) > 0 {
+		cpuset := "cpuset -l " + FormatCPUList(s.CPUSet) + " -j " + s.Name
+		exec.Created = append([]string{cpuset}, exec.Created...)
+	}
+	for _, hook := range exec.hooks() {
+		if len(*hook.field) > 0 {
+			add(hook.name, *hook.field...)
+		}
+	}
+	return j
+}
+
+// addrParams adds the interface parameter, and the addresses and
+// aliases of one address family (or ip4=inherit and ip6=inherit)
+func (s Spec) addrParams(name string, add func(string, ...string)) {
+	if name == "ip4.addr" && s.Interface != "" {
+		add("interface", s.Interface)
+	}
+	if (name == "ip4.addr" && s.IP4Inherit) || (name == "ip6.addr" && s.IP6Inherit) {
+		add(strings.TrimSuffix(name, ".addr"), jailSysNames[jailSysInherit])
+	}
+	var values []string
+	addrs := s.IP4
+	if name == "ip6.addr" {
+		addrs = s.IP6
+	}
+	for _, addr := range addrs {
+		values = append(values, addr.String())
+	}
+	for _, a := range s.Aliases {
+		if a.Prefix.Addr().Is4() != (name == "ip4.addr") {
+			continue
+		}
+		if a.Interface == "" {
+			a.Interface = s.Interface
+		}
+		values = append(values, a.String())
+	}
+	if len(values) > 0 {
+		add(name, values...)
+	}
+}
+
+// parseConfigBool parses the value of a boolean parameter. A
+// parameter without a value (eg "persist;") is true.
+func parseConfigBool(v string) (bool, error) {
+	switch v {
+	case "", "1", "true":
+		return true, nil
+	case "0", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("not a boolean: %q", v)
+	}
+}
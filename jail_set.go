@@ -10,11 +10,7 @@ import (
 
 // jail_set(2) wrapper
 func Set(params Params, flags uintptr) (int32, error) {
-	iov, keep, err := params.buildIovec()
-	if err != nil {
-		return 0, err
-	}
-	return set(iov, keep, flags)
+	return kernel.Set(params, flags)
 }
 
 // jail_set(2)
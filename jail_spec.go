@@ -0,0 +1,389 @@
+package jail
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// Spec describes the desired state of a jail. A Jail is read from
+// the kernel, whereas a Spec is written by the caller and can be
+// sent to the kernel in a single jail_set(2) call.
+type Spec struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"`
+	Hostname string       `json:"hostname"`
+	IP4      []netip.Addr `json:"ip4_addr"`
+	IP6      []netip.Addr `json:"ip6_addr"`
+	// Interface is the host interface the addresses of IP4 and
+	// IP6 are added to as aliases (the interface pseudo-parameter
+	// of jail(8)). No aliases are added when empty.
+	Interface string `json:"interface,omitempty"`
+	// Aliases are addresses of the jail that are added to a host
+	// interface, in addition to those of IP4 and IP6
+	Aliases       []IPAlias `json:"aliases,omitempty"`
+	SecureLevel   int32     `json:"securelevel"`
+	EnforceStatFS int32     `json:"enforce_statfs"`
+	DevFSRuleset  int32     `json:"devfs_ruleset"`
+	ChildrenMax   int32     `json:"children_max"`
+	Vnet          bool      `json:"vnet"`
+	Persist       bool      `json:"persist"`
+	Perms         Perms     `json:"perms"`
+	// OSRelease and OSRelDate are inherited from the host when empty
+	OSRelease string `json:"osrelease,omitempty"`
+	OSRelDate int32  `json:"osreldate,omitempty"`
+	// Domainname, HostUUID and HostID are inherited from the host
+	// when empty
+	Domainname string `json:"domainname,omitempty"`
+	HostUUID   string `json:"hostuuid,omitempty"`
+	HostID     int64  `json:"hostid,omitempty"`
+	// SysVMsg, SysVSem and SysVShm give the jail access to System V
+	// IPC: "new" for its own objects, "inherit" for those of the
+	// host, or "disable" (the default when empty)
+	SysVMsg string `json:"sysvmsg,omitempty"`
+	SysVSem string `json:"sysvsem,omitempty"`
+	SysVShm string `json:"sysvshm,omitempty"`
+	// IP4Inherit and IP6Inherit give the jail the addresses of its
+	// parent (ip4=inherit and ip6=inherit of jail(8)), in place of
+	// IP4 and IP6. The jail has no network of that family otherwise
+	// when its list of addresses is empty.
+	IP4Inherit bool `json:"ip4_inherit,omitempty"`
+	IP6Inherit bool `json:"ip6_inherit,omitempty"`
+	// IP4SAddrSel and IP6SAddrSel select the source address of
+	// connections among the addresses of the jail, rather than its
+	// primary address
+	IP4SAddrSel bool `json:"ip4_saddrsel"`
+	IP6SAddrSel bool `json:"ip6_saddrsel"`
+
+	// The fields below are pseudo-parameters: they are not sent to
+	// the kernel, and act on the host when the jail is created.
+
+	// IPHostname adds the addresses the hostname resolves to to
+	// IP4 and IP6 when the Spec is resolved (the ip_hostname
+	// parameter of jail(8))
+	IPHostname bool `json:"ip_hostname,omitempty"`
+	// CPUSet restricts the jail to a set of CPUs once it has been
+	// created. The jail may run on every CPU when empty.
+	CPUSet []int `json:"cpuset,omitempty"`
+	// Limits are rctl(8) rules added once the jail has been created.
+	// The subject of each rule is set to the jail.
+	Limits []rctl.Rule `json:"rctl,omitempty"`
+	// Template is a template of TemplateDir whose base is mounted
+	// read-only in Path before the jail is created, and unmounted
+	// by Destroy (see NewThinRoot). jail.conf(5) carries the mounts
+	// rather than the name of the template.
+	Template string `json:"template,omitempty"`
+
+	// The fields below are only carried for jail(8): they are written
+	// to and read from jail.conf(5), but Create does not act on them.
+
+	// Mounts are the mount parameters of jail(8). Apply mounts them
+	// in the root of the jails it creates and updates (see NewPlan).
+	Mounts []Mount `json:"mounts,omitempty"`
+	// Datasets are the ZFS datasets delegated to the jail (the
+	// zfs.dataset parameter of jail(8), see storage.ZFS.Delegate)
+	Datasets []string `json:"zfs_datasets,omitempty"`
+	// Exec are the exec.* parameters of jail(8)
+	Exec ExecHooks `json:"exec,omitzero"`
+}
+
+// Mount is a filesystem mounted before a jail is created, given as
+// a single fstab(5) line by the mount parameter of jail(8)
+type Mount struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	FSType  string `json:"fstype"`
+	Options string `json:"options"`
+}
+
+// Parses a fstab(5) line. The dump and pass fields are optional.
+func ParseMount(line string) (Mount, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields) > 6 {
+		return Mount{}, fmt.Errorf("expected a fstab(5) line: %q", line)
+	}
+	return Mount{Source: fields[0], Target: fields[1], FSType: fields[2], Options: fields[3]}, nil
+}
+
+// Returns the mount as a fstab(5) line
+func (m Mount) String() string {
+	return strings.Join([]string{m.Source, m.Target, m.FSType, m.Options, "0", "0"}, " ")
+}
+
+// ExecHooks are the commands jail(8) runs as a jail is started and
+// stopped, in the order of the fields
+type ExecHooks struct {
+	Prepare   []string `json:"prepare,omitempty"`
+	PreStart  []string `json:"prestart,omitempty"`
+	Created   []string `json:"created,omitempty"`
+	Start     []string `json:"start,omitempty"`
+	PostStart []string `json:"poststart,omitempty"`
+	PreStop   []string `json:"prestop,omitempty"`
+	Stop      []string `json:"stop,omitempty"`
+	PostStop  []string `json:"poststop,omitempty"`
+	Release   []string `json:"release,omitempty"`
+}
+
+// hooks returns the exec.* parameters of jail(8) with the field
+// that holds each of them
+func (e *ExecHooks) hooks() []struct {
+	name  string
+	field *[]string
+} {
+	return []struct {
+		name  string
+		field *[]string
+	}{
+		{"exec.prepare", &e.Prepare},
+		{"exec.prestart", &e.PreStart},
+		{"exec.created", &e.Created},
+		{"exec.start", &e.Start},
+		{"exec.poststart", &e.PostStart},
+		{"exec.prestop", &e.PreStop},
+		{"exec.stop", &e.Stop},
+		{"exec.poststop", &e.PostStop},
+		{"exec.release", &e.Release},
+	}
+}
+
+// immutable lists the parameters that cannot be changed once a
+// jail has been created
+var immutable = map[string]bool{
+	"path":      true,
+	"vnet":      true,
+	"osrelease": true,
+	"osreldate": true,
+}
+
+// jailSysNames are the values of the jailsys parameters (eg sysvmsg)
+// as text, indexed by their value
+var jailSysNames = []string{
+	jailSysDisable: "disable",
+	jailSysNew:     "new",
+	jailSysInherit: "inherit",
+}
+
+// Returns a Spec with the defaults of jail(8). The zero value of
+// Spec is not a safe default: it would set enforce_statfs to 0.
+func NewSpec(name, path string) Spec {
+	return Spec{
+		Name:          name,
+		Path:          path,
+		Hostname:      name,
+		SecureLevel:   -1,
+		EnforceStatFS: 2,
+		Persist:       true,
+		IP4SAddrSel:   true,
+		IP6SAddrSel:   true,
+		Perms: Perms{
+			AllowSetHostname:           true,
+			AllowReservedPorts:         true,
+			AllowRoot:                  true,
+			AllowUnprivilegedProcDebug: true,
+		},
+	}
+}
+
+// Returns the Spec of a jail. The addresses a jail inherits from
+// its parent are left out.
+func (j *Jail) Spec() Spec {
+	s := Spec{
+		Name:          j.Name,
+		Path:          j.Path,
+		Hostname:      j.Hostname,
+		IP4:           j.IP4,
+		IP6:           j.IP6,
+		SecureLevel:   j.SecureLevel,
+		EnforceStatFS: j.EnforceStatFS,
+		DevFSRuleset:  j.DevFSRuleset,
+		ChildrenMax:   j.ChildrenMax,
+		Vnet:          j.Vnet,
+		Persist:       j.Persist,
+		Perms:         j.Perms,
+		OSRelease:     j.OSRelease,
+		OSRelDate:     j.OSRelDate,
+		Domainname:    j.Domainname,
+		HostUUID:      j.HostUUID,
+		HostID:        j.HostID,
+		SysVMsg:       j.SysVMsg,
+		SysVSem:       j.SysVSem,
+		SysVShm:       j.SysVShm,
+		IP4Inherit:    j.IP4Inherit,
+		IP6Inherit:    j.IP6Inherit,
+		IP4SAddrSel:   j.IP4SAddrSel,
+		IP6SAddrSel:   j.IP6SAddrSel,
+	}
+	if s.IP4Inherit {
+		s.IP4 = nil
+	}
+	if s.IP6Inherit {
+		s.IP6 = nil
+	}
+	return s
+}
+
+// Returns the Spec as Params that can be passed to Set
+func (s Spec) Params() Params {
+	params := NewParams()
+	for _, p := range s.params() {
+		if p.value != nil && !p.implied {
+			params.Add(p.key, p.value)
+		}
+	}
+	return params
+}
+
+// specParam is a single parameter of a Spec
+type specParam struct {
+	// name is the name of the parameter
+	name string
+	// text is the value of the parameter as text, for comparison
+	text string
+	// key is the name sent to jail_set(2) (eg allow.nomount)
+	key string
+	// value is the value sent to jail_set(2), or nil when unset
+	value any
+	// implied is true when value is the default of a new jail, and
+	// is not known to every kernel: it is then left out on creation
+	implied bool
+}
+
+// params returns the parameters of a Spec in a stable order
+func (s Spec) params() []specParam {
+	var ps []specParam
+	str := func(name, v string) {
+		p := specParam{name: name, text: v, key: name}
+		if v != "" {
+			p.value = v
+		}
+		ps = append(ps, p)
+	}
+	num := func(name string, v int32) {
+		ps = append(ps, specParam{name: name, text: strconv.Itoa(int(v)), key: name, value: v})
+	}
+	addrs := func(name, mode string, v []netip.Addr, inherit bool) {
+		p := specParam{name: name, text: addrText(v), key: name, value: encodeAddrs(v)}
+		switch {
+		case inherit:
+			p.text, p.key, p.value = jailSysNames[jailSysInherit], mode, int32(jailSysInherit)
+		case len(v) == 0:
+			p.key, p.value = mode, int32(jailSysDisable)
+		}
+		ps = append(ps, p)
+	}
+	boolean := func(name string, v, optional bool) {
+		p := specParam{name: name, text: strconv.FormatBool(v), key: name, value: int32(1)}
+		if !v {
+			p.key, p.implied = noParam(name), optional
+		}
+		ps = append(ps, p)
+	}
+	str("name", s.Name)
+	if s.Path == "" {
+		str("path", "")
+	} else {
+		str("path", filepath.Clean(s.Path))
+	}
+	ps = append(ps, specParam{name: "host.hostname", text: s.Hostname, key: "host.hostname", value: s.Hostname})
+	addrs("ip4.addr", "ip4", s.ip4(), s.IP4Inherit)
+	addrs("ip6.addr", "ip6", s.ip6(), s.IP6Inherit)
+	num("securelevel", s.SecureLevel)
+	num("enforce_statfs", s.EnforceStatFS)
+	num("devfs_ruleset", s.DevFSRuleset)
+	num("children.max", s.ChildrenMax)
+	str("osrelease", s.OSRelease)
+	osreldate := specParam{name: "osreldate", key: "osreldate"}
+	if s.OSRelDate != 0 {
+		osreldate.text, osreldate.value = strconv.Itoa(int(s.OSRelDate)), s.OSRelDate
+	}
+	ps = append(ps, osreldate)
+	str("host.domainname", s.Domainname)
+	str("host.hostuuid", s.HostUUID)
+	hostid := specParam{name: "host.hostid", key: "host.hostid"}
+	if s.HostID != 0 {
+		hostid.text, hostid.value = strconv.FormatInt(s.HostID, 10), s.HostID
+	}
+	ps = append(ps, hostid)
+	sysv := func(name, v string) {
+		if v == "" {
+			v = jailSysNames[jailSysDisable]
+		}
+		n := slices.Index(jailSysNames, v)
+		ps = append(ps, specParam{name: name, text: v, key: name, value: int32(n), implied: n == jailSysDisable})
+	}
+	sysv("sysvmsg", s.SysVMsg)
+	sysv("sysvsem", s.SysVSem)
+	sysv("sysvshm", s.SysVShm)
+	saddrsel := func(name string, v bool) {
+		p := specParam{name: name, text: strconv.FormatBool(v), key: name, value: int32(1), implied: true}
+		if !v {
+			p.key, p.implied = noParam(name), false
+		}
+		ps = append(ps, p)
+	}
+	saddrsel("ip4.saddrsel", s.IP4SAddrSel)
+	saddrsel("ip6.saddrsel", s.IP6SAddrSel)
+	vnet := specParam{name: "vnet", text: strconv.FormatBool(s.Vnet), key: "vnet", value: int32(jailSysNew)}
+	if !s.Vnet {
+		vnet.value, vnet.implied = int32(jailSysInherit), true
+	}
+	ps = append(ps, vnet)
+	boolean("persist", s.Persist, false)
+	for _, perm := range perms {
+		boolean(perm.name, *perm.field(&s.Perms), perm.optional)
+	}
+	return ps
+}
+
+// ip4 returns the IPv4 addresses of the jail, including aliases
+func (s Spec) ip4() []netip.Addr {
+	addrs := s.IP4
+	for _, a := range s.Aliases {
+		if a.Prefix.Addr().Is4() {
+			addrs = append(addrs[:len(addrs):len(addrs)], a.Prefix.Addr())
+		}
+	}
+	return addrs
+}
+
+// ip6 returns the IPv6 addresses of the jail, including aliases
+func (s Spec) ip6() []netip.Addr {
+	addrs := s.IP6
+	for _, a := range s.Aliases {
+		if a.Prefix.Addr().Is6() {
+			addrs = append(addrs[:len(addrs):len(addrs)], a.Prefix.Addr())
+		}
+	}
+	return addrs
+}
+
+// param returns a parameter of a Spec by name
+func (s Spec) param(name string) (specParam, bool) {
+	for _, p := range s.params() {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return specParam{}, false
+}
+
+// addrText formats a list of addresses. The kernel keeps the first
+// address (the primary address) in place and sorts the rest, so the
+// same is done here to compare lists reliably.
+func addrText(addrs []netip.Addr) string {
+	if len(addrs) == 0 {
+		return ""
+	}
+	rest := slices.Clone(addrs[1:])
+	slices.SortFunc(rest, netip.Addr.Compare)
+	s := make([]string, 0, len(addrs))
+	for _, addr := range append([]netip.Addr{addrs[0]}, rest...) {
+		s = append(s, addr.String())
+	}
+	return strings.Join(s, ",")
+}
@@ -8,6 +8,31 @@ const (
 	sysJailGet    = 506
 	sysJailSet    = 507
 	sysJailRemove = 508
+
+	sysCpusetGetAffinity = 487
+	sysCpusetSetAffinity = 488
+
+	sysSysctl = 202
+)
+
+// The kern.proc.proc sysctl, and the kinfo_proc it returns
+const (
+	ctlKern      = 1
+	kernProc     = 14
+	kernProcProc = 8
+	// kinfoProcSize is KINFO_PROC_SIZE on amd64 and arm64
+	kinfoProcSize = 1088
+)
+
+// Arguments of cpuset_getaffinity(2) and cpuset_setaffinity(2)
+const (
+	// cpuLevelWhich is CPU_LEVEL_WHICH: the mask of the object itself
+	cpuLevelWhich = 3
+	// cpuWhichJail is CPU_WHICH_JAIL: the id is a jid
+	cpuWhichJail = 5
+	// cpuSetSize is the number of CPUs in a mask. It is CPU_SETSIZE
+	// of FreeBSD 13, which FreeBSD 14 still accepts.
+	cpuSetSize = 256
 )
 
 const (
@@ -35,6 +60,13 @@ const (
 	GetMaskFlag = uintptr(0x08)
 )
 
+// Values of the jailsys parameters (eg vnet, ip4)
+const (
+	jailSysDisable = 0
+	jailSysNew     = 1
+	jailSysInherit = 2
+)
+
 // jailAPIVersion is the current jail API version.
 const jailAPIVersion uint32 = 2
 
@@ -0,0 +1,157 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/internal/fsutil"
+)
+
+// TemplateDir is the directory that holds templates: each of its
+// subdirectories is a template, named after it, that holds a base
+// system (eg extracted by the provision package)
+var TemplateDir = "/usr/local/jails/templates"
+
+// TemplateBase lists the directories of a template that thin jails
+// share. They are mounted read-only (nullfs) into the root of each
+// thin jail.
+var TemplateBase = []string{"bin", "lib", "libexec", "sbin", "usr"}
+
+// TemplateSkeleton lists the directories of a template that are
+// copied into the root of each thin jail, which can write to them
+var TemplateSkeleton = []string{"etc", "root", "tmp", "var"}
+
+// Template is a base system that thin jails share
+type Template struct {
+	Name string
+	Path string
+	// Jails are the names of the living jails that have the base of
+	// the template mounted in their root
+	Jails []string
+}
+
+// Returns the templates of TemplateDir, with the jails that use each
+// of them
+func Templates() ([]Template, error) {
+	entries, err := os.ReadDir(TemplateDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	mounts, err := mountDriver.Mounts()
+	if err != nil {
+		return nil, err
+	}
+	jails, err := Living()
+	if err != nil {
+		return nil, err
+	}
+	var templates []Template
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		t := Template{Name: e.Name(), Path: filepath.Join(TemplateDir, e.Name())}
+		for _, j := range jails {
+			if t.usedBy(j, mounts) {
+				t.Jails = append(t.Jails, j.Name)
+			}
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// Returns a template of TemplateDir by name
+func LookupTemplate(name string) (Template, error) {
+	if err := validateTemplate(name); err != nil {
+		return Template{}, err
+	}
+	t := Template{Name: name, Path: filepath.Join(TemplateDir, name)}
+	if fi, err := os.Stat(t.Path); err != nil {
+		return Template{}, fmt.Errorf("template %s: %w", name, err)
+	} else if !fi.IsDir() {
+		return Template{}, fmt.Errorf("template %s: not a directory: %s", name, t.Path)
+	}
+	return t, nil
+}
+
+// Creates the root of a thin jail: a copy of the TemplateSkeleton
+// directories of a template, and an empty mount point for each of
+// the TemplateBase directories. The base is mounted when a jail is
+// created from a Spec with the Template. root must not exist, or be
+// empty. File flags of the template are not copied.
+func NewThinRoot(template, root string) error {
+	t, err := LookupTemplate(template)
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(root); err == nil && len(entries) > 0 {
+		return fmt.Errorf("thin root is not empty: %s", root)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+	for _, dir := range TemplateSkeleton {
+		src := filepath.Join(t.Path, dir)
+		if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := fsutil.CopyTree(src, filepath.Join(root, dir)); err != nil {
+			return err
+		}
+	}
+	for _, dir := range TemplateBase {
+		if err := os.Mkdir(filepath.Join(root, dir), 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// usedBy reports whether the base of the template is mounted in the
+// root of a jail
+func (t Template) usedBy(j *Jail, mounts []Mount) bool {
+	return slices.ContainsFunc(mounts, func(m Mount) bool {
+		return m.FSType == "nullfs" && within(m.Source, t.Path) && within(m.Target, j.Path)
+	})
+}
+
+// templateMounts returns the nullfs mounts of the base of the
+// Template of a Spec
+func (s Spec) templateMounts() []Mount {
+	if s.Template == "" {
+		return nil
+	}
+	mounts := make([]Mount, 0, len(TemplateBase))
+	for _, dir := range TemplateBase {
+		mounts = append(mounts, Mount{
+			Source:  filepath.Join(TemplateDir, s.Template, dir),
+			Target:  filepath.Join(s.Path, dir),
+			FSType:  "nullfs",
+			Options: "ro",
+		})
+	}
+	return mounts
+}
+
+func validateTemplate(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid template name: %q", name)
+	}
+	return nil
+}
+
+// within reports whether path is dir, or is under dir
+func within(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
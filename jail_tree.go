@@ -0,0 +1,105 @@
+package jail
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a jail within the jail hierarchy
+type Node struct {
+	*Jail
+	Children []*Node
+}
+
+// Returns the living jails as a hierarchy. The roots are the jails
+// whose parent is the host (or is not visible), and children are
+// ordered by jid.
+func Tree() ([]*Node, error) {
+	jails, err := Living()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[int32]*Node, len(jails))
+	for _, j := range jails {
+		nodes[j.ID] = &Node{Jail: j}
+	}
+	var roots []*Node
+	for _, j := range jails {
+		if parent, ok := nodes[j.Parent]; ok && j.Parent != 0 {
+			parent.Children = append(parent.Children, nodes[j.ID])
+		} else {
+			roots = append(roots, nodes[j.ID])
+		}
+	}
+	return roots, nil
+}
+
+// Returns the living jails whose parent is the jail
+func (j *Jail) Children() ([]*Jail, error) {
+	jails, err := Living()
+	if err != nil {
+		return nil, err
+	}
+	var children []*Jail
+	for _, child := range jails {
+		if child.Parent == j.ID {
+			children = append(children, child)
+		}
+	}
+	return children, nil
+}
+
+// Returns the parent of the jail, its parent, and so on up to (but
+// not including) the host
+func (j *Jail) Ancestors() ([]*Jail, error) {
+	var ancestors []*Jail
+	for parent := j.Parent; parent != 0; {
+		p, err := FindByID(parent)
+		if err != nil {
+			return nil, err
+		}
+		ancestors = append(ancestors, p)
+		parent = p.Parent
+	}
+	return ancestors, nil
+}
+
+// Set the number of child jails the jail may create
+func (j *Jail) SetChildrenMax(n int32) error {
+	if n < 0 || int64(n) > MaxChildJails {
+		return fmt.Errorf("children.max must be between 0 and %d: %d", MaxChildJails, n)
+	}
+	if err := j.SetParam("children.max", n); err != nil {
+		return err
+	}
+	j.ChildrenMax = n
+	return nil
+}
+
+// Creates a child of the jail from a Spec (see Create). The name of
+// the Spec is prefixed with the name of the jail (eg "web" becomes
+// "host.web") unless it already is, and the path is a path on the
+// host. The jail must allow children through children.max, which is
+// read from the kernel along with children.cur, as other children may
+// have been created or removed since the jail was read.
+func (j *Jail) CreateChild(s Spec) (*Jail, error) {
+	if !strings.HasPrefix(s.Name, j.Name+".") {
+		s.Name = j.Name + "." + s.Name
+	}
+	var err error
+	if j.ChildrenMax, err = j.GetInt32("children.max"); err != nil {
+		return nil, err
+	}
+	if j.ChildrenCur, err = j.GetInt32("children.cur"); err != nil {
+		return nil, err
+	}
+	if j.ChildrenCur >= j.ChildrenMax {
+		return nil, fmt.Errorf("jail %q cannot have more than %d children", j.Name, j.ChildrenMax)
+	}
+	child, err := Create(s)
+	if err != nil {
+		return nil, err
+	}
+	j.ChildrenCur++
+	return child, nil
+}
@@ -1,26 +1,43 @@
 package jail
 
 import (
+	"errors"
+	"fmt"
+	"net/netip"
 	"strings"
 
 	"golang.org/x/sys/unix"
 )
 
 type Jail struct {
-	Name          string `json:"name"`
-	Path          string `json:"path"`
-	Hostname      string `json:"hostname"`
-	OSRelease     string `json:"osrelease"`
-	OSRelDate     int32  `json:"osreldate"`
-	ID            int32  `json:"id"`
-	SecureLevel   int32  `json:"securelevel"`
-	Parent        int32  `json:"parent"`
-	EnforceStatFS int32  `json:"enforce_statfs"`
-	DevFSRuleset  int32  `json:"devfs_ruleset"`
-	Vnet          bool   `json:"vnet"`
-	Dying         bool   `json:"dying"`
-	Persist       bool   `json:"persist"`
-	Perms         Perms  `json:"perms"`
+	Name          string       `json:"name"`
+	Path          string       `json:"path"`
+	Hostname      string       `json:"hostname"`
+	OSRelease     string       `json:"osrelease"`
+	OSRelDate     int32        `json:"osreldate"`
+	ID            int32        `json:"id"`
+	SecureLevel   int32        `json:"securelevel"`
+	Parent        int32        `json:"parent"`
+	EnforceStatFS int32        `json:"enforce_statfs"`
+	DevFSRuleset  int32        `json:"devfs_ruleset"`
+	ChildrenMax   int32        `json:"children_max"`
+	ChildrenCur   int32        `json:"children_cur"`
+	IP4           []netip.Addr `json:"ip4_addr"`
+	IP6           []netip.Addr `json:"ip6_addr"`
+	Vnet          bool         `json:"vnet"`
+	Dying         bool         `json:"dying"`
+	Persist       bool         `json:"persist"`
+	Domainname    string       `json:"domainname"`
+	HostUUID      string       `json:"hostuuid"`
+	HostID        int64        `json:"hostid"`
+	SysVMsg       string       `json:"sysvmsg"`
+	SysVSem       string       `json:"sysvsem"`
+	SysVShm       string       `json:"sysvshm"`
+	IP4Inherit    bool         `json:"ip4_inherit"`
+	IP6Inherit    bool         `json:"ip6_inherit"`
+	IP4SAddrSel   bool         `json:"ip4_saddrsel"`
+	IP6SAddrSel   bool         `json:"ip6_saddrsel"`
+	Perms         Perms        `json:"perms"`
 }
 
 type Perms struct {
@@ -55,7 +72,7 @@ func (j *Jail) GetBool(mib string) (bool, error) {
 	params := NewParams()
 	params.Add("jid", j.ID)
 	params.Add(mib, &b)
-	_, err := Get(params, 0)
+	_, err := Get(params, DyingFlag)
 	return b == 1, err
 }
 
@@ -65,7 +82,7 @@ func (j *Jail) GetString(mib string) (string, error) {
 	params := NewParams()
 	params.Add("jid", j.ID)
 	params.Add(mib, b)
-	_, err := Get(params, 0)
+	_, err := Get(params, DyingFlag)
 	return unix.ByteSliceToString(b), err
 }
 
@@ -75,10 +92,65 @@ func (j *Jail) GetInt32(mib string) (int32, error) {
 	params := NewParams()
 	params.Add("jid", j.ID)
 	params.Add(mib, &i)
-	_, err := Get(params, 0)
+	_, err := Get(params, DyingFlag)
 	return i, err
 }
 
+// Get a jail parameter (int64)
+func (j *Jail) GetInt64(mib string) (int64, error) {
+	var i int64
+	params := NewParams()
+	params.Add("jid", j.ID)
+	params.Add(mib, &i)
+	_, err := Get(params, DyingFlag)
+	return i, err
+}
+
+// Get a jail parameter (list of IP addresses). Each address is size
+// bytes long. The kernel reports EINVAL when the list does not fit,
+// and the buffer then grows up to maxAddrsLen. Kernels without
+// support for the address family report no addresses.
+func (j *Jail) getAddrs(mib string, size int) ([]netip.Addr, error) {
+	for n := 1024; ; n *= 2 {
+		b := make([]byte, n)
+		params := NewParams()
+		params.Add("jid", j.ID)
+		params.Add(mib, b)
+		_, err := Get(params, DyingFlag)
+		switch {
+		case errors.Is(err, unix.EINVAL) && n < maxAddrsLen:
+			continue
+		case errors.Is(err, unix.ENOENT):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		var addrs []netip.Addr
+		for i := 0; i+size <= len(b); i += size {
+			addr, _ := netip.AddrFromSlice(b[i : i+size])
+			if addr.IsUnspecified() {
+				break
+			}
+			addrs = append(addrs, addr)
+		}
+		return addrs, nil
+	}
+}
+
+// maxAddrsLen bounds the buffer of getAddrs: 65536 IPv6 addresses,
+// well above the security.jail.jail_max_af_ips limit of the kernel
+const maxAddrsLen = 1 << 20
+
+// encodeAddrs encodes IP addresses as expected by the ip4.addr and
+// ip6.addr parameters
+func encodeAddrs(addrs []netip.Addr) []byte {
+	var b []byte
+	for _, addr := range addrs {
+		b = append(b, addr.AsSlice()...)
+	}
+	return b
+}
+
 // Get a jail parameter (of an unknown type)
 func (j *Jail) GetAny(mib string) (any, error) {
 	var (
@@ -354,6 +426,20 @@ func (j *Jail) SetSecureLevel(level int32) error {
 	return j.SetParam("securelevel", level)
 }
 
+// Set the devfs ruleset applied to devfs mounts of the jail. The
+// ruleset is expected to be loaded already (see the devfs package),
+// and 0 means no ruleset.
+func (j *Jail) SetDevFSRuleset(ruleset int32) error {
+	if ruleset < 0 || ruleset > maxDevFSRuleset {
+		return fmt.Errorf("devfs_ruleset must be between 0 and %d: %d", maxDevFSRuleset, ruleset)
+	}
+	if err := j.SetParam("devfs_ruleset", ruleset); err != nil {
+		return err
+	}
+	j.DevFSRuleset = ruleset
+	return nil
+}
+
 // Attach the current process to a jail
 func (j *Jail) Attach() error {
 	return Attach(j.ID)
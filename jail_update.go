@@ -0,0 +1,62 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrImmutable is returned when a parameter that can only be set
+// on creation would be changed on a live jail
+var ErrImmutable = errors.New("parameter cannot be changed after creation")
+
+// readOnly lists the parameters that are only reported by the kernel
+var readOnly = map[string]bool{
+	"jid":          true,
+	"lastjid":      true,
+	"parent":       true,
+	"children.cur": true,
+	"dying":        true,
+}
+
+// Update a jail to match desired through a single jail_set(2) call.
+// Only the parameters that differ are sent, and allow.* flags that
+// are turned off are sent by their "no" name (eg allow.nomount). The
+// read-only fields of desired (ID, Parent, ChildrenCur, Dying,
+// OSRelease and OSRelDate) are ignored. An error wrapping ErrImmutable is returned,
+// and nothing is changed, when a parameter such as path or vnet
+// would change.
+func (j *Jail) Update(desired Jail) error {
+	desired.OSRelease, desired.OSRelDate = j.OSRelease, j.OSRelDate
+	changes := Diff(j, &desired)
+	if len(changes) == 0 {
+		return nil
+	}
+	for _, c := range changes {
+		if c.Restart {
+			return fmt.Errorf("%w: %s", ErrImmutable, c.Param)
+		}
+	}
+	if _, err := Set(desired.Spec().changed(j.ID, changes), UpdateFlag); err != nil {
+		return err
+	}
+	desired.ID, desired.Parent, desired.Dying = j.ID, j.Parent, j.Dying
+	desired.ChildrenCur = j.ChildrenCur
+	*j = desired
+	return nil
+}
+
+// Set several jail params through a single jail_set(2) call
+func (j *Jail) SetParams(params Params) error {
+	update := NewParams()
+	update.Add("jid", j.ID)
+	for name, v := range params {
+		if immutable[name] || immutable[baseParam(name)] {
+			return fmt.Errorf("%w: %s", ErrImmutable, name)
+		} else if readOnly[name] {
+			return fmt.Errorf("parameter is read-only: %s", name)
+		}
+		update.Add(name, v)
+	}
+	_, err := Set(update, UpdateFlag)
+	return err
+}
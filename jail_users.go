@@ -0,0 +1,10 @@
+package jail
+
+import "git.hardenedbsd.org/0x1eef/jail/users"
+
+// Returns the accounts of the jail. Its master.passwd(5) and group(5)
+// are edited through FS, so that they are confined to the root of
+// the jail.
+func (j *Jail) Users() *users.Accounts {
+	return users.New(j.FS(), j.Path)
+}
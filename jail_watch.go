@@ -0,0 +1,317 @@
+package jail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EventKind is the kind of an Event
+type EventKind int
+
+const (
+	// A jail was created
+	EventCreated EventKind = iota
+	// A jail was removed, and is gone
+	EventRemoved
+	// A jail is dying: it was removed, and some of its processes
+	// are still exiting
+	EventBecameDying
+	// A parameter of a jail changed
+	EventParamChanged
+	// The jails could not be read: see Event.Err
+	EventFailed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventCreated:
+		return "created"
+	case EventRemoved:
+		return "removed"
+	case EventBecameDying:
+		return "dying"
+	case EventParamChanged:
+		return "param-changed"
+	case EventFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event describes a change observed by a Watcher
+type Event struct {
+	Kind EventKind
+	// Jail is the jail, or its last known state when it is gone
+	Jail *Jail
+	// Change is the parameter that changed, with its old and new
+	// values (EventParamChanged only)
+	Change Change
+	// Err is the error that occurred (EventFailed only)
+	Err error
+}
+
+func (e Event) String() string {
+	switch e.Kind {
+	case EventParamChanged:
+		return fmt.Sprintf("%s %d %s: %s", e.Kind, e.Jail.ID, e.Jail.Name, e.Change)
+	case EventFailed:
+		return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+	default:
+		return fmt.Sprintf("%s %d %s", e.Kind, e.Jail.ID, e.Jail.Name)
+	}
+}
+
+// Watcher compares snapshots of the jails taken on each Poll. A jail
+// is identified by its ID.
+type Watcher struct {
+	jails map[int32]*Jail
+}
+
+// Returns a Watcher. Its first Poll reports every jail as created.
+func NewWatcher() *Watcher {
+	return &Watcher{jails: make(map[int32]*Jail)}
+}
+
+// Takes a snapshot of the jails, and returns the events since the
+// previous snapshot. Events are ordered by jail ID, and the events
+// of a jail are ordered by kind, then by parameter (as Diff orders
+// them). The snapshot is kept only when it could be read entirely.
+func (w *Watcher) Poll() ([]Event, error) {
+	jails, err := snapshot()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int32, 0, len(jails)+len(w.jails))
+	for id := range jails {
+		ids = append(ids, id)
+	}
+	for id := range w.jails {
+		if _, ok := jails[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	var events []Event
+	for _, id := range ids {
+		prev, cur := w.jails[id], jails[id]
+		switch {
+		case prev == nil:
+			events = append(events, Event{Kind: EventCreated, Jail: cur})
+			if cur.Dying {
+				events = append(events, Event{Kind: EventBecameDying, Jail: cur})
+			}
+		case cur == nil:
+			events = append(events, Event{Kind: EventRemoved, Jail: prev})
+		default:
+			if cur.Dying && !prev.Dying {
+				events = append(events, Event{Kind: EventBecameDying, Jail: cur})
+			}
+			for _, c := range Diff(prev, cur) {
+				events = append(events, Event{Kind: EventParamChanged, Jail: cur, Change: c})
+			}
+		}
+	}
+	w.jails = jails
+	return events, nil
+}
+
+// Watches the jails, polling them at an interval, and sends an Event
+// for each change (see Watcher). The jails that exist when Watch is
+// called are reported as created. An error is sent as an EventFailed
+// event, and polling goes on. The channel is closed when ctx is done.
+func Watch(ctx context.Context, interval time.Duration) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		w := NewWatcher()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			events, err := w.Poll()
+			if err != nil {
+				events = []Event{{Kind: EventFailed, Err: err}}
+			}
+			for _, e := range events {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// snapshot reads every jail, dying jails included, enumerating them
+// through lastjid. A jail that is removed while the snapshot is taken
+// is left out. Only the parameters that Poll compares are read (see
+// watched).
+func snapshot() (map[int32]*Jail, error) {
+	jails := make(map[int32]*Jail)
+	var (
+		jid      int32
+		optional map[string]bool
+	)
+	for {
+		params := NewParams()
+		params.Add("lastjid", jid)
+		next, err := Get(params, DyingFlag)
+		if errors.Is(err, unix.ENOENT) {
+			return jails, nil
+		} else if err != nil {
+			return nil, err
+		}
+		jid = next
+		if optional == nil {
+			if optional, err = optionalParams(jid); err != nil {
+				return nil, err
+			}
+		}
+		j, err := watched(jid, optional)
+		if errors.Is(err, unix.ENOENT) {
+			// The jail is gone, and the optional parameters may
+			// have been probed on it
+			optional = nil
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		jails[jid] = j
+	}
+}
+
+// watched reads the parameters of a jail that Diff compares, and
+// whether it is dying. They are read with a single jail_get(2), but
+// for the addresses. optional holds the optional parameters that
+// the kernel knows (see optionalParams).
+func watched(jid int32, optional map[string]bool) (*Jail, error) {
+	j := &Jail{ID: jid}
+	params := NewParams()
+	params.Add("jid", jid)
+	strs := map[string]*string{
+		"name":            &j.Name,
+		"path":            &j.Path,
+		"host.hostname":   &j.Hostname,
+		"osrelease":       &j.OSRelease,
+		"host.domainname": &j.Domainname,
+		"host.hostuuid":   &j.HostUUID,
+	}
+	bufs := make(map[string][]byte, len(strs))
+	for mib := range strs {
+		bufs[mib] = make([]byte, 1024)
+		params.Add(mib, bufs[mib])
+	}
+	nums := map[string]*int32{
+		"securelevel":    &j.SecureLevel,
+		"enforce_statfs": &j.EnforceStatFS,
+		"devfs_ruleset":  &j.DevFSRuleset,
+		"children.max":   &j.ChildrenMax,
+		"osreldate":      &j.OSRelDate,
+	}
+	for mib, n := range nums {
+		params.Add(mib, n)
+	}
+	params.Add("host.hostid", &j.HostID)
+	var ip4, ip6 string
+	syss := map[string]*string{
+		"sysvmsg": &j.SysVMsg,
+		"sysvsem": &j.SysVSem,
+		"sysvshm": &j.SysVShm,
+		"ip4":     &ip4,
+		"ip6":     &ip6,
+	}
+	sysValues := make(map[string]*int32, len(syss))
+	for mib, v := range syss {
+		*v = jailSysNames[jailSysDisable]
+		if optional[mib] {
+			sysValues[mib] = new(int32)
+			params.Add(mib, sysValues[mib])
+		}
+	}
+	bools := map[string]*bool{
+		"vnet":    &j.Vnet,
+		"persist": &j.Persist,
+		"dying":   &j.Dying,
+	}
+	for _, perm := range perms {
+		if !perm.optional || optional[perm.name] {
+			bools[perm.name] = perm.field(&j.Perms)
+		}
+	}
+	if optional["ip4.saddrsel"] {
+		bools["ip4.saddrsel"] = &j.IP4SAddrSel
+	}
+	if optional["ip6.saddrsel"] {
+		bools["ip6.saddrsel"] = &j.IP6SAddrSel
+	}
+	values := make(map[string]*int32, len(bools))
+	for mib := range bools {
+		values[mib] = new(int32)
+		params.Add(mib, values[mib])
+	}
+	if _, err := Get(params, DyingFlag); err != nil {
+		return nil, err
+	}
+	for mib, s := range strs {
+		*s = unix.ByteSliceToString(bufs[mib])
+	}
+	for mib, b := range bools {
+		*b = *values[mib] == 1
+	}
+	for mib, v := range sysValues {
+		if *v < 0 || int(*v) >= len(jailSysNames) {
+			return nil, fmt.Errorf("%s: unknown value: %d", mib, *v)
+		}
+		*syss[mib] = jailSysNames[*v]
+	}
+	j.IP4Inherit = ip4 == jailSysNames[jailSysInherit]
+	j.IP6Inherit = ip6 == jailSysNames[jailSysInherit]
+	var err error
+	if j.IP4, err = j.getAddrs("ip4.addr", 4); err != nil {
+		return nil, err
+	}
+	if j.IP6, err = j.getAddrs("ip6.addr", 16); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// optionalParams reports which of the optional parameters the kernel
+// knows (the optional allow.* parameters, and those of kernel options
+// such as SYSVMSG and INET), by reading them from a jail
+func optionalParams(jid int32) (map[string]bool, error) {
+	names := []string{"sysvmsg", "sysvsem", "sysvshm", "ip4", "ip6", "ip4.saddrsel", "ip6.saddrsel"}
+	for _, perm := range perms {
+		if perm.optional {
+			names = append(names, perm.name)
+		}
+	}
+	known := make(map[string]bool)
+	for _, name := range names {
+		var n int32
+		params := NewParams()
+		params.Add("jid", jid)
+		params.Add(name, &n)
+		_, err := Get(params, DyingFlag)
+		switch {
+		case err == nil:
+			known[name] = true
+		case errors.Is(err, unix.EINVAL), errors.Is(err, unix.ENOENT):
+		default:
+			return nil, err
+		}
+	}
+	return known, nil
+}
@@ -0,0 +1,568 @@
+// Package jailtest provides a fake jail.Kernel that keeps jails in
+// memory, so code built on the jail package can be tested on any
+// platform and without privileges.
+package jailtest
+
+import (
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"golang.org/x/sys/unix"
+)
+
+// Kernel is a fake jail.Kernel. Parameters are validated much like
+// the FreeBSD kernel validates them, and errors are reported with the
+// same errno values.
+type Kernel struct {
+	mu       sync.Mutex
+	jails    map[int32]map[string]any
+	cpusets  map[int32][]int
+	lastjid  int32
+	attached []int32
+	procs    []byte
+	signals  []Signal
+}
+
+// Signal is a signal sent to a process through Kill
+type Signal struct {
+	PID int32
+	Sig unix.Signal
+}
+
+// CPUs is the number of CPUs of a Kernel. A jail may run on every
+// CPU until its cpuset is changed.
+const CPUs = 8
+
+var (
+	// allow lists the allow.* parameters, and whether they are
+	// enabled for a new jail
+	allow = map[string]bool{
+		"allow.set_hostname":                  true,
+		"allow.reserved_ports":                true,
+		"allow.suser":                         true,
+		"allow.unprivileged_proc_debug":       true,
+		"allow.chflags":                       false,
+		"allow.raw_sockets":                   false,
+		"allow.mount":                         false,
+		"allow.mount.devfs":                   false,
+		"allow.mount.procfs":                  false,
+		"allow.mount.tmpfs":                   false,
+		"allow.mount.nullfs":                  false,
+		"allow.mount.zfs":                     false,
+		"allow.mlock":                         false,
+		"allow.read_msgbuf":                   false,
+		"allow.socket_af":                     false,
+		"allow.quotas":                        false,
+		"allow.extattr":                       false,
+		"allow.routing":                       false,
+		"allow.settime":                       false,
+		"allow.adjtime":                       false,
+		"allow.setaudit":                      false,
+		"allow.unprivileged_parent_tampering": false,
+		"allow.vmm":                           false,
+	}
+	// readOnly lists parameters that are only reported by the kernel
+	readOnly = map[string]bool{
+		"parent":       true,
+		"children.cur": true,
+		"dying":        true,
+	}
+	// immutable lists parameters that can only be set on creation
+	immutable = map[string]bool{
+		"path":      true,
+		"vnet":      true,
+		"osrelease": true,
+		"osreldate": true,
+	}
+)
+
+// Returns a Kernel without any jails
+func NewKernel() *Kernel {
+	return &Kernel{jails: make(map[int32]map[string]any), cpusets: make(map[int32][]int)}
+}
+
+// Installs a new Kernel for the duration of a test
+func Use(t testing.TB) *Kernel {
+	k := NewKernel()
+	prev := jail.SetKernel(k)
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	return k
+}
+
+// Returns the IDs of the jails that have been attached to
+func (k *Kernel) Attached() []int32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]int32{}, k.attached...)
+}
+
+// jail_set(2)
+func (k *Kernel) Set(params jail.Params, flags uintptr) (int32, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	values := make(map[string]any, len(params))
+	for key, v := range params {
+		dv, ok := decode(v)
+		if !ok {
+			return 0, unix.EINVAL
+		}
+		values[key] = dv
+	}
+	jid, j := k.lookup(values, flags)
+	switch {
+	case j != nil && flags&jail.UpdateFlag == 0:
+		return 0, unix.EEXIST
+	case j == nil && flags&jail.CreateFlag == 0:
+		return 0, unix.ENOENT
+	case j == nil && k.jails[jid] != nil:
+		// The jid of a dying jail
+		return 0, unix.EEXIST
+	}
+	created := j == nil
+	if created {
+		if jid == 0 {
+			jid = k.lastjid + 1
+		}
+		j = defaults(jid)
+		if name, ok := values["name"].(string); ok {
+			parent, err := k.parent(name)
+			if err != nil {
+				return 0, err
+			}
+			j["parent"] = int64(parent)
+		}
+	}
+	changes := make(map[string]any, len(values))
+	for key, v := range values {
+		if key == "jid" {
+			continue
+		}
+		if readOnly[key] || (!created && immutable[key]) {
+			return 0, unix.EINVAL
+		}
+		name, nv, ok := normalize(j, key, v)
+		if !ok {
+			return 0, unix.EINVAL
+		}
+		changes[name] = nv
+		switch name {
+		case "ip4.addr", "ip6.addr":
+			changes[name[:3]] = int64(1)
+		case "ip4", "ip6":
+			// Only a jail with its own addresses (ip4=new) keeps them
+			if nv != int64(1) {
+				changes[name+".addr"] = []byte(nil)
+			}
+		}
+	}
+	if name, ok := changes["name"].(string); ok {
+		if other := k.byName(name); other != nil && other["jid"] != j["jid"] {
+			return 0, unix.EEXIST
+		}
+	}
+	for name, v := range changes {
+		j[name] = v
+	}
+	if created {
+		k.jails[jid] = j
+		if jid > k.lastjid {
+			k.lastjid = jid
+		}
+		if parent := k.jails[int32(j["parent"].(int64))]; parent != nil {
+			parent["children.cur"] = parent["children.cur"].(int64) + 1
+		}
+	}
+	if flags&jail.AttachFlag != 0 {
+		k.attached = append(k.attached, jid)
+	}
+	return jid, nil
+}
+
+// jail_get(2). As with the kernel, a dying jail is only found with
+// DyingFlag (see lookup).
+func (k *Kernel) Get(params jail.Params, flags uintptr) (int32, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var (
+		jid int32
+		j   map[string]any
+	)
+	if v, ok := params["lastjid"]; ok {
+		last, _ := decode(v)
+		for _, id := range k.ids() {
+			if int64(id) > last.(int64) && (flags&jail.DyingFlag != 0 || !dying(k.jails[id])) {
+				jid, j = id, k.jails[id]
+				break
+			}
+		}
+	} else {
+		values := make(map[string]any, 2)
+		for _, key := range []string{"jid", "name"} {
+			if v, ok := params[key]; ok && isInput(v) {
+				values[key], _ = decode(v)
+			}
+		}
+		jid, j = k.lookup(values, flags)
+	}
+	if j == nil {
+		return 0, unix.ENOENT
+	}
+	for key, v := range params {
+		if key == "lastjid" || isInput(v) {
+			continue
+		}
+		stored, ok := j[key]
+		if !ok {
+			return 0, unix.EINVAL
+		}
+		if err := encode(v, stored); err != nil {
+			return 0, err
+		}
+	}
+	return jid, nil
+}
+
+// jail_remove(2)
+func (k *Kernel) Remove(jid int32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	j, ok := k.jails[jid]
+	if !ok {
+		return unix.EINVAL
+	}
+	prefix := j["name"].(string) + "."
+	for id, child := range k.jails {
+		if strings.HasPrefix(child["name"].(string), prefix) {
+			delete(k.jails, id)
+			delete(k.cpusets, id)
+		}
+	}
+	delete(k.jails, jid)
+	delete(k.cpusets, jid)
+	if parent := k.jails[int32(j["parent"].(int64))]; parent != nil {
+		parent["children.cur"] = parent["children.cur"].(int64) - 1
+	}
+	return nil
+}
+
+// Marks a jail as dying, as the kernel does when a jail is removed
+// while some of its processes are still exiting
+func (k *Kernel) SetDying(jid int32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	j, ok := k.jails[jid]
+	if !ok {
+		return unix.EINVAL
+	}
+	j["dying"] = int64(1)
+	return nil
+}
+
+// dying reports whether a jail is dying
+func dying(j map[string]any) bool {
+	return j != nil && j["dying"] == int64(1)
+}
+
+// jail_attach(2)
+func (k *Kernel) Attach(jid int32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.jails[jid]; !ok {
+		return unix.EINVAL
+	}
+	k.attached = append(k.attached, jid)
+	return nil
+}
+
+// cpuset_getaffinity(2)
+func (k *Kernel) GetCPUSet(jid int32) ([]int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.jails[jid]; !ok {
+		return nil, unix.ESRCH
+	}
+	if cpus, ok := k.cpusets[jid]; ok {
+		return append([]int{}, cpus...), nil
+	}
+	cpus := make([]int, CPUs)
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus, nil
+}
+
+// cpuset_setaffinity(2)
+func (k *Kernel) SetCPUSet(jid int32, cpus []int) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.jails[jid]; !ok {
+		return unix.ESRCH
+	}
+	if len(cpus) == 0 {
+		return unix.EDEADLK
+	}
+	set := make([]int, 0, len(cpus))
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= CPUs {
+			return unix.EINVAL
+		}
+		set = append(set, cpu)
+	}
+	slices.Sort(set)
+	k.cpusets[jid] = slices.Compact(set)
+	return nil
+}
+
+// Sets the kinfo_proc records returned by Procs (eg a capture of the
+// kern.proc sysctl)
+func (k *Kernel) SetProcs(b []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.procs = append([]byte(nil), b...)
+}
+
+// Returns the signals sent through Kill
+func (k *Kernel) Signals() []Signal {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]Signal{}, k.signals...)
+}
+
+// sysctl(3) kern.proc.proc
+func (k *Kernel) Procs() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]byte(nil), k.procs...), nil
+}
+
+// kill(2). Only the processes of SetProcs exist.
+func (k *Kernel) Kill(pid int32, sig unix.Signal) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	procs, err := jail.ParseKinfoProc(k.procs)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(procs, func(p jail.Process) bool { return p.PID == pid }) {
+		return unix.ESRCH
+	}
+	k.signals = append(k.signals, Signal{pid, sig})
+	return nil
+}
+
+// lookup finds a jail by the jid or name parameter. As with the
+// kernel, a dying jail is only found with DyingFlag, and does not
+// keep a new jail from taking its name.
+func (k *Kernel) lookup(values map[string]any, flags uintptr) (int32, map[string]any) {
+	if v, ok := values["jid"].(int64); ok && v != 0 {
+		if j := k.jails[int32(v)]; flags&jail.DyingFlag != 0 || !dying(j) {
+			return int32(v), j
+		}
+		return int32(v), nil
+	}
+	if name, ok := values["name"].(string); ok {
+		if j := k.byName(name); j != nil {
+			return int32(j["jid"].(int64)), j
+		}
+		if j := k.dyingByName(name); j != nil && flags&jail.DyingFlag != 0 {
+			return int32(j["jid"].(int64)), j
+		}
+	}
+	return 0, nil
+}
+
+// byName returns the living jail of a name
+func (k *Kernel) byName(name string) map[string]any {
+	for _, j := range k.jails {
+		if j["name"] == name && !dying(j) {
+			return j
+		}
+	}
+	return nil
+}
+
+// dyingByName returns a dying jail of a name
+func (k *Kernel) dyingByName(name string) map[string]any {
+	for _, j := range k.jails {
+		if j["name"] == name && dying(j) {
+			return j
+		}
+	}
+	return nil
+}
+
+// parent returns the ID of the parent of a hierarchical jail name
+// (eg "a" for "a.b"), or 0 for a top-level jail
+func (k *Kernel) parent(name string) (int32, error) {
+	i := strings.LastIndexByte(name, '.')
+	if i == -1 {
+		return 0, nil
+	}
+	parent := k.byName(name[:i])
+	if parent == nil {
+		return 0, unix.ENOENT
+	}
+	if parent["children.cur"].(int64) >= parent["children.max"].(int64) {
+		return 0, unix.EPERM
+	}
+	return int32(parent["jid"].(int64)), nil
+}
+
+func (k *Kernel) ids() []int32 {
+	ids := make([]int32, 0, len(k.jails))
+	for id := range k.jails {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids
+}
+
+// defaults returns the parameters of a new jail
+func defaults(jid int32) map[string]any {
+	j := map[string]any{
+		"jid":             int64(jid),
+		"name":            strconv.Itoa(int(jid)),
+		"path":            "/",
+		"host.hostname":   "",
+		"osrelease":       "14.3-RELEASE",
+		"osreldate":       int64(1403000),
+		"securelevel":     int64(-1),
+		"enforce_statfs":  int64(2),
+		"devfs_ruleset":   int64(0),
+		"parent":          int64(0),
+		"children.max":    int64(0),
+		"children.cur":    int64(0),
+		"vnet":            int64(2),
+		"dying":           int64(0),
+		"persist":         int64(0),
+		"host.domainname": "",
+		"host.hostuuid":   "00000000-0000-0000-0000-000000000000",
+		"host.hostid":     int64(0),
+		"sysvmsg":         int64(0),
+		"sysvsem":         int64(0),
+		"sysvshm":         int64(0),
+		"ip4.saddrsel":    int64(1),
+		"ip6.saddrsel":    int64(1),
+		"ip4":             int64(0),
+		"ip6":             int64(0),
+		"ip4.addr":        []byte(nil),
+		"ip6.addr":        []byte(nil),
+	}
+	for name, on := range allow {
+		j[name] = int64(0)
+		if on {
+			j[name] = int64(1)
+		}
+	}
+	return j
+}
+
+// normalize resolves "no" parameters (eg allow.nomount=1 becomes
+// allow.mount=0), and checks the value matches the parameter type
+func normalize(j map[string]any, key string, v any) (string, any, bool) {
+	if _, ok := j[key]; !ok {
+		i := strings.IndexByte(key, '.') + 1
+		if !strings.HasPrefix(key[i:], "no") {
+			return "", nil, false
+		}
+		base := key[:i] + key[i+2:]
+		if _, ok := j[base]; !ok {
+			return "", nil, false
+		}
+		n, ok := v.(int64)
+		if !ok {
+			return "", nil, false
+		}
+		return base, int64(1) - n, true
+	}
+	if reflect.TypeOf(j[key]) != reflect.TypeOf(v) {
+		return "", nil, false
+	}
+	return key, v, true
+}
+
+// decode converts a parameter value into a string, int64 or []byte
+func decode(v any) (any, bool) {
+	switch vv := v.(type) {
+	case string:
+		return vv, true
+	case []byte:
+		return append([]byte(nil), vv...), true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return int64(1), true
+		}
+		return int64(0), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	default:
+		return nil, false
+	}
+}
+
+// encode copies a stored value into the buffer of a jail_get(2) call
+func encode(out any, stored any) error {
+	switch buf := out.(type) {
+	case []byte:
+		switch s := stored.(type) {
+		case string:
+			if len(s) >= len(buf) {
+				return unix.ENAMETOOLONG
+			}
+			copy(buf, s)
+			buf[len(s)] = 0
+		case []byte:
+			if len(s) > len(buf) {
+				return unix.EINVAL
+			}
+			copy(buf, s)
+		default:
+			return unix.EINVAL
+		}
+		return nil
+	}
+	n, ok := stored.(int64)
+	if !ok {
+		return unix.EINVAL
+	}
+	switch p := out.(type) {
+	case *int32:
+		*p = int32(n)
+	case *int64:
+		*p = n
+	case *int:
+		*p = int(n)
+	case *bool:
+		*p = n != 0
+	default:
+		return unix.EINVAL
+	}
+	return nil
+}
+
+// isInput reports whether a jail_get(2) parameter is an input
+// (eg a jid or name to look up) rather than a buffer to fill in
+func isInput(v any) bool {
+	switch v.(type) {
+	case []byte:
+		return false
+	case string:
+		return true
+	}
+	return reflect.ValueOf(v).Kind() != reflect.Ptr
+}
@@ -0,0 +1,22 @@
+package provision
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sets the file flags of a path through lchflags(2): a symbolic link
+// is never followed, so that the flags cannot reach a file outside
+// of the root an archive is extracted into
+func Chflags(path string, flags uint32) error {
+	p, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	_, _, e1 := unix.Syscall(unix.SYS_LCHFLAGS, uintptr(unsafe.Pointer(p)), uintptr(flags), 0)
+	if e1 != 0 {
+		return e1
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !freebsd
+
+package provision
+
+import "errors"
+
+// Reports an error: file flags are only supported on FreeBSD
+func Chflags(path string, flags uint32) error {
+	return errors.New("file flags are not supported")
+}
@@ -0,0 +1,52 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fileFlags maps the names of strtofflags(3) to the flags of
+// chflags(2) (sys/stat.h)
+var fileFlags = map[string]uint32{
+	"nodump":     0x00000001,
+	"uchg":       0x00000002,
+	"uchange":    0x00000002,
+	"uimmutable": 0x00000002,
+	"uappnd":     0x00000004,
+	"uappend":    0x00000004,
+	"opaque":     0x00000008,
+	"uunlnk":     0x00000010,
+	"uunlink":    0x00000010,
+	"uhidden":    0x00008000,
+	"hidden":     0x00008000,
+	"arch":       0x00010000,
+	"archived":   0x00010000,
+	"schg":       0x00020000,
+	"schange":    0x00020000,
+	"simmutable": 0x00020000,
+	"sappnd":     0x00040000,
+	"sappend":    0x00040000,
+	"sunlnk":     0x00100000,
+	"sunlink":    0x00100000,
+}
+
+// Parses a comma-separated list of file flags, as ls(1) -o prints
+// them (eg "schg,nodump"). A flag can be cleared by its "no" name
+// (eg "nouchg"), and "" is no flags.
+func ParseFlags(s string) (uint32, error) {
+	var flags uint32
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if f, ok := fileFlags[name]; ok {
+			flags |= f
+		} else if f, ok := fileFlags[strings.TrimPrefix(name, "no")]; ok && name != "nodump" {
+			flags &^= f
+		} else {
+			return 0, fmt.Errorf("unknown file flag: %q", name)
+		}
+	}
+	return flags, nil
+}
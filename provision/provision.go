@@ -0,0 +1,208 @@
+// Package provision installs a FreeBSD base system into the root of
+// a jail from the distribution sets of a release (eg base.txz), and
+// seeds the files a new jail needs.
+package provision
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Release is a local directory that holds the distribution sets of
+// a release and their MANIFEST (eg a copy of
+// https://download.freebsd.org/releases/amd64/14.1-RELEASE/)
+type Release struct {
+	Dir string
+	// Decompress returns the tar archive of a distribution set
+	// (XZ when nil)
+	Decompress func(r io.Reader) (io.ReadCloser, error)
+	// Chflags sets the file flags of a path without following a
+	// symbolic link (lchflags(2) when nil)
+	Chflags func(path string, flags uint32) error
+}
+
+// Manifest maps the file of each distribution set (eg base.txz) to
+// its sha256 sum
+type Manifest map[string]string
+
+// Reads the MANIFEST of the release
+func (r *Release) Manifest() (Manifest, error) {
+	f, err := os.Open(filepath.Join(r.Dir, "MANIFEST"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseManifest(f)
+}
+
+// Parses a MANIFEST. Each line has the file of a distribution set,
+// its sha256 sum, and other fields that are ignored, separated by
+// tabs.
+func ParseManifest(r io.Reader) (Manifest, error) {
+	m := make(Manifest)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("MANIFEST: line %d: expected a file and its sha256 sum", n)
+		}
+		sum := strings.ToLower(fields[1])
+		if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("MANIFEST: line %d: invalid sha256 sum: %q", n, fields[1])
+		}
+		m[fields[0]] = sum
+	}
+	return m, s.Err()
+}
+
+// Reports an error when the file of a distribution set (eg "base")
+// does not match the sha256 sum of the MANIFEST
+func (r *Release) Verify(set string) error {
+	m, err := r.Manifest()
+	if err != nil {
+		return err
+	}
+	return r.verify(m, set)
+}
+
+// Extracts distribution sets (eg "base" and "lib32") into root,
+// which is created when it does not exist. Each set is hashed as it
+// is extracted, and checked against the MANIFEST before the file
+// flags and directory modes of its entries are set: a set that does
+// not match is an error, and its files are left without them.
+func (r *Release) Extract(root string, sets ...string) error {
+	m, err := r.Manifest()
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if _, ok := m[file(set)]; !ok {
+			return fmt.Errorf("%s is not listed in the MANIFEST", file(set))
+		}
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if err := r.extract(root, set, m[file(set)]); err != nil {
+			return fmt.Errorf("%s: %w", file(set), err)
+		}
+	}
+	return nil
+}
+
+func (r *Release) verify(m Manifest, set string) error {
+	want, ok := m[file(set)]
+	if !ok {
+		return fmt.Errorf("%s is not listed in the MANIFEST", file(set))
+	}
+	f, err := os.Open(filepath.Join(r.Dir, file(set)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if err := checkSum(h, want); err != nil {
+		return fmt.Errorf("%s: %w", file(set), err)
+	}
+	return nil
+}
+
+// extract extracts a set from a single read of its file, which is
+// hashed on the way to the decompressor
+func (r *Release) extract(root, set, want string) error {
+	f, err := os.Open(filepath.Join(r.Dir, file(set)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decompress := r.Decompress
+	if decompress == nil {
+		decompress = XZ
+	}
+	h := sha256.New()
+	rc, err := decompress(io.TeeReader(f, h))
+	if err != nil {
+		return err
+	}
+	chflags := r.Chflags
+	if chflags == nil {
+		chflags = Chflags
+	}
+	closed := false
+	err = untar(rc, root, chflags, func() error {
+		// The archive can end before its file does (eg the padding
+		// of tar(1) or a trailer of the compression): the rest is
+		// read and hashed before the sum is checked
+		if _, err := io.Copy(io.Discard, rc); err != nil {
+			return err
+		}
+		closed = true
+		if err := rc.Close(); err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		return checkSum(h, want)
+	})
+	if !closed {
+		rc.Close()
+	}
+	return err
+}
+
+// checkSum reports an error when the sum of h is not want
+func checkSum(h hash.Hash, want string) error {
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != want {
+		return fmt.Errorf("sha256 mismatch: expected %s but got %s", want, sum)
+	}
+	return nil
+}
+
+// Decompresses an xz stream through xz(1)
+func XZ(r io.Reader) (io.ReadCloser, error) {
+	cmd := exec.Command("xz", "-dc")
+	cmd.Stdin = r
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdReader{ReadCloser: out, cmd: cmd}, nil
+}
+
+// cmdReader reads the output of a command, and waits for it on Close
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdReader) Close() error {
+	c.ReadCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("xz: %w", err)
+	}
+	return nil
+}
+
+// file returns the file of a distribution set
+func file(set string) string {
+	return set + ".txz"
+}
@@ -0,0 +1,102 @@
+package provision
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/rcconf"
+)
+
+// Seed is the configuration that a jail needs on its first start,
+// and that a base system leaves out
+type Seed struct {
+	// ResolvConf is copied to /etc/resolv.conf ("" leaves it out)
+	ResolvConf string
+	// LocalTime is copied to /etc/localtime ("" leaves it out)
+	LocalTime string
+	// RCConf are the variables of /etc/rc.conf
+	RCConf map[string]string
+}
+
+// Returns a Seed that copies the resolver and time zone of the host,
+// and configures rc(8) for a jail: no sendmail(8), and a syslogd(8)
+// that does not listen on the network
+func DefaultSeed(hostname string) Seed {
+	return Seed{
+		ResolvConf: "/etc/resolv.conf",
+		LocalTime:  "/etc/localtime",
+		RCConf: map[string]string{
+			"hostname":         hostname,
+			"sendmail_enable":  "NONE",
+			"syslogd_flags":    "-ss",
+			"clear_tmp_enable": "YES",
+			"cron_flags":       "-J 60",
+		},
+	}
+}
+
+// Writes the files of the Seed into root. An existing /etc/rc.conf
+// is left as it is, and the variables of RCConf are quoted as
+// rcconf.File.Set does (an invalid variable name is an error). As with Untar, a path under a symbolic link is
+// an error.
+func (s Seed) Apply(root string) error {
+	for _, f := range []struct{ src, name string }{
+		{s.ResolvConf, "etc/resolv.conf"},
+		{s.LocalTime, "etc/localtime"},
+	} {
+		if f.src == "" {
+			continue
+		}
+		b, err := os.ReadFile(f.src)
+		if err != nil {
+			return err
+		}
+		if err := writeRoot(root, f.name, b, 0o644); err != nil {
+			return err
+		}
+	}
+	if len(s.RCConf) == 0 {
+		return nil
+	}
+	if _, err := os.Lstat(filepath.Join(root, "etc", "rc.conf")); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	names := make([]string, 0, len(s.RCConf))
+	for name := range s.RCConf {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	rc, err := rcconf.Parse(strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := rc.Set(name, s.RCConf[name]); err != nil {
+			return err
+		}
+	}
+	return writeRoot(root, "etc/rc.conf", rc.Bytes(), 0o644)
+}
+
+// writeRoot writes a file at a path relative to root
+func writeRoot(root, name string, b []byte, mode fs.FileMode) error {
+	name = filepath.FromSlash(name)
+	if err := mkdirParents(root, name); err != nil {
+		return err
+	}
+	p := filepath.Join(root, name)
+	if err := replace(p); err != nil {
+		return err
+	}
+	if err := writeFile(p, bytes.NewReader(b)); err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
@@ -0,0 +1,276 @@
+package provision
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// attrs are attributes that are set once every entry is extracted:
+// the mode of a directory could forbid creating its entries, and a
+// file flag (eg schg) could forbid changing the file
+type attrs struct {
+	path  string
+	dir   bool
+	mode  fs.FileMode
+	mtime time.Time
+	flags uint32
+}
+
+// Extracts a tar archive into root, with the modes, owners (when run
+// as root), hardlinks and file flags (the SCHILY.fflags record of
+// bsdtar(1)) of its entries. An entry outside of root, or under a
+// symbolic link, is an error: symbolic links of the archive are
+// created, but never followed.
+func Untar(r io.Reader, root string, chflags func(path string, flags uint32) error) error {
+	return untar(r, root, chflags, nil)
+}
+
+// untar is Untar, with a check that runs once every entry is
+// extracted: the attributes of the entries are only set when it
+// returns nil
+func untar(r io.Reader, root string, chflags func(path string, flags uint32) error, check func() error) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	var (
+		tr       = tar.NewReader(r)
+		owner    = os.Geteuid() == 0
+		deferred []attrs
+	)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		name, err := clean(h.Name)
+		if err != nil {
+			return err
+		} else if name == "" {
+			continue
+		}
+		p := filepath.Join(root, name)
+		// The entry replaces any earlier entry at its path, and the
+		// entries beneath it: their attributes are not set
+		deferred = slices.DeleteFunc(deferred, func(a attrs) bool { return within(a.path, p) })
+		if err := mkdirParents(root, name); err != nil {
+			return err
+		}
+		flags, err := ParseFlags(h.PAXRecords["SCHILY.fflags"])
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.Name, err)
+		}
+		mode := h.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := mkdir(p); err != nil {
+				return err
+			}
+			deferred = append(deferred, attrs{path: p, dir: true, mode: mode, mtime: h.ModTime, flags: flags})
+			if owner {
+				if err := os.Lchown(p, h.Uid, h.Gid); err != nil {
+					return err
+				}
+			}
+			continue
+		case tar.TypeReg:
+			if err := replace(p); err != nil {
+				return err
+			}
+			if err := writeFile(p, tr); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := clean(h.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := mkdirParents(root, target); err != nil {
+				return err
+			}
+			fi, err := os.Lstat(filepath.Join(root, target))
+			if err != nil {
+				return fmt.Errorf("%s: hardlink target: %w", h.Name, err)
+			} else if !fi.Mode().IsRegular() {
+				return fmt.Errorf("%s: hardlink target is not a regular file: %s", h.Name, h.Linkname)
+			}
+			if err := replace(p); err != nil {
+				return err
+			}
+			if err := os.Link(filepath.Join(root, target), p); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeSymlink:
+			if err := replace(p); err != nil {
+				return err
+			}
+			if err := os.Symlink(h.Linkname, p); err != nil {
+				return err
+			}
+			if owner {
+				if err := os.Lchown(p, h.Uid, h.Gid); err != nil {
+					return err
+				}
+			}
+			continue
+		case tar.TypeFifo:
+			if err := replace(p); err != nil {
+				return err
+			}
+			if err := unix.Mkfifo(p, 0o600); err != nil {
+				return fmt.Errorf("%s: %w", h.Name, err)
+			}
+		default:
+			return fmt.Errorf("%s: unsupported entry type: %q", h.Name, h.Typeflag)
+		}
+		if owner {
+			if err := os.Lchown(p, h.Uid, h.Gid); err != nil {
+				return err
+			}
+		}
+		// chown(2) clears the setuid and setgid bits: the mode is
+		// set after the owner
+		if err := os.Chmod(p, mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(p, h.ModTime, h.ModTime); err != nil {
+			return err
+		}
+		if flags != 0 {
+			deferred = append(deferred, attrs{path: p, flags: flags})
+		}
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	// Entries are set before the directories that hold them
+	for i := len(deferred) - 1; i >= 0; i-- {
+		a := deferred[i]
+		fi, err := os.Lstat(a.path)
+		if err != nil {
+			return err
+		} else if fi.Mode()&fs.ModeSymlink != 0 || fi.IsDir() != a.dir {
+			return fmt.Errorf("%s: replaced during extraction", a.path)
+		}
+		if a.dir {
+			if err := os.Chmod(a.path, a.mode); err != nil {
+				return err
+			}
+			if err := os.Chtimes(a.path, a.mtime, a.mtime); err != nil {
+				return err
+			}
+		}
+		if a.flags != 0 {
+			if err := chflags(a.path, a.flags); err != nil {
+				return fmt.Errorf("chflags %s: %w", a.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clean returns the path of an entry relative to the root, or "" for
+// the root itself. An absolute path, or a path that leaves the root,
+// is an error.
+func clean(name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("absolute path in archive: %s", name)
+	}
+	c := path.Clean(name)
+	if c == ".." || strings.HasPrefix(c, "../") {
+		return "", fmt.Errorf("path leaves the root: %s", name)
+	} else if c == "." {
+		return "", nil
+	}
+	return filepath.FromSlash(c), nil
+}
+
+// within reports whether p is dir, or is beneath dir
+func within(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// mkdirParents creates the missing parent directories of an entry,
+// and reports an error when a parent is not a directory (eg a
+// symbolic link)
+func mkdirParents(root, name string) error {
+	dir := root
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !fi.IsDir():
+			return fmt.Errorf("%s: not a directory: %s", name, dir)
+		}
+	}
+	return nil
+}
+
+// mkdir creates a directory, and replaces any other file at its path
+func mkdir(p string) error {
+	fi, err := os.Lstat(p)
+	switch {
+	case err == nil && fi.IsDir():
+		return nil
+	case err == nil:
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	return os.Mkdir(p, 0o700)
+}
+
+// replace removes the file at a path before an entry is extracted to
+// it. A directory is not replaced.
+func replace(p string) error {
+	fi, err := os.Lstat(p)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case fi.IsDir():
+		return fmt.Errorf("%s: is a directory", p)
+	}
+	return os.Remove(p)
+}
+
+func writeFile(p string, r io.Reader) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
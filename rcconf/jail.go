@@ -0,0 +1,115 @@
+package rcconf
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrEveryJail is returned by DisableJail when rc(8) starts every jail
+// of jail.conf(5): an empty jail_list cannot leave a single jail out
+var ErrEveryJail = errors.New("rcconf: jail_list is empty, and every jail starts at boot")
+
+// Reports whether rc(8) starts jails at boot (jail_enable)
+func (f *File) JailEnabled() bool {
+	return f.Bool("jail_enable")
+}
+
+// Returns the jails of jail_list, in the order rc(8) starts them
+func (f *File) JailList() []string {
+	v, _ := f.Get("jail_list")
+	return strings.Fields(v)
+}
+
+// Returns the jails rc(8) starts at boot. all is true when jails are
+// enabled and jail_list is empty: rc(8) then starts every jail of
+// jail.conf(5).
+func (f *File) BootJails() (names []string, all bool) {
+	if !f.JailEnabled() {
+		return nil, false
+	}
+	names = f.JailList()
+	return names, len(names) == 0
+}
+
+// Enables a jail at boot: jail_enable is set, and the jail is added
+// to jail_list. A jail_list that was empty, which started every jail,
+// then starts this jail only.
+func (f *File) EnableJail(name string) error {
+	if err := f.Set("jail_enable", "YES"); err != nil {
+		return err
+	}
+	list := f.JailList()
+	if slices.Contains(list, name) {
+		return nil
+	}
+	return f.Set("jail_list", strings.Join(append(list, name), " "))
+}
+
+// Disables a jail at boot: the jail is removed from jail_list. When
+// no jail is left, jail_enable is turned off, as an empty jail_list
+// would start every jail. ErrEveryJail is returned when jail_list is
+// already empty with jail_enable on: the jails to start are then to
+// be listed with EnableJail first.
+func (f *File) DisableJail(name string) error {
+	list := f.JailList()
+	if _, all := f.BootJails(); all {
+		return ErrEveryJail
+	}
+	if !slices.Contains(list, name) {
+		return nil
+	}
+	list = slices.DeleteFunc(list, func(s string) bool { return s == name })
+	if err := f.Set("jail_list", strings.Join(list, " ")); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return f.Set("jail_enable", "NO")
+	}
+	return nil
+}
+
+// Reports whether rc(8) starts jails in parallel
+// (jail_parallel_start)
+func (f *File) ParallelStart() bool {
+	return f.Bool("jail_parallel_start")
+}
+
+// Sets jail_parallel_start
+func (f *File) SetParallelStart(parallel bool) error {
+	if parallel {
+		return f.Set("jail_parallel_start", "YES")
+	}
+	return f.Set("jail_parallel_start", "NO")
+}
+
+// Returns the jail_<name>_* variables of a jail, by the name that
+// follows the prefix (eg "flags" for jail_web_flags). The variables
+// of a jail named web_local share the prefix, and are included.
+func (f *File) JailVars(name string) map[string]string {
+	prefix := jailPrefix(name)
+	vars := map[string]string{}
+	for _, key := range f.Keys() {
+		if suffix, ok := strings.CutPrefix(key, prefix); ok && suffix != "" {
+			vars[suffix], _ = f.Get(key)
+		}
+	}
+	return vars
+}
+
+// Sets a jail_<name>_* variable of a jail
+func (f *File) SetJailVar(name, key, value string) error {
+	return f.Set(jailPrefix(name)+key, value)
+}
+
+// jailPrefix returns the prefix of the variables of a jail. As in
+// rc.d/jail, the characters of the name that cannot be part of a
+// variable name are replaced by underscores.
+func jailPrefix(name string) string {
+	return "jail_" + strings.Map(func(c rune) rune {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return '_'
+	}, name) + "_"
+}
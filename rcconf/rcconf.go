@@ -0,0 +1,286 @@
+// Package rcconf reads and edits rc.conf(5) files, such as
+// /etc/rc.conf, in place: the comments, blank lines and order of a
+// file are kept, and only the assignments that change are rewritten.
+package rcconf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultFile is the rc.conf(5) file that rc(8) reads jails from
+var DefaultFile = "/etc/rc.conf"
+
+// File is an rc.conf(5) file. Each line that is a single sh(1)
+// assignment (eg `jail_enable="YES"`, with an optional comment) is a
+// variable of the file; other lines are kept as they are, and are
+// not evaluated. Values are taken literally: variables they refer to
+// are not expanded.
+type File struct {
+	lines []line
+}
+
+// line is a line of a File. A quoted value can span several lines
+// of the file, which are then kept as a single line.
+type line struct {
+	raw string
+	// key is the variable assigned by the line, or "" for a line
+	// that is not an assignment
+	key   string
+	value string
+	// comment is the text that follows the value, including the
+	// blanks before it (eg ` # web servers`)
+	comment string
+}
+
+// Reads an rc.conf(5) file
+func Read(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rc, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rc, nil
+}
+
+// Parses the rc.conf(5) format
+func Parse(r io.Reader) (*File, error) {
+	f := &File{}
+	s := bufio.NewScanner(r)
+	var pending string
+	lineno, start := 0, 0
+	for s.Scan() {
+		lineno++
+		text := s.Text()
+		if start == 0 {
+			start = lineno
+		} else {
+			text = pending + "\n" + text
+		}
+		l, complete := parseLine(text)
+		if !complete {
+			pending = text
+			continue
+		}
+		f.lines = append(f.lines, l)
+		pending, start = "", 0
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if start != 0 {
+		return nil, fmt.Errorf("line %d: unterminated quote", start)
+	}
+	return f, nil
+}
+
+// Returns the value of a variable, and whether the file assigns it.
+// As with sh(1), the last assignment wins.
+func (f *File) Get(key string) (string, bool) {
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].key == key {
+			return f.lines[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a variable is set to YES, TRUE, ON or 1 (in any
+// case), as checkyesno does in rc.subr(8)
+func (f *File) Bool(key string) bool {
+	v, _ := f.Get(key)
+	switch strings.ToUpper(v) {
+	case "YES", "TRUE", "ON", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+// Sets a variable. The last assignment of the variable is rewritten
+// in place and keeps its comment, or an assignment is appended to
+// the file.
+func (f *File) Set(key, value string) error {
+	if !validKey(key) {
+		return fmt.Errorf("rcconf: invalid variable name: %q", key)
+	}
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if l := &f.lines[i]; l.key == key {
+			if l.value != value {
+				l.value = value
+				l.raw = format(key, value) + l.comment
+			}
+			return nil
+		}
+	}
+	f.lines = append(f.lines, line{raw: format(key, value), key: key, value: value})
+	return nil
+}
+
+// Removes every assignment of a variable
+func (f *File) Delete(key string) {
+	lines := f.lines[:0]
+	for _, l := range f.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+	f.lines = lines
+}
+
+// Returns the variables of the file, in the order they are first
+// assigned
+func (f *File) Keys() []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, l := range f.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// Returns the file in the rc.conf(5) format
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range f.lines {
+		b.WriteString(l.raw)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(f.Bytes())
+	return int64(n), err
+}
+
+// Writes the file to path through a temporary file that is renamed
+// over it, so that rc(8) never reads a partial file. The mode of an
+// existing file is kept.
+func (f *File) Write(path string) error {
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := f.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// parseLine parses a line of a file, and reports whether it is
+// complete: a quote that is left open continues on the next line
+func parseLine(text string) (line, bool) {
+	l := line{raw: text}
+	trimmed := strings.TrimLeft(text, " \t")
+	eq := strings.IndexByte(trimmed, '=')
+	if eq <= 0 || !validKey(trimmed[:eq]) {
+		return l, true
+	}
+	var value strings.Builder
+	rest := trimmed[eq+1:]
+	i := 0
+	for i < len(rest) {
+		switch c := rest[i]; {
+		case c == '\'':
+			end := strings.IndexByte(rest[i+1:], '\'')
+			if end == -1 {
+				return l, false
+			}
+			value.WriteString(rest[i+1 : i+1+end])
+			i += end + 2
+		case c == '"':
+			i++
+			for {
+				if i >= len(rest) {
+					return l, false
+				}
+				c := rest[i]
+				if c == '"' {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(rest) && strings.IndexByte("\"\\$`\n", rest[i+1]) != -1 {
+					if rest[i+1] != '\n' {
+						value.WriteByte(rest[i+1])
+					}
+					i += 2
+					continue
+				}
+				value.WriteByte(c)
+				i++
+			}
+		case c == '\\' && i+1 < len(rest):
+			value.WriteByte(rest[i+1])
+			i += 2
+		case c == ' ' || c == '\t':
+			comment := rest[i:]
+			if t := strings.TrimLeft(comment, " \t"); t != "" && t[0] != '#' {
+				// Several commands, or a command with an
+				// assignment: the line is kept as it is
+				return l, true
+			}
+			l.comment = comment
+			i = len(rest)
+		case c == ';' || c == '&' || c == '|' || c == '(' || c == ')' || c == '<' || c == '>':
+			return l, true
+		default:
+			value.WriteByte(c)
+			i++
+		}
+	}
+	l.key, l.value = trimmed[:eq], value.String()
+	return l, true
+}
+
+// format returns an assignment with a double-quoted value
+func format(key, value string) string {
+	var b strings.Builder
+	b.WriteString(key + `="`)
+	for _, c := range value {
+		if strings.ContainsRune("\"\\$`", c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// validKey reports whether key is the name of a sh(1) variable
+func validKey(key string) bool {
+	if key == "" || key[0] >= '0' && key[0] <= '9' {
+		return false
+	}
+	for _, c := range key {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package rctl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// Backend adds, removes and lists rules. The default Backend is
+// Command, and it can be replaced through SetBackend.
+type Backend interface {
+	// Add adds a rule
+	Add(r Rule) error
+	// Remove removes the rules that match a filter
+	Remove(filter Rule) error
+	// Rules returns the rules that match a filter
+	Rules(filter Rule) ([]Rule, error)
+	// Usage returns the resource usage of a subject, keyed by
+	// resource
+	Usage(subject Rule) (map[string]int64, error)
+}
+
+var backend Backend = Command{}
+
+// Replace the Backend used by the package, and return the previous one
+func SetBackend(b Backend) Backend {
+	prev := backend
+	backend = b
+	return prev
+}
+
+// Adds a rule, after it has been validated
+func Add(r Rule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return backend.Add(r)
+}
+
+// Removes the rules that match a filter
+func Remove(filter Rule) error {
+	return backend.Remove(filter)
+}
+
+// Returns the rules that match a filter
+func Rules(filter Rule) ([]Rule, error) {
+	return backend.Rules(filter)
+}
+
+// Returns the resource usage of a subject (eg the Subject and
+// SubjectID of Rule{Subject: "jail", SubjectID: "web"})
+func Usage(subject Rule) (map[string]int64, error) {
+	if subject.Subject == "" || subject.SubjectID == "" {
+		return nil, fmt.Errorf("rctl: usage needs a subject and a subject ID: %q", subject.Filter())
+	}
+	return backend.Usage(Rule{Subject: subject.Subject, SubjectID: subject.SubjectID})
+}
+
+// Command implements Backend through rctl(8)
+type Command struct {
+	// Runner runs rctl(8) (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+func (c Command) Add(r Rule) error {
+	_, err := c.run("-a", r.String())
+	return err
+}
+
+func (c Command) Remove(filter Rule) error {
+	_, err := c.run("-r", filter.Filter())
+	return err
+}
+
+func (c Command) Rules(filter Rule) ([]Rule, error) {
+	args := []string{"-n"}
+	if f := filter.Filter(); f != "" {
+		args = append(args, f)
+	}
+	out, err := c.run(args...)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, line := range lines(out) {
+		r, err := ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (c Command) Usage(subject Rule) (map[string]int64, error) {
+	out, err := c.run("-u", subject.Filter())
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]int64)
+	for _, line := range lines(out) {
+		resource, amount, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("rctl: unexpected usage: %q", line)
+		}
+		n, err := strconv.ParseInt(amount, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rctl: unexpected usage: %q", line)
+		}
+		usage[resource] = n
+	}
+	return usage, nil
+}
+
+func (c Command) run(arg ...string) ([]byte, error) {
+	return runner.Or(c.Runner).Run(exec.Command("rctl", arg...))
+}
+
+// lines returns the non-empty lines of the output of rctl(8)
+func lines(out []byte) []string {
+	var ls []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			ls = append(ls, line)
+		}
+	}
+	return ls
+}
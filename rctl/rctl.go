@@ -0,0 +1,207 @@
+// Package rctl manages resource limits through rctl(8). A Rule is
+// written in the syntax of rctl(8):
+//
+//	subject:subject-id:resource:action=amount[/per]
+//
+// for example "jail:web:memoryuse:deny=512m".
+package rctl
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Subjects
+const (
+	SubjectProcess    = "process"
+	SubjectUser       = "user"
+	SubjectLoginClass = "loginclass"
+	SubjectJail       = "jail"
+)
+
+// Resources
+const (
+	CPUTime         = "cputime"
+	DataSize        = "datasize"
+	StackSize       = "stacksize"
+	CoreDumpSize    = "coredumpsize"
+	MemoryUse       = "memoryuse"
+	MemoryLocked    = "memorylocked"
+	MaxProc         = "maxproc"
+	OpenFiles       = "openfiles"
+	VMemoryUse      = "vmemoryuse"
+	PseudoTerminals = "pseudoterminals"
+	SwapUse         = "swapuse"
+	NThr            = "nthr"
+	MsgqQueued      = "msgqqueued"
+	MsgqSize        = "msgqsize"
+	NMsgq           = "nmsgq"
+	NSem            = "nsem"
+	NSemop          = "nsemop"
+	NShm            = "nshm"
+	ShmSize         = "shmsize"
+	WallClock       = "wallclock"
+	PCPU            = "pcpu"
+	ReadBPS         = "readbps"
+	WriteBPS        = "writebps"
+	ReadIOPS        = "readiops"
+	WriteIOPS       = "writeiops"
+)
+
+// Actions. A signal name prefixed with "sig" (eg sigterm) is also
+// an action.
+const (
+	Deny     = "deny"
+	Log      = "log"
+	DevCtl   = "devctl"
+	Throttle = "throttle"
+)
+
+var (
+	subjects  = []string{SubjectProcess, SubjectUser, SubjectLoginClass, SubjectJail}
+	resources = []string{
+		CPUTime, DataSize, StackSize, CoreDumpSize, MemoryUse, MemoryLocked,
+		MaxProc, OpenFiles, VMemoryUse, PseudoTerminals, SwapUse, NThr,
+		MsgqQueued, MsgqSize, NMsgq, NSem, NSemop, NShm, ShmSize, WallClock,
+		PCPU, ReadBPS, WriteBPS, ReadIOPS, WriteIOPS,
+	}
+	signals = []string{
+		"sighup", "sigint", "sigquit", "sigill", "sigtrap", "sigabrt",
+		"sigemt", "sigfpe", "sigkill", "sigbus", "sigsegv", "sigsys",
+		"sigpipe", "sigalrm", "sigterm", "sigurg", "sigstop", "sigtstp",
+		"sigcont", "sigchld", "sigttin", "sigttou", "sigio", "sigxcpu",
+		"sigxfsz", "sigvtalrm", "sigprof", "sigwinch", "siginfo",
+		"sigusr1", "sigusr2", "sigthr", "siglibrt",
+	}
+)
+
+// Rule is a single rctl(8) rule. A Rule with empty fields is a
+// filter, which matches every rule that has the fields that are set.
+type Rule struct {
+	// Subject is one of process, user, loginclass or jail
+	Subject string `json:"subject"`
+	// SubjectID identifies the subject (eg a jail name)
+	SubjectID string `json:"subject_id"`
+	Resource  string `json:"resource"`
+	Action    string `json:"action"`
+	// Amount is the limit, in the unit of the resource (bytes,
+	// seconds, percent of a CPU, ...)
+	Amount int64 `json:"amount"`
+	// Per is the subject the amount applies to, when it is not
+	// Subject (eg "jail:web:maxproc:deny=100/user")
+	Per string `json:"per,omitempty"`
+}
+
+// Parses a rule. The amount can have a k, m, g, t, p or e suffix
+// (powers of 1024), as accepted by rctl(8).
+func ParseRule(s string) (Rule, error) {
+	r, err := ParseFilter(s)
+	if err != nil {
+		return r, err
+	}
+	if !strings.Contains(s, "=") {
+		return r, fmt.Errorf("rctl: missing amount: %q", s)
+	}
+	return r, r.Validate()
+}
+
+// Parses a filter: a rule with trailing fields left out (eg
+// "jail:web" or "jail::memoryuse")
+func ParseFilter(s string) (Rule, error) {
+	var r Rule
+	s = strings.TrimSpace(s)
+	rule, amount, hasAmount := strings.Cut(s, "=")
+	fields := strings.Split(rule, ":")
+	if len(fields) > 4 {
+		return r, fmt.Errorf("rctl: too many fields: %q", s)
+	}
+	fields = append(fields, make([]string, 4-len(fields))...)
+	r.Subject, r.SubjectID, r.Resource, r.Action = fields[0], fields[1], fields[2], fields[3]
+	if hasAmount {
+		amount, per, _ := strings.Cut(amount, "/")
+		n, err := ParseAmount(amount)
+		if err != nil {
+			return r, fmt.Errorf("rctl: %q: %w", s, err)
+		}
+		r.Amount, r.Per = n, per
+	}
+	return r, nil
+}
+
+// Parses an amount with an optional k, m, g, t, p or e suffix
+func ParseAmount(s string) (int64, error) {
+	shift := 0
+	if s != "" {
+		if i := strings.IndexByte("kmgtpe", lower(s[len(s)-1])); i != -1 {
+			shift, s = 10*(i+1), s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %q", s)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative amount: %d", n)
+	}
+	if shift > 0 && n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("amount out of range: %s", s)
+	}
+	return n << shift, nil
+}
+
+// Returns the rule in the syntax of rctl(8)
+func (r Rule) String() string {
+	s := strings.Join([]string{r.Subject, r.SubjectID, r.Resource, r.Action}, ":")
+	s += "=" + strconv.FormatInt(r.Amount, 10)
+	if r.Per != "" {
+		s += "/" + r.Per
+	}
+	return s
+}
+
+// Returns the rule as a filter, with empty trailing fields left out
+func (r Rule) Filter() string {
+	fields := []string{r.Subject, r.SubjectID, r.Resource, r.Action}
+	for len(fields) > 0 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, ":")
+}
+
+// Reports an error when a rule is incomplete, or has an unknown
+// subject, resource or action
+func (r Rule) Validate() error {
+	switch {
+	case !slices.Contains(subjects, r.Subject):
+		return fmt.Errorf("rctl: unknown subject: %q", r.Subject)
+	case r.SubjectID == "":
+		return errors.New("rctl: missing subject ID: " + r.String())
+	case !slices.Contains(resources, r.Resource):
+		return fmt.Errorf("rctl: unknown resource: %q", r.Resource)
+	case !isAction(r.Action):
+		return fmt.Errorf("rctl: unknown action: %q", r.Action)
+	case r.Amount < 0:
+		return fmt.Errorf("rctl: negative amount: %d", r.Amount)
+	case r.Per != "" && !slices.Contains(subjects, r.Per):
+		return fmt.Errorf("rctl: unknown per subject: %q", r.Per)
+	}
+	return nil
+}
+
+func isAction(s string) bool {
+	switch s {
+	case Deny, Log, DevCtl, Throttle:
+		return true
+	}
+	return slices.Contains(signals, s)
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
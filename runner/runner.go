@@ -0,0 +1,51 @@
+// Package runner runs the FreeBSD utilities (eg ifconfig(8) or
+// rctl(8)) that other packages wrap, and lets tests replace them.
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs a command and returns its standard output
+type Runner interface {
+	Run(cmd *exec.Cmd) ([]byte, error)
+}
+
+// Exec runs commands through os/exec. When a command fails, the
+// returned error includes its standard error.
+type Exec struct{}
+
+func (Exec) Run(cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && stderr.Len() > 0 {
+			return out, fmt.Errorf("%s: %s", strings.Join(cmd.Args, " "), bytes.TrimSpace(stderr.Bytes()))
+		}
+		return out, fmt.Errorf("%s: %w", strings.Join(cmd.Args, " "), err)
+	}
+	return out, nil
+}
+
+// Func adapts a function to the Runner interface
+type Func func(cmd *exec.Cmd) ([]byte, error)
+
+func (f Func) Run(cmd *exec.Cmd) ([]byte, error) {
+	return f(cmd)
+}
+
+// Returns r, or Exec when r is nil
+func Or(r Runner) Runner {
+	if r == nil {
+		return Exec{}
+	}
+	return r
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail/internal/fsutil"
+)
+
+// Dir implements Driver with plain directories: each root is a
+// subdirectory of Root, and a snapshot is a full copy of a root kept
+// under Root/.snapshots. It needs no ZFS, but a snapshot or a clone
+// costs a copy of the root.
+type Dir struct {
+	Root string
+}
+
+// snapshotDir holds the snapshots of every root
+const snapshotDir = ".snapshots"
+
+// orderFile lists the snapshots of a root, oldest first
+const orderFile = ".order"
+
+func (d Dir) Create(name string) (string, error) {
+	if err := validateName("root", name); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(d.Root, 0o755); err != nil {
+		return "", err
+	}
+	p := filepath.Join(d.Root, name)
+	if err := os.Mkdir(p, 0o755); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (d Dir) Clone(src, snapshot, name string) (string, error) {
+	if err := errors.Join(validateName("root", src), validateName("snapshot", snapshot), validateName("root", name)); err != nil {
+		return "", err
+	}
+	snap := filepath.Join(d.Root, snapshotDir, src, snapshot)
+	if _, err := os.Stat(snap); err != nil {
+		return "", fmt.Errorf("storage: snapshot %s@%s: %w", src, snapshot, err)
+	}
+	p := filepath.Join(d.Root, name)
+	if _, err := os.Lstat(p); err == nil {
+		return "", fmt.Errorf("storage: root %s: %w", name, fs.ErrExist)
+	}
+	if err := fsutil.CopyTree(snap, p); err != nil {
+		return "", errors.Join(err, fsutil.RemoveAll(p))
+	}
+	return p, nil
+}
+
+func (d Dir) Path(name string) (string, error) {
+	if err := validateName("root", name); err != nil {
+		return "", err
+	}
+	p := filepath.Join(d.Root, name)
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (d Dir) Snapshot(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	p, err := d.Path(name)
+	if err != nil {
+		return err
+	}
+	snapshots, err := d.Snapshots(name)
+	if err != nil {
+		return err
+	} else if slices.Contains(snapshots, snapshot) {
+		return fmt.Errorf("storage: snapshot %s@%s: %w", name, snapshot, fs.ErrExist)
+	}
+	dir := filepath.Join(d.Root, snapshotDir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := fsutil.CopyTree(p, filepath.Join(dir, snapshot)); err != nil {
+		return errors.Join(err, fsutil.RemoveAll(filepath.Join(dir, snapshot)))
+	}
+	return d.writeOrder(name, append(snapshots, snapshot))
+}
+
+// DestroySnapshot does not fail for a snapshot that a root has been
+// cloned from, as the clone is a copy
+func (d Dir) DestroySnapshot(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	snapshots, err := d.Snapshots(name)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(snapshots, snapshot)
+	if i == -1 {
+		return fmt.Errorf("storage: snapshot %s@%s: %w", name, snapshot, fs.ErrNotExist)
+	}
+	if err := fsutil.RemoveAll(filepath.Join(d.Root, snapshotDir, name, snapshot)); err != nil {
+		return err
+	}
+	return d.writeOrder(name, slices.Delete(snapshots, i, i+1))
+}
+
+func (d Dir) Snapshots(name string) ([]string, error) {
+	if err := validateName("root", name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(d.Root, snapshotDir, name, orderFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var snapshots []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			snapshots = append(snapshots, line)
+		}
+	}
+	return snapshots, s.Err()
+}
+
+func (d Dir) Rollback(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	snapshots, err := d.Snapshots(name)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(snapshots, snapshot)
+	if i == -1 {
+		return fmt.Errorf("storage: snapshot %s@%s: %w", name, snapshot, fs.ErrNotExist)
+	}
+	dir := filepath.Join(d.Root, snapshotDir, name)
+	// The snapshot is copied next to the root, and only replaces it
+	// once the copy is complete: a failed copy leaves the root as is
+	tmp, err := os.MkdirTemp(d.Root, "."+name+"-")
+	if err != nil {
+		return err
+	}
+	defer fsutil.RemoveAll(tmp)
+	if err := fsutil.CopyTree(filepath.Join(dir, snapshot), filepath.Join(tmp, "root")); err != nil {
+		return err
+	}
+	p := filepath.Join(d.Root, name)
+	if err := os.Rename(p, filepath.Join(tmp, "old")); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmp, "root"), p); err != nil {
+		return errors.Join(err, os.Rename(filepath.Join(tmp, "old"), p))
+	}
+	for _, later := range snapshots[i+1:] {
+		if err := fsutil.RemoveAll(filepath.Join(dir, later)); err != nil {
+			return err
+		}
+	}
+	return d.writeOrder(name, snapshots[:i+1])
+}
+
+func (d Dir) Destroy(name string) error {
+	if err := validateName("root", name); err != nil {
+		return err
+	}
+	return errors.Join(
+		fsutil.RemoveAll(filepath.Join(d.Root, name)),
+		fsutil.RemoveAll(filepath.Join(d.Root, snapshotDir, name)),
+	)
+}
+
+func (d Dir) writeOrder(name string, snapshots []string) error {
+	var sb strings.Builder
+	for _, snap := range snapshots {
+		sb.WriteString(snap + "\n")
+	}
+	return os.WriteFile(filepath.Join(d.Root, snapshotDir, name, orderFile), []byte(sb.String()), 0o600)
+}
@@ -0,0 +1,44 @@
+// Package storage manages the root filesystems of jails: a root per
+// jail, snapshots of it, and new roots cloned from the snapshot of a
+// template. ZFS keeps each root in a dataset, and Dir keeps it in a
+// plain directory.
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Driver creates and snapshots the roots of jails. A root is named
+// after its jail, and a template is the root of a jail that is only
+// cloned from.
+type Driver interface {
+	// Create creates an empty root, and returns its path
+	Create(name string) (string, error)
+	// Clone creates a root from a snapshot of another root (eg a
+	// template), and returns its path
+	Clone(src, snapshot, name string) (string, error)
+	// Path returns the path of a root
+	Path(name string) (string, error)
+	// Snapshot takes a snapshot of a root
+	Snapshot(name, snapshot string) error
+	// DestroySnapshot destroys a snapshot of a root. It fails when
+	// a root has been cloned from the snapshot.
+	DestroySnapshot(name, snapshot string) error
+	// Snapshots returns the snapshots of a root, oldest first
+	Snapshots(name string) ([]string, error)
+	// Rollback restores a root to a snapshot. The snapshots taken
+	// after it are destroyed.
+	Rollback(name, snapshot string) error
+	// Destroy destroys a root and its snapshots
+	Destroy(name string) error
+}
+
+// validateName reports an error for a name that is not a single
+// component of a dataset or path
+func validateName(kind, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/@# \t\n") {
+		return fmt.Errorf("storage: invalid %s name: %q", kind, name)
+	}
+	return nil
+}
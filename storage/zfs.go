@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// ZFS implements Driver through zfs(8): each root is a child dataset
+// of Dataset, and is mounted where Dataset places it
+type ZFS struct {
+	// Dataset is the parent dataset of the roots (eg zroot/jails)
+	Dataset string
+	// Runner runs zfs(8) (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+func (z ZFS) Create(name string) (string, error) {
+	if err := validateName("root", name); err != nil {
+		return "", err
+	}
+	if _, err := z.zfs("create", "-p", z.dataset(name)); err != nil {
+		return "", err
+	}
+	return z.Path(name)
+}
+
+func (z ZFS) Clone(src, snapshot, name string) (string, error) {
+	if err := errors.Join(validateName("root", src), validateName("snapshot", snapshot), validateName("root", name)); err != nil {
+		return "", err
+	}
+	if _, err := z.zfs("clone", "-p", z.dataset(src)+"@"+snapshot, z.dataset(name)); err != nil {
+		return "", err
+	}
+	return z.Path(name)
+}
+
+func (z ZFS) Path(name string) (string, error) {
+	if err := validateName("root", name); err != nil {
+		return "", err
+	}
+	out, err := z.zfs("get", "-H", "-o", "value", "mountpoint", z.dataset(name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (z ZFS) Snapshot(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	_, err := z.zfs("snapshot", z.dataset(name)+"@"+snapshot)
+	return err
+}
+
+func (z ZFS) DestroySnapshot(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	_, err := z.zfs("destroy", z.dataset(name)+"@"+snapshot)
+	return err
+}
+
+func (z ZFS) Snapshots(name string) ([]string, error) {
+	if err := validateName("root", name); err != nil {
+		return nil, err
+	}
+	out, err := z.zfs("list", "-H", "-t", "snapshot", "-o", "name", "-s", "createtxg", "-d", "1", z.dataset(name))
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, snap, ok := strings.Cut(strings.TrimSpace(line), "@"); ok {
+			snapshots = append(snapshots, snap)
+		}
+	}
+	return snapshots, nil
+}
+
+func (z ZFS) Rollback(name, snapshot string) error {
+	if err := errors.Join(validateName("root", name), validateName("snapshot", snapshot)); err != nil {
+		return err
+	}
+	_, err := z.zfs("rollback", "-r", z.dataset(name)+"@"+snapshot)
+	return err
+}
+
+// Destroy fails when a root has been cloned from one of the
+// snapshots
+func (z ZFS) Destroy(name string) error {
+	if err := validateName("root", name); err != nil {
+		return err
+	}
+	_, err := z.zfs("destroy", "-r", z.dataset(name))
+	return err
+}
+
+// Delegates a dataset to a jail (the zfs.dataset parameter of
+// jail(8)): the dataset is marked as jailed, and the jail can then
+// mount and manage it and its children. The jail must allow mount
+// and mount.zfs, and have an enforce_statfs below 2.
+func (z ZFS) Delegate(j *jail.Jail, dataset string) error {
+	switch {
+	case !j.Perms.AllowMount || !j.Perms.AllowMountZfs:
+		return errors.New("storage: delegation requires allow.mount and allow.mount.zfs")
+	case j.EnforceStatFS >= 2:
+		return errors.New("storage: delegation requires an enforce_statfs below 2")
+	}
+	if err := validateDataset(dataset); err != nil {
+		return err
+	}
+	if _, err := z.zfs("set", "jailed=on", dataset); err != nil {
+		return err
+	}
+	_, err := z.zfs("jail", strconv.Itoa(int(j.ID)), dataset)
+	return err
+}
+
+// Takes back a dataset delegated to a jail. The dataset stays marked
+// as jailed, so that the host does not mount it: the jail could have
+// changed its mountpoint.
+func (z ZFS) Undelegate(j *jail.Jail, dataset string) error {
+	if err := validateDataset(dataset); err != nil {
+		return err
+	}
+	_, err := z.zfs("unjail", strconv.Itoa(int(j.ID)), dataset)
+	return err
+}
+
+// validateDataset reports an error for a dataset that zfs(8) would
+// take for an option, or that is a snapshot
+func validateDataset(dataset string) error {
+	if dataset == "" || strings.HasPrefix(dataset, "-") || strings.Contains(dataset, "@") {
+		return fmt.Errorf("storage: invalid dataset name: %q", dataset)
+	}
+	return nil
+}
+
+func (z ZFS) dataset(name string) string {
+	return z.Dataset + "/" + name
+}
+
+func (z ZFS) zfs(args ...string) ([]byte, error) {
+	return runner.Or(z.Runner).Run(exec.Command("zfs", args...))
+}
@@ -0,0 +1,10 @@
+package test
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
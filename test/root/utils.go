@@ -1,15 +1,57 @@
 package test
 
 import (
+	"log"
+	"os"
+	"os/exec"
 	"testing"
 
 	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/provision"
 )
 
+// root is /tmp/jail, or a root provisioned once by TestMain from the
+// release in $JAIL_RELEASE (a directory with base.txz and its MANIFEST)
+var root = "/tmp/jail"
+
 func newJail(t *testing.T) *jail.Jail {
-	j, err := jail.NewJail("/tmp/jail")
+	j, err := jail.NewJail(root)
 	if err != nil {
 		t.Fatalf("new jail fail: %v", err)
 	}
 	return j
 }
+
+// runTests provisions the root the jails of the package share, runs
+// the tests and removes the root
+func runTests(m *testing.M) int {
+	dir := os.Getenv("JAIL_RELEASE")
+	if dir == "" {
+		return m.Run()
+	}
+	tmp, err := os.MkdirTemp("", "jail")
+	if err != nil {
+		log.Printf("provision fail: %v", err)
+		return 1
+	}
+	defer removeRoot(tmp)
+	rel := &provision.Release{Dir: dir}
+	if err := rel.Extract(tmp, "base"); err != nil {
+		log.Printf("provision fail: %v", err)
+		return 1
+	}
+	root = tmp
+	return m.Run()
+}
+
+// removeRoot removes a provisioned root. The schg flag that base.txz
+// sets on some files (eg lib/libc.so.7) is cleared first, since they
+// cannot be removed otherwise.
+func removeRoot(dir string) {
+	if out, err := exec.Command("chflags", "-R", "noschg", dir).CombinedOutput(); err != nil {
+		log.Printf("chflags fail: %v: %s", err, out)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("remove root fail: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"errors"
+	"net/netip"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// aliases is a fake jail.AliasDriver
+type aliases struct {
+	added map[string]bool
+	fail  string
+}
+
+func (a *aliases) AddAlias(iface string, addr netip.Prefix) error {
+	if addr.String() == a.fail {
+		return errors.New("cannot add " + a.fail)
+	}
+	a.added[iface+"|"+addr.String()] = true
+	return nil
+}
+
+func (a *aliases) RemoveAlias(iface string, addr netip.Prefix) error {
+	delete(a.added, iface+"|"+addr.String())
+	return nil
+}
+
+func (a *aliases) Aliases() ([]jail.IPAlias, error) {
+	var list []jail.IPAlias
+	for s := range a.added {
+		alias, err := jail.ParseIPAlias(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, alias)
+	}
+	return list, nil
+}
+
+func useAliases(t *testing.T) *aliases {
+	a := &aliases{added: map[string]bool{}}
+	prev := jail.SetAliasDriver(a)
+	t.Cleanup(func() { jail.SetAliasDriver(prev) })
+	return a
+}
+
+func TestParseIPAlias(t *testing.T) {
+	tests := map[string]jail.IPAlias{
+		"em0|10.0.0.5/24": {Interface: "em0", Prefix: netip.MustParsePrefix("10.0.0.5/24")},
+		"10.0.0.5":        {Prefix: netip.MustParsePrefix("10.0.0.5/32")},
+		"lo1|fd00::5":     {Interface: "lo1", Prefix: netip.MustParsePrefix("fd00::5/128")},
+	}
+	for s, want := range tests {
+		a, err := jail.ParseIPAlias(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !reflect.DeepEqual(a, want) {
+			t.Errorf("%s: expected %+v but got %+v", s, want, a)
+		} else if a.String() != s {
+			t.Errorf("expected %s but got %s", s, a)
+		}
+	}
+	for _, s := range []string{"|10.0.0.5", "em0|10.0.0", "em0|10.0.0.5/33"} {
+		if _, err := jail.ParseIPAlias(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestCreateAliases(t *testing.T) {
+	jailtest.Use(t)
+	a := useAliases(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.Interface = "em0"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.5")}
+	s.Aliases = []jail.IPAlias{{Interface: "lo1", Prefix: netip.MustParsePrefix("fd00::5/64")}}
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := map[string]bool{"em0|10.0.0.5/32": true, "lo1|fd00::5/64": true}
+	if !reflect.DeepEqual(a.added, want) {
+		t.Fatalf("expected %v but got %v", want, a.added)
+	}
+	if len(j.IP6) != 1 || j.IP6[0] != netip.MustParseAddr("fd00::5") {
+		t.Fatalf("expected the alias to be a jail address but got %v", j.IP6)
+	}
+	if err := jail.Destroy(j, s); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(a.added) != 0 {
+		t.Fatalf("expected the aliases to be removed but got %v", a.added)
+	}
+}
+
+func TestCreateAliasesCleanup(t *testing.T) {
+	jailtest.Use(t)
+	a := useAliases(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.Interface = "em0"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.6")}
+	a.fail = "10.0.0.6/32"
+	if _, err := jail.Create(s); err == nil {
+		t.Fatalf("expected an error")
+	} else if len(a.added) != 0 {
+		t.Fatalf("expected the aliases to be removed but got %v", a.added)
+	}
+	a.fail = ""
+	if _, err := jail.Create(jail.NewSpec("web", "/jails/web")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := jail.Create(s); err == nil {
+		t.Fatalf("expected an error for an existing jail")
+	} else if len(a.added) != 0 {
+		t.Fatalf("expected the aliases to be removed but got %v", a.added)
+	}
+}
+
+func TestIfconfigAliases(t *testing.T) {
+	out := `em0: flags=8863<UP,BROADCAST,RUNNING,SIMPLEX,MULTICAST> metric 0 mtu 1500
+	options=481009b<RXCSUM,TXCSUM,VLAN_MTU,VLAN_HWTAGGING>
+	ether 58:9c:fc:00:00:01
+	inet 192.168.1.10 netmask 0xffffff00 broadcast 192.168.1.255
+	inet 10.0.0.4 netmask 0xffffffff broadcast 10.0.0.4
+	inet6 fe80::1%em0 prefixlen 64 scopeid 0x1
+lo1: flags=8049<UP,LOOPBACK,RUNNING,MULTICAST> metric 0 mtu 16384
+	inet6 fd00::5 prefixlen 128
+`
+	i := jail.IfconfigAliases{Runner: runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		return []byte(out), nil
+	})}
+	got, err := i.Aliases()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var s []string
+	for _, a := range got {
+		s = append(s, a.String())
+	}
+	want := []string{"em0|192.168.1.10/24", "em0|10.0.0.4", "em0|fe80::1/64", "lo1|fd00::5"}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("expected %q but got %q", want, s)
+	}
+}
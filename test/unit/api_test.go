@@ -0,0 +1,174 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/api"
+	"git.hardenedbsd.org/0x1eef/jail/client"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+// sh runs commands on the host rather than through jexec(8)
+func sh(ctx context.Context, jid int32, name string, arg ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, arg...)
+}
+
+func serve(h http.Handler, cred *api.Cred, method, path, body string) *http.Response {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if cred != nil {
+		req = req.WithContext(api.WithCred(req.Context(), *cred))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	jailtest.Use(t)
+	h := &api.Handler{Policy: api.Policy{UIDs: []uint32{1001}, GIDs: []uint32{5}}}
+	tests := []struct {
+		cred *api.Cred
+		want int
+	}{
+		{nil, http.StatusForbidden},
+		{&api.Cred{UID: 0}, http.StatusOK},
+		{&api.Cred{UID: 1001}, http.StatusOK},
+		{&api.Cred{UID: 1002, Groups: []uint32{5}}, http.StatusOK},
+		{&api.Cred{UID: 1002, Groups: []uint32{1002}}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if res := serve(h, tt.cred, "GET", "/v1/jails", ""); res.StatusCode != tt.want {
+			t.Errorf("%+v: expected %d but got %d", tt.cred, tt.want, res.StatusCode)
+		}
+	}
+}
+
+func TestAPIJails(t *testing.T) {
+	jailtest.Use(t)
+	h, root := &api.Handler{}, &api.Cred{}
+	res := serve(h, root, "POST", "/v1/jails", `{"name":"web","path":"/jails/web","hostname":"web.local"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201 but got %d", res.StatusCode)
+	}
+	var j jail.Jail
+	json.NewDecoder(res.Body).Decode(&j)
+	if j.Name != "web" || j.EnforceStatFS != 2 {
+		t.Fatalf("expected jail web with the defaults of NewSpec but got %+v", j)
+	}
+	for _, path := range []string{"/v1/jails/web", "/v1/jails/1"} {
+		if res := serve(h, root, "GET", path, ""); res.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: expected 200 but got %d", path, res.StatusCode)
+		}
+	}
+	res = serve(h, root, "PATCH", "/v1/jails/web", `{"securelevel":2,"host.hostname":"www.local","allow.raw_sockets":true}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 but got %d", res.StatusCode)
+	}
+	json.NewDecoder(res.Body).Decode(&j)
+	if j.SecureLevel != 2 || j.Hostname != "www.local" || !j.Perms.AllowRawSockets {
+		t.Fatalf("expected the parameters to be set but got %+v", j)
+	}
+	res = serve(h, root, "GET", "/v1/jails", "")
+	var jails []*jail.Jail
+	json.NewDecoder(res.Body).Decode(&jails)
+	if len(jails) != 1 {
+		t.Fatalf("expected 1 jail but got %d", len(jails))
+	}
+	if res := serve(h, root, "DELETE", "/v1/jails/web", ""); res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 but got %d", res.StatusCode)
+	}
+	if res := serve(h, root, "GET", "/v1/jails/web", ""); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 but got %d", res.StatusCode)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	jailtest.Use(t)
+	h, root := &api.Handler{}, &api.Cred{}
+	serve(h, root, "POST", "/v1/jails", `{"name":"web","path":"/jails/web"}`)
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/v1/jails", `{"name":"db","path":"jails/db"}`, http.StatusBadRequest},
+		{"POST", "/v1/jails", `{"name":"web","path":"/jails/web"}`, http.StatusConflict},
+		{"POST", "/v1/jails", `{`, http.StatusBadRequest},
+		{"PATCH", "/v1/jails/web", `{"path":"/jails/www"}`, http.StatusConflict},
+		{"PATCH", "/v1/jails/web", `{"securelevel":1.5}`, http.StatusBadRequest},
+		{"PATCH", "/v1/jails/db", `{"securelevel":1}`, http.StatusNotFound},
+		{"DELETE", "/v1/jails/42", "", http.StatusNotFound},
+		{"POST", "/v1/jails/web/exec", `{"command":[]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		res := serve(h, root, tt.method, tt.path, tt.body)
+		var e api.Error
+		json.NewDecoder(res.Body).Decode(&e)
+		if res.StatusCode != tt.want || e.Message == "" {
+			t.Errorf("%s %s %s: expected %d and a message but got %d %q", tt.method, tt.path, tt.body, tt.want, res.StatusCode, e.Message)
+		}
+	}
+}
+
+func TestAPIExec(t *testing.T) {
+	jailtest.Use(t)
+	h, root := &api.Handler{Command: sh}, &api.Cred{}
+	serve(h, root, "POST", "/v1/jails", `{"name":"web","path":"/jails/web"}`)
+	res := serve(h, root, "POST", "/v1/jails/web/exec", `{"command":["sh","-c","echo out; echo err >&2; exit 3"]}`)
+	var out bytes.Buffer
+	out.ReadFrom(res.Body)
+	if res.StatusCode != http.StatusOK || out.String() != "out\nerr\n" {
+		t.Fatalf("expected the output of the command but got %d %q", res.StatusCode, out.String())
+	}
+	if status := res.Trailer.Get(api.ExitStatusHeader); status != "3" {
+		t.Fatalf("expected exit status 3 but got %q", status)
+	}
+}
+
+func TestClient(t *testing.T) {
+	jailtest.Use(t)
+	socket := filepath.Join(t.TempDir(), "jaild.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	srv := &http.Server{
+		Handler:     &api.Handler{Policy: api.Policy{UIDs: []uint32{uint32(os.Getuid())}}, Command: sh},
+		ConnContext: api.ConnContext,
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	ctx, c := context.Background(), client.New(socket)
+	j, err := c.Create(ctx, jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if j, err = c.Update(ctx, j.Name, map[string]any{"securelevel": 3}); err != nil || j.SecureLevel != 3 {
+		t.Fatalf("expected securelevel 3 but got %+v, %v", j, err)
+	}
+	if jails, err := c.List(ctx, true); err != nil || len(jails) != 1 {
+		t.Fatalf("expected 1 jail but got %v, %v", jails, err)
+	}
+	var out bytes.Buffer
+	if status, err := c.Exec(ctx, "web", &out, "echo", "hello"); err != nil || status != 0 || out.String() != "hello\n" {
+		t.Fatalf("expected hello and exit status 0 but got %q, %d, %v", out.String(), status, err)
+	}
+	if err := c.Remove(ctx, "web"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var e *api.Error
+	if _, err := c.Get(ctx, "web"); !errors.As(err, &e) || e.Status != http.StatusNotFound {
+		t.Fatalf("expected a 404 error but got %v", err)
+	}
+}
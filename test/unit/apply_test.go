@@ -0,0 +1,258 @@
+package test
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// useHost installs fakes of what Apply sets up on the host
+func useHost(t *testing.T) (*aliases, *mounts, *limits) {
+	t.Helper()
+	return useAliases(t), useTemplates(t), useLimits(t)
+}
+
+func TestApply(t *testing.T) {
+	jailtest.Use(t)
+	useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	web.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	db := jail.NewSpec("db", "/jails/db")
+	plan, err := jail.Apply(context.Background(), []jail.Spec{web, db}, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(plan.Steps) != 2 {
+		t.Fatalf("expected 2 steps but got %d", len(plan.Steps))
+	}
+	jails, err := jail.Living()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(jails) != 2 {
+		t.Fatalf("expected 2 jails but got %d", len(jails))
+	}
+	if jails[0].Name != "web" || jails[0].IP4[0] != web.IP4[0] {
+		t.Fatalf("unexpected jail: %+v", jails[0])
+	}
+	plan, err = jail.NewPlan([]jail.Spec{web, db}, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(plan.Steps) != 0 {
+		t.Fatalf("expected no steps but got:\n%s", plan)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	jailtest.Use(t)
+	useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	db := jail.NewSpec("db", "/jails/db")
+	old := jail.NewSpec("old", "/jails/old")
+	if _, err := jail.Apply(context.Background(), []jail.Spec{web, db, old}, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	web.Hostname = "www.local"
+	web.Perms.AllowRawSockets = true
+	db.Path = "/jails/db2"
+	cache := jail.NewSpec("cache", "/jails/cache")
+	plan, err := jail.NewPlan([]jail.Spec{web, db, cache}, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []jail.Action{jail.ActionUpdate, jail.ActionRecreate, jail.ActionCreate, jail.ActionRemove}
+	if len(plan.Steps) != len(want) {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+	for i, step := range plan.Steps {
+		if step.Action != want[i] {
+			t.Errorf("step %d: expected %v but got %v", i, want[i], step.Action)
+		}
+	}
+	if s := plan.String(); !strings.Contains(s, `~ host.hostname: "web" => "www.local"`) {
+		t.Errorf("expected the plan to show the hostname change:\n%s", s)
+	}
+	if err := plan.Apply(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	plan, err = jail.NewPlan([]jail.Spec{web, db, cache}, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(plan.Steps) != 0 {
+		t.Fatalf("expected no steps but got:\n%s", plan)
+	}
+}
+
+func TestApplyPrune(t *testing.T) {
+	jailtest.Use(t)
+	useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	old := jail.NewSpec("old", "/jails/old")
+	if _, err := jail.Apply(context.Background(), []jail.Spec{web, old}, false); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if plan, err := jail.Apply(context.Background(), []jail.Spec{web}, false); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(plan.Steps) != 0 {
+		t.Fatalf("expected old to be left alone but got:\n%s", plan)
+	}
+	plan, err := jail.Apply(context.Background(), []jail.Spec{web}, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(plan.Steps) != 1 || plan.Steps[0].Action != jail.ActionRemove || plan.Steps[0].Name != "old" {
+		t.Fatalf("expected old to be removed but got:\n%s", plan)
+	}
+	if jails, _ := jail.Living(); len(jails) != 1 || jails[0].Name != "web" {
+		t.Fatalf("expected only web to be left but got %d jails", len(jails))
+	}
+}
+
+// converge applies a plan of the desired jails, and expects a new
+// plan to have no steps
+func converge(t *testing.T, desired ...jail.Spec) jail.Plan {
+	t.Helper()
+	plan, err := jail.NewPlan(desired, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := plan.Apply(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if again, err := jail.NewPlan(desired, true); err != nil {
+		t.Fatalf("%v", err)
+	} else if len(again.Steps) != 0 {
+		t.Fatalf("expected no steps but got:\n%s", again)
+	}
+	return plan
+}
+
+// actions returns the actions of a plan, with the changed parameters
+// of each step
+func actions(p jail.Plan) []string {
+	var s []string
+	for _, step := range p.Steps {
+		a := step.Action.String()
+		for _, c := range step.Changes {
+			a += " " + c.Param
+		}
+		s = append(s, a)
+	}
+	return s
+}
+
+func TestPlanRecreateTearsDown(t *testing.T) {
+	jailtest.Use(t)
+	a, m, l := useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	web.Interface = "em0"
+	web.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	web.Template = "14.1"
+	web.Limits = []rctl.Rule{{Resource: "memoryuse", Action: "deny", Amount: 512 << 20}}
+	converge(t, web)
+	web = jail.NewSpec("web", "/jails/web2")
+	web.Interface = "em1"
+	web.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.5")}
+	plan := converge(t, web)
+	if got, want := actions(plan), []string{"recreate path ip4.addr ip4.addr rctl alias alias template"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+	if want := map[string]bool{"em1|10.0.0.5/32": true}; !reflect.DeepEqual(a.added, want) {
+		t.Errorf("expected aliases %v but got %v", want, a.added)
+	}
+	if len(m.mounted) != 0 {
+		t.Errorf("expected the template to be unmounted but got %v", m.mounted)
+	}
+	if len(l.rules) != 0 {
+		t.Errorf("expected the rules to be removed but got %v", l.rules)
+	}
+}
+
+func TestPlanHost(t *testing.T) {
+	jailtest.Use(t)
+	a, m, l := useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	web.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	web.Aliases = []jail.IPAlias{{Interface: "em0", Prefix: netip.MustParsePrefix("10.0.0.4/24")}}
+	converge(t, web)
+	web.CPUSet = []int{0, 1}
+	web.Limits = []rctl.Rule{{Resource: "maxproc", Action: "deny", Amount: 100}}
+	web.Aliases = []jail.IPAlias{{Interface: "em1", Prefix: netip.MustParsePrefix("10.0.0.4/24")}}
+	web.Mounts = []jail.Mount{{Source: "/data", Target: "/jails/web/data", FSType: "nullfs", Options: "rw"}}
+	plan := converge(t, web)
+	if got, want := actions(plan), []string{"update cpuset rctl alias alias mount"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+	j, _ := jail.FindByName("web")
+	if cpus, _ := j.CPUSet(); !reflect.DeepEqual(cpus, []int{0, 1}) {
+		t.Errorf("expected CPUs 0-1 but got %v", cpus)
+	}
+	if len(l.rules) != 1 || l.rules[0].Resource != "maxproc" {
+		t.Errorf("unexpected rules: %v", l.rules)
+	}
+	if want := map[string]bool{"em1|10.0.0.4/24": true}; !reflect.DeepEqual(a.added, want) {
+		t.Errorf("expected aliases %v but got %v", want, a.added)
+	}
+	if !reflect.DeepEqual(m.mounted, web.Mounts) {
+		t.Errorf("expected %v to be mounted but got %v", web.Mounts, m.mounted)
+	}
+	web.Template = "14.1"
+	plan, err := jail.NewPlan([]jail.Spec{web}, false)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if got, want := actions(plan), []string{"recreate template"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+}
+
+func TestPlanSecureLevel(t *testing.T) {
+	jailtest.Use(t)
+	useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	web.SecureLevel = 1
+	converge(t, web)
+	web.SecureLevel = 2
+	if got, want := actions(converge(t, web)), []string{"update securelevel"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+	web.SecureLevel = 0
+	if got, want := actions(converge(t, web)), []string{"recreate securelevel"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+}
+
+func TestPlanDying(t *testing.T) {
+	k := jailtest.Use(t)
+	a, _, _ := useHost(t)
+	web := jail.NewSpec("web", "/jails/web")
+	web.Interface = "em0"
+	web.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	old := jail.NewSpec("old", "/jails/old")
+	old.Interface = "em0"
+	old.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.9")}
+	converge(t, web, old)
+	for _, name := range []string{"web", "old"} {
+		j, _ := jail.FindByName(name)
+		k.SetDying(j.ID)
+	}
+	plan, err := jail.NewPlan([]jail.Spec{web}, true)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if got, want := actions(plan), []string{"recreate", "remove"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	} else if !plan.Steps[0].Dying || !strings.Contains(plan.String(), "(remove, jid 2, dying)") {
+		t.Fatalf("expected dying steps but got:\n%s", plan)
+	}
+	if err := plan.Apply(context.Background()); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := map[string]bool{"em0|10.0.0.4/32": true}; !reflect.DeepEqual(a.added, want) {
+		t.Errorf("expected aliases %v but got %v", want, a.added)
+	}
+	if j, err := jail.FindByName("web"); err != nil || j.Dying {
+		t.Fatalf("expected web to be recreated but got %+v, %v", j, err)
+	}
+}
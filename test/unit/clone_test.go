@@ -0,0 +1,133 @@
+package test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/storage"
+)
+
+func TestClone(t *testing.T) {
+	jailtest.Use(t)
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "web", "etc"), 0o755)
+	os.WriteFile(filepath.Join(dir, "web", "etc", "rc.conf"), []byte("nginx_enable=\"YES\"\n"), 0o644)
+	s := jail.NewSpec("web", filepath.Join(dir, "web"))
+	s.Hostname = "web.local"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	s.SecureLevel = 3
+	s.Perms.AllowRawSockets = true
+	s.Perms.AllowMount = true
+	src, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var overrides jail.Spec
+	overrides.Name = "web2"
+	overrides.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.5")}
+	j, err := jail.Clone(src, overrides)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := s
+	want.Name, want.Hostname, want.Path, want.IP4 = "web2", "web2", filepath.Join(dir, "web2"), overrides.IP4
+	if changes := jail.DiffSpecs(want, j.Spec()); len(changes) != 0 {
+		t.Fatalf("expected the clone to match its source but got %+v", changes)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "web2", "etc", "rc.conf")); err != nil || string(b) != "nginx_enable=\"YES\"\n" {
+		t.Fatalf("expected the root to be copied but got %q, %v", b, err)
+	}
+	if _, err := jail.Clone(src, jail.Spec{Name: "web"}); err == nil {
+		t.Fatalf("expected an error for a clone named after its source")
+	}
+	if _, err := jail.Clone(src, jail.Spec{}); err == nil {
+		t.Fatalf("expected an error for a clone without a name")
+	}
+}
+
+func TestCloneRollback(t *testing.T) {
+	jailtest.Use(t)
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "web"), 0o755)
+	src, _ := jail.Create(jail.NewSpec("web", filepath.Join(dir, "web")))
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	if _, err := jail.Clone(src, jail.Spec{Name: "db", Path: filepath.Join(dir, "db")}); err == nil {
+		t.Fatalf("expected an error for an existing jail")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "db")); err == nil {
+		t.Fatalf("expected the copy of the root to be removed")
+	}
+}
+
+func TestCloneRootDriver(t *testing.T) {
+	jailtest.Use(t)
+	d := storage.Dir{Root: t.TempDir()}
+	prev := jail.SetRootDriver(d)
+	t.Cleanup(func() { jail.SetRootDriver(prev) })
+	root, _ := d.Create("web")
+	os.WriteFile(filepath.Join(root, "motd"), []byte("web"), 0o644)
+	src, _ := jail.Create(jail.NewSpec("web", root))
+	j, err := jail.Clone(src, jail.Spec{Name: "web2", Hostname: "web2.local"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if j.Path != filepath.Join(d.Root, "web2") || j.Hostname != "web2.local" {
+		t.Fatalf("expected a clone of the root but got %s (%s)", j.Path, j.Hostname)
+	}
+	if snaps, _ := d.Snapshots("web"); !reflect.DeepEqual(snaps, []string{"web2"}) {
+		t.Fatalf("expected a snapshot named after the clone but got %v", snaps)
+	}
+	if b, _ := os.ReadFile(filepath.Join(j.Path, "motd")); string(b) != "web" {
+		t.Fatalf("expected the root to be cloned but got %q", b)
+	}
+}
+
+func TestCloneRootDriverRollback(t *testing.T) {
+	jailtest.Use(t)
+	d := storage.Dir{Root: t.TempDir()}
+	prev := jail.SetRootDriver(d)
+	t.Cleanup(func() { jail.SetRootDriver(prev) })
+	root, _ := d.Create("web")
+	src, _ := jail.Create(jail.NewSpec("web", root))
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	if _, err := jail.Clone(src, jail.Spec{Name: "db"}); err == nil {
+		t.Fatalf("expected an error for an existing jail")
+	}
+	if _, err := d.Path("db"); err == nil {
+		t.Fatalf("expected the clone of the root to be destroyed")
+	}
+	if snaps, _ := d.Snapshots("web"); len(snaps) != 0 {
+		t.Fatalf("expected the snapshot to be destroyed but got %v", snaps)
+	}
+}
+
+func TestCloneThin(t *testing.T) {
+	jailtest.Use(t)
+	m := useTemplates(t)
+	dir := t.TempDir()
+	root := filepath.Join(dir, "web")
+	if err := jail.NewThinRoot("14.1", root); err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := jail.NewSpec("web", root)
+	s.Template = "14.1"
+	src, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.WriteFile(filepath.Join(root, "bin", "sh"), []byte("sh"), 0o555)
+	if _, err := jail.Clone(src, jail.Spec{Name: "web2"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if entries, err := os.ReadDir(filepath.Join(dir, "web2", "bin")); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty mount point but got %v, %v", entries, err)
+	}
+	if len(m.mounted) != 2*len(jail.TemplateBase) || m.mounted[len(jail.TemplateBase)].Target != filepath.Join(dir, "web2", "bin") {
+		t.Fatalf("expected the template to be mounted in the clone but got %v", m.mounted)
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+const conf = `
+# defaults for every jail
+path = "/jails/$name";
+exec.clean;
+mount.devfs;
+
+/* a database */
+db {
+	host.hostname = db.local;
+	ip4.addr = 10.0.0.2, 10.0.0.3;
+}
+
+web {
+	host.hostname = "${name}.local";
+	ip4.addr = 10.0.0.4;
+	ip4.addr += 10.0.0.5;
+	exec.start = '/bin/sh /etc/rc $notexpanded';
+	depend = db; // the database starts first
+}
+`
+
+func TestParseConfig(t *testing.T) {
+	c, err := jail.ParseConfig(strings.NewReader(conf))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(c.Jails) != 2 {
+		t.Fatalf("expected 2 jails but got %d", len(c.Jails))
+	}
+	web, ok := c.Jail("web")
+	if !ok {
+		t.Fatalf("expected to find jail web")
+	}
+	tests := map[string]string{
+		"path":          "/jails/web",
+		"host.hostname": "web.local",
+		"ip4.addr":      "10.0.0.4,10.0.0.5",
+		"exec.start":    "/bin/sh /etc/rc $notexpanded",
+		"exec.clean":    "",
+	}
+	for name, want := range tests {
+		if got, ok := web.Get(name); !ok || got != want {
+			t.Errorf("%s: expected %q but got %q", name, want, got)
+		}
+	}
+	if deps := web.Depend(); !reflect.DeepEqual(deps, []string{"db"}) {
+		t.Errorf("expected web to depend on db but got %v", deps)
+	}
+	db, _ := c.Jail("db")
+	if v := db.Values("ip4.addr"); !reflect.DeepEqual(v, []string{"10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("unexpected ip4.addr: %v", v)
+	}
+}
+
+func TestParseConfigExpandOrder(t *testing.T) {
+	src := `
+path = "/jails/$name";
+mount.fstab = "$path/fstab";
+web {
+	path = "/usr/jails/$name";
+	exec.start = "$path/start";
+}
+`
+	c, err := jail.ParseConfig(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	web, _ := c.Jail("web")
+	tests := map[string]string{
+		"path":        "/usr/jails/web",
+		"mount.fstab": "/jails/web/fstab",
+		"exec.start":  "/usr/jails/web/start",
+	}
+	for name, want := range tests {
+		if got, ok := web.Get(name); !ok || got != want {
+			t.Errorf("%s: expected %q but got %q", name, want, got)
+		}
+	}
+}
+
+func TestParseConfigEscapes(t *testing.T) {
+	src := "web {\n" +
+		"\texec.start = \"echo \\$name \\\\$name\";\n" +
+		"\texec.stop = echo\\$HOME;\n" +
+		"\texec.poststop = \"a\x00b\";\n" +
+		"}\n"
+	c, err := jail.ParseConfig(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	web, _ := c.Jail("web")
+	tests := map[string]string{
+		"exec.start":    "echo $name \\web",
+		"exec.stop":     "echo$HOME",
+		"exec.poststop": "a\x00b",
+	}
+	for name, want := range tests {
+		if got, ok := web.Get(name); !ok || got != want {
+			t.Errorf("%s: expected %q but got %q", name, want, got)
+		}
+	}
+}
+
+func TestConfigQuoteRoundTrip(t *testing.T) {
+	s := jail.NewSpec("web", "/jails/web")
+	s.Exec.Start = []string{"echo 'it''s' $HOME \\$name"}
+	var buf strings.Builder
+	c := &jail.Config{Jails: []jail.ConfigJail{s.ConfigJail()}}
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	parsed, err := jail.ParseConfig(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, _ := parsed.Jails[0].Get("exec.start"); got != s.Exec.Start[0] {
+		t.Errorf("expected %q but got %q in:\n%s", s.Exec.Start[0], got, buf.String())
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []string{
+		`web { path = /jails/web }`,
+		`web { path = "/jails/web; }`,
+		`web { path = $nope; }`,
+		`web { path = /jails/web;`,
+		`/* web {}`,
+	}
+	for _, src := range tests {
+		if _, err := jail.ParseConfig(strings.NewReader(src)); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := map[string][]int{
+		"0":         {0},
+		"0-3,6":     {0, 1, 2, 3, 6},
+		"6, 0-3, 2": {0, 1, 2, 3, 6},
+		"4-4":       {4},
+	}
+	for s, want := range tests {
+		cpus, err := jail.ParseCPUList(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !reflect.DeepEqual(cpus, want) {
+			t.Errorf("%s: expected %v but got %v", s, want, cpus)
+		}
+	}
+	for _, s := range []string{"", "a", "3-1", "0-", "-1", "0,,1", "0-256"} {
+		if _, err := jail.ParseCPUList(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	tests := map[string][]int{
+		"0":           {0},
+		"0-3,6":       {3, 2, 1, 0, 6},
+		"0,2,4-5":     {0, 2, 4, 5, 5},
+		"1-2,7,10-12": {1, 2, 7, 10, 11, 12},
+		"":            nil,
+	}
+	for want, cpus := range tests {
+		if s := jail.FormatCPUList(cpus); s != want {
+			t.Errorf("%v: expected %q but got %q", cpus, want, s)
+		}
+	}
+}
+
+func TestSetCPUSet(t *testing.T) {
+	jailtest.Use(t)
+	j, err := jail.Create(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if cpus, _ := j.CPUSet(); len(cpus) != jailtest.CPUs {
+		t.Errorf("expected every CPU but got %v", cpus)
+	}
+	if err := j.SetCPUSet([]int{3, 1}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if cpus, _ := j.CPUSet(); !reflect.DeepEqual(cpus, []int{1, 3}) {
+		t.Errorf("unexpected cpuset: %v", cpus)
+	}
+	if err := j.SetCPUSet(nil); err == nil {
+		t.Errorf("expected an error for an empty cpuset")
+	}
+	if err := j.SetCPUSet([]int{jailtest.CPUs}); err == nil {
+		t.Errorf("expected an error for a missing CPU")
+	}
+}
+
+func TestCreateWithCPUSet(t *testing.T) {
+	jailtest.Use(t)
+	c, err := jail.ParseConfig(strings.NewReader(`db { path = /jails/db; exec.created = "cpuset -l 0-1,4 -j $name"; }`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s, err := c.Jails[0].Spec()
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(s.Exec.Created) != 0 {
+		t.Errorf("expected the cpuset(1) command to be read as CPUSet: %v", s.Exec.Created)
+	}
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if cpus, _ := j.CPUSet(); !reflect.DeepEqual(cpus, []int{0, 1, 4}) {
+		t.Errorf("unexpected cpuset: %v", cpus)
+	}
+	s = jail.NewSpec("app", "/jails/app")
+	s.CPUSet = []int{jailtest.CPUs}
+	if _, err := jail.Create(s); err == nil {
+		t.Fatalf("expected an error for a missing CPU")
+	}
+	if jails, _ := jail.Living(); len(jails) != 1 {
+		t.Errorf("expected the jail to be removed when its cpuset fails: %d jails", len(jails))
+	}
+}
+
+// jailKernel is a Kernel that only makes the jail system calls
+type jailKernel struct {
+	jail.Kernel
+}
+
+func TestCPUSetUnsupported(t *testing.T) {
+	k := jailtest.NewKernel()
+	prev := jail.SetKernel(jailKernel{k})
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	j, err := jail.Create(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := j.CPUSet(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported but got %v", err)
+	}
+	s := jail.NewSpec("db", "/jails/db")
+	s.CPUSet = []int{0}
+	if _, err := jail.Create(s); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported but got %v", err)
+	}
+	if _, err := jail.FindByName("db"); err == nil {
+		t.Fatalf("expected the jail to be removed")
+	}
+}
@@ -0,0 +1,170 @@
+package test
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"golang.org/x/sys/unix"
+)
+
+func TestCreate(t *testing.T) {
+	jailtest.Use(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	s.IP6 = []netip.Addr{netip.MustParseAddr("fd00::4")}
+	s.SecureLevel = 3
+	s.DevFSRuleset = 4
+	s.ChildrenMax = 2
+	s.Vnet = true
+	s.Perms.AllowRoot = false
+	s.Perms.AllowRawSockets = true
+	s.Perms.AllowMountZfs = true
+	s.Domainname = "example.org"
+	s.HostID = 42
+	s.SysVMsg, s.SysVShm = "new", "inherit"
+	s.IP4SAddrSel = false
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if changes := jail.DiffSpecs(s, j.Spec()); len(changes) != 0 {
+		t.Fatalf("expected the jail to match its spec but got %+v", changes)
+	}
+}
+
+func TestCreateIPInherit(t *testing.T) {
+	jailtest.Use(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.IP4Inherit = true
+	s.IP6SAddrSel = false
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !j.IP4Inherit || j.IP6Inherit || j.IP6SAddrSel {
+		t.Fatalf("expected ip4=inherit and ip6.nosaddrsel but got %+v", j)
+	}
+	if changes := jail.DiffSpecs(s, j.Spec()); len(changes) != 0 {
+		t.Fatalf("expected the jail to match its spec but got %+v", changes)
+	}
+	desired := *j
+	desired.IP4Inherit, desired.IP4 = false, []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	if err := j.Update(desired); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if j, err = jail.FindByID(j.ID); err != nil {
+		t.Fatalf("%v", err)
+	} else if j.IP4Inherit || len(j.IP4) != 1 {
+		t.Errorf("expected the jail to have its own address but got %+v", j)
+	}
+}
+
+func TestCreateManyAddrs(t *testing.T) {
+	jailtest.Use(t)
+	s := jail.NewSpec("web", "/jails/web")
+	for i := range 100 {
+		s.IP6 = append(s.IP6, netip.AddrFrom16([16]byte{0: 0xfd, 15: byte(i + 1)}))
+	}
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(j.IP6) != 100 {
+		t.Fatalf("expected 100 addresses but got %d", len(j.IP6))
+	}
+}
+
+// failingKernel fails to read a parameter
+type failingKernel struct {
+	*jailtest.Kernel
+	param string
+}
+
+func (k *failingKernel) Get(params jail.Params, flags uintptr) (int32, error) {
+	if _, ok := params[k.param]; ok {
+		return 0, unix.EPERM
+	}
+	return k.Kernel.Get(params, flags)
+}
+
+func TestFindAddrsError(t *testing.T) {
+	k := &failingKernel{Kernel: jailtest.NewKernel(), param: "ip4.addr"}
+	prev := jail.SetKernel(k)
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	if _, err := jail.Create(jail.NewSpec("web", "/jails/web")); !errors.Is(err, unix.EPERM) {
+		t.Fatalf("expected EPERM but got %v", err)
+	}
+}
+
+// attachedKernel hides every jail from jail_get(2) once the process
+// is attached, as the kernel does for the jail a process runs in
+type attachedKernel struct {
+	*jailtest.Kernel
+	attached bool
+}
+
+func (k *attachedKernel) Get(params jail.Params, flags uintptr) (int32, error) {
+	if k.attached {
+		return 0, unix.ENOENT
+	}
+	return k.Kernel.Get(params, flags)
+}
+
+func (k *attachedKernel) Attach(jid int32) error {
+	k.attached = true
+	return k.Kernel.Attach(jid)
+}
+
+func TestCreateAndAttachLast(t *testing.T) {
+	k := &attachedKernel{Kernel: jailtest.NewKernel()}
+	prev := jail.SetKernel(k)
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	s := jail.NewSpec("web", "/jails/web")
+	s.CPUSet = []int{0}
+	j, err := jail.CreateAndAttach(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if attached := k.Attached(); len(attached) != 1 || attached[0] != j.ID || j.Name != "web" {
+		t.Fatalf("expected jail %d to be read, then attached, but got %v", j.ID, attached)
+	}
+}
+
+func TestCreateAndAttach(t *testing.T) {
+	k := jailtest.Use(t)
+	j, err := jail.CreateAndAttach(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if attached := k.Attached(); len(attached) != 1 || attached[0] != j.ID {
+		t.Fatalf("expected jail %d to be attached but got %v", j.ID, attached)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	tests := map[string]func(*jail.Spec){
+		"relative path":  func(s *jail.Spec) { s.Path = "jails/web" },
+		"securelevel":    func(s *jail.Spec) { s.SecureLevel = 4 },
+		"enforce_statfs": func(s *jail.Spec) { s.EnforceStatFS = -1 },
+		"children.max":   func(s *jail.Spec) { s.ChildrenMax = -1 },
+		"numeric name":   func(s *jail.Spec) { s.Name = "42" },
+		"ip4.addr":       func(s *jail.Spec) { s.IP4 = []netip.Addr{netip.MustParseAddr("fd00::1")} },
+		"sysvshm":        func(s *jail.Spec) { s.SysVShm = "yes" },
+		"ip4 inherit": func(s *jail.Spec) {
+			s.IP4, s.IP4Inherit = []netip.Addr{netip.MustParseAddr("10.0.0.4")}, true
+		},
+	}
+	for name, fn := range tests {
+		s := jail.NewSpec("web", "/jails/web")
+		fn(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := jail.NewSpec("web", "/jails/web").Validate(); err != nil {
+		t.Errorf("%v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+func newGraph(t *testing.T, deps map[string][]string, names ...string) *jail.Graph {
+	jails := make([]jail.ConfigJail, 0, len(names))
+	for _, name := range names {
+		j := jail.ConfigJail{Name: name}
+		if d, ok := deps[name]; ok {
+			j.Params = append(j.Params, jail.ConfigParam{Name: "depend", Values: d})
+		}
+		jails = append(jails, j)
+	}
+	g, err := jail.NewGraph(jails)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return g
+}
+
+func TestGraphOrder(t *testing.T) {
+	g := newGraph(t, map[string][]string{
+		"web":   {"db", "cache"},
+		"db":    {"dns"},
+		"cache": {"dns"},
+	}, "web", "db", "cache", "dns", "mail")
+	want := []string{"dns", "mail", "db", "cache", "web"}
+	if order := g.Order(); !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected %v but got %v", want, order)
+	}
+}
+
+func TestGraphCycle(t *testing.T) {
+	jails := []jail.ConfigJail{
+		{Name: "a", Params: []jail.ConfigParam{{Name: "depend", Values: []string{"b"}}}},
+		{Name: "b", Params: []jail.ConfigParam{{Name: "depend", Values: []string{"a"}}}},
+	}
+	if _, err := jail.NewGraph(jails); err == nil {
+		t.Fatalf("expected a cycle to be detected")
+	}
+	jails = []jail.ConfigJail{
+		{Name: "a", Params: []jail.ConfigParam{{Name: "depend", Values: []string{"c"}}}},
+	}
+	if _, err := jail.NewGraph(jails); err == nil {
+		t.Fatalf("expected an unknown dependency to be detected")
+	}
+}
+
+func TestGraphStart(t *testing.T) {
+	g := newGraph(t, map[string][]string{
+		"web": {"db"},
+		"db":  {"dns"},
+	}, "web", "db", "dns", "mail")
+	var (
+		mu      sync.Mutex
+		started []string
+	)
+	err := g.Start(context.Background(), 2, func(ctx context.Context, name string) error {
+		if name == "db" {
+			return errors.New("boom")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		started = append(started, name)
+		return nil
+	})
+	var errs jail.BulkError
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected a BulkError but got %v", err)
+	}
+	if len(errs) != 2 || errs["db"] == nil || !errors.Is(errs["web"], jail.ErrSkipped) {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(started) != 2 {
+		t.Fatalf("expected dns and mail to start but got %v", started)
+	}
+}
+
+func TestGraphStartParallel(t *testing.T) {
+	g := newGraph(t, map[string][]string{
+		"web": {"db"},
+	}, "web", "db", "dns", "mail", "cache", "proxy", "log")
+	var (
+		mu       sync.Mutex
+		running  int
+		most     int
+		started  []string
+		parallel = 2
+	)
+	err := g.Start(context.Background(), parallel, func(ctx context.Context, name string) error {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		started = append(started, name)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(started) != 7 {
+		t.Fatalf("expected 7 jails to start but got %v", started)
+	}
+	if most > parallel {
+		t.Fatalf("expected at most %d concurrent starts but got %d", parallel, most)
+	} else if most < parallel {
+		t.Fatalf("expected %d concurrent starts but got %d", parallel, most)
+	}
+}
+
+func TestGraphStop(t *testing.T) {
+	g := newGraph(t, map[string][]string{
+		"web": {"db"},
+		"db":  {"dns"},
+	}, "web", "db", "dns")
+	var stopped []string
+	err := g.Stop(context.Background(), 1, func(ctx context.Context, name string) error {
+		stopped = append(stopped, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := []string{"web", "db", "dns"}; !reflect.DeepEqual(stopped, want) {
+		t.Fatalf("expected %v but got %v", want, stopped)
+	}
+}
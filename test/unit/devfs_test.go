@@ -0,0 +1,131 @@
+package test
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/devfs"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+func TestDevfsReadFiles(t *testing.T) {
+	rules, err := devfs.ReadFiles("testdata/devfs.rules", "testdata/missing.rules")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(rules.Rulesets) != 5 {
+		t.Fatalf("expected 5 rulesets but got %d", len(rules.Rulesets))
+	}
+	web, ok := rules.Lookup("web")
+	if !ok || web.Number != 10 {
+		t.Fatalf("unexpected ruleset: %+v", web)
+	}
+	want := []devfs.Rule{
+		{Include: "devfsrules_jail"},
+		{Num: 100, Path: "bpf*", Unhide: true},
+		{Path: "tun*", Unhide: true, Mode: "0660", Group: "network"},
+		{Type: "disk", Hide: true},
+	}
+	if !reflect.DeepEqual(web.Rules, want) {
+		t.Errorf("expected %+v but got %+v", want, web.Rules)
+	}
+	if err := rules.Check(4); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := rules.Check(0); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := rules.CheckJail(&jail.Jail{Name: "db", DevFSRuleset: 5}); err == nil {
+		t.Errorf("expected an error for an undeclared ruleset")
+	}
+}
+
+func TestDevfsInvalidFiles(t *testing.T) {
+	for _, path := range []string{"testdata/devfs_cycle.rules", "testdata/devfs_unknown.rules"} {
+		if _, err := devfs.ReadFiles(path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+	for _, src := range []string{
+		"add hide",
+		"[a]\nadd hide",
+		"[a=0]\nadd hide",
+		"[a=1]\n[a=2]",
+		"[a=1]\n[b=1]",
+		"[a=1]\nadd path null",
+		"[a=1]\nadd path null unhide mode 0999",
+		"[a=1]\nadd path null hide unhide",
+		"[a=1]\nadd path 'null unhide",
+		"[a=1]\nadd type block hide",
+		"[a=1]\nadd include $b path null unhide",
+		"[a=1]\nremove path null",
+	} {
+		rules, err := devfs.Parse(strings.NewReader(src))
+		if err == nil {
+			err = rules.Validate()
+		}
+		if err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
+
+func TestDevfsApply(t *testing.T) {
+	rules, err := devfs.ReadFiles("testdata/devfs.rules")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var cmds []string
+	err = rules.Apply(10, runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd.Args, " "))
+		return nil, nil
+	}))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{
+		"devfs rule -s 1 delset",
+		"devfs rule -s 1 add hide",
+		"devfs rule -s 2 delset",
+	}
+	if !reflect.DeepEqual(cmds[:3], want) {
+		t.Errorf("expected %q but got %q", want, cmds[:3])
+	}
+	want = []string{
+		"devfs rule -s 10 delset",
+		"devfs rule -s 10 add include 4",
+		"devfs rule -s 10 add 100 path bpf* unhide",
+		"devfs rule -s 10 add path tun* unhide group network mode 0660",
+		"devfs rule -s 10 add type disk hide",
+	}
+	if got := cmds[len(cmds)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+	if n := strings.Count(strings.Join(cmds, "\n"), "delset"); n != 5 {
+		t.Errorf("expected each ruleset to be loaded once: %d", n)
+	}
+	if err := rules.Apply(5, nil); err == nil {
+		t.Errorf("expected an error for an undeclared ruleset")
+	}
+}
+
+func TestSetDevFSRuleset(t *testing.T) {
+	jailtest.Use(t)
+	j, err := jail.Create(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := j.SetDevFSRuleset(10); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if j, _ = jail.FindByID(j.ID); j.DevFSRuleset != 10 {
+		t.Errorf("expected ruleset 10 but got %d", j.DevFSRuleset)
+	}
+	if err := j.SetDevFSRuleset(-1); err == nil {
+		t.Errorf("expected an error for a negative ruleset")
+	}
+}
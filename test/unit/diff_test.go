@@ -0,0 +1,46 @@
+package test
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+func TestDiff(t *testing.T) {
+	a := &jail.Jail{
+		Name:          "web",
+		Path:          "/jails/web",
+		Hostname:      "web.local",
+		EnforceStatFS: 2,
+		IP4:           []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")},
+	}
+	b := *a
+	b.Path = "/jails/www"
+	b.Hostname = ""
+	b.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.3")}
+	b.Perms.AllowMount = true
+	want := []jail.Change{
+		{Kind: jail.Changed, Param: "path", Old: "/jails/web", New: "/jails/www", Restart: true},
+		{Kind: jail.Removed, Param: "host.hostname", Old: "web.local"},
+		{Kind: jail.Removed, Param: "ip4.addr", Old: "10.0.0.2"},
+		{Kind: jail.Added, Param: "ip4.addr", New: "10.0.0.3"},
+		{Kind: jail.Changed, Param: "allow.mount", Old: "false", New: "true"},
+	}
+	if changes := jail.Diff(a, &b); !reflect.DeepEqual(changes, want) {
+		t.Fatalf("expected %+v but got %+v", want, changes)
+	}
+	if changes := jail.Diff(a, a); len(changes) != 0 {
+		t.Fatalf("expected no changes but got %+v", changes)
+	}
+}
+
+func TestDiffPrimaryAddr(t *testing.T) {
+	a := &jail.Jail{IP4: []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")}}
+	b := &jail.Jail{IP4: []netip.Addr{netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")}}
+	changes := jail.Diff(a, b)
+	if len(changes) != 1 || changes[0].Kind != jail.Changed || changes[0].Param != "ip4.addr" {
+		t.Fatalf("expected the primary address to change but got %+v", changes)
+	}
+}
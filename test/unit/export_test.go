@@ -0,0 +1,175 @@
+package test
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+)
+
+// fullSpec returns a Spec with every kind of parameter set
+func fullSpec() jail.Spec {
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	s.IP6 = []netip.Addr{netip.MustParseAddr("fd00::4")}
+	s.Interface = "em0"
+	s.Aliases = []jail.IPAlias{{Interface: "lo1", Prefix: netip.MustParsePrefix("10.1.0.4/24")}}
+	s.SecureLevel = 2
+	s.DevFSRuleset = 4
+	s.ChildrenMax = 1
+	s.OSRelease = "13.4-RELEASE"
+	s.OSRelDate = 1304000
+	s.Domainname = "example.org"
+	s.HostID = 0x1234
+	s.SysVShm = "new"
+	s.IP4SAddrSel = false
+	s.IP6SAddrSel = false
+	s.Perms.AllowSetHostname = false
+	s.Perms.AllowRawSockets = true
+	s.CPUSet = []int{0, 1, 4}
+	s.Limits = []rctl.Rule{
+		{Resource: rctl.MemoryUse, Action: rctl.Deny, Amount: 512 << 20},
+		{Resource: rctl.MaxProc, Action: rctl.Deny, Amount: 100},
+	}
+	s.Mounts = []jail.Mount{{Source: "/data/web", Target: "/jails/web/data", FSType: "nullfs", Options: "ro"}}
+	s.Datasets = []string{"zroot/jails/web/data"}
+	s.Exec.Start = []string{"/bin/sh /etc/rc"}
+	s.Exec.Stop = []string{"/bin/sh /etc/rc.shutdown jail"}
+	s.Exec.PostStop = []string{"echo 'stopped ${name}'"}
+	return s
+}
+
+func TestExportImport(t *testing.T) {
+	s := fullSpec()
+	b, err := jail.ExportSpec(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got, err := jail.Import(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("expected %+v but got %+v", s, got)
+	}
+	for _, src := range []string{
+		`{"jail": {"name": "web", "path": "/jails/web"}}`,
+		`{"version": 2, "jail": {"name": "web", "path": "/jails/web"}}`,
+		`{"version": 1}`,
+		`{"version": 1, "jail": {"name": "web", "path": "jails/web"}}`,
+		`{"version": 1, "jail": {"name": "web", "path": "/jails/web", "ip4_addr": ["fd00::4"]}}`,
+	} {
+		if _, err := jail.Import([]byte(src)); err == nil {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+	s, err = jail.Import([]byte(`{"version": 1, "jail": {"name": "db", "path": "/jails/db"}}`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if s.EnforceStatFS != 2 || !s.Perms.AllowRoot {
+		t.Errorf("expected the defaults of NewSpec but got %+v", s)
+	}
+}
+
+func TestExportJail(t *testing.T) {
+	jailtest.Use(t)
+	useLimits(t)
+	s := fullSpec()
+	s.Interface, s.Aliases = "", nil
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if rules, _ := j.Limits(); len(rules) != 2 {
+		t.Errorf("expected the limits to be added: %v", ruleStrings(rules))
+	}
+	b, err := jail.Export(j)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got, err := jail.Import(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if changes := jail.DiffSpecs(s, got); len(changes) != 0 {
+		t.Errorf("expected no changes but got %v", changes)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	s := fullSpec()
+	var buf bytes.Buffer
+	c := &jail.Config{Jails: []jail.ConfigJail{s.ConfigJail()}}
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, line := range []string{
+		"\tpath = /jails/web;\n",
+		"\tinterface = em0;\n",
+		"\tip4.addr = 10.0.0.4, lo1|10.1.0.4/24;\n",
+		"\tallow.noset_hostname;\n",
+		"\tpersist;\n",
+		"\thost.domainname = example.org;\n",
+		"\thost.hostid = 4660;\n",
+		"\tsysvshm = new;\n",
+		"\tip4.nosaddrsel;\n",
+		"\tip6.nosaddrsel;\n",
+		"\texec.created = \"cpuset -l 0-1,4 -j web\";\n",
+		"\texec.prestart = \"rctl -a jail:web:memoryuse:deny=536870912\", \"rctl -a jail:web:maxproc:deny=100\";\n",
+		"\texec.poststop = \"echo 'stopped \\${name}'\", \"rctl -r jail:web\";\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in:\n%s", line, buf.String())
+		}
+	}
+	parsed, err := jail.ParseConfig(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	got, err := parsed.Jails[0].Spec()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("expected %+v but got %+v", s, got)
+	}
+}
+
+func TestConfigIPInherit(t *testing.T) {
+	s := jail.NewSpec("web", "/jails/web")
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	s.IP6Inherit = true
+	var buf bytes.Buffer
+	c := &jail.Config{Jails: []jail.ConfigJail{s.ConfigJail()}}
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(buf.String(), "\tip6 = inherit;\n") {
+		t.Errorf("expected ip6 = inherit in:\n%s", buf.String())
+	}
+	parsed, err := jail.ParseConfig(&buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, err := parsed.Jails[0].Spec(); err != nil {
+		t.Fatalf("%v", err)
+	} else if !got.IP6Inherit || got.IP4Inherit {
+		t.Errorf("expected ip6=inherit but got %+v", got)
+	}
+	b, err := jail.ExportSpec(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got, err := jail.Import(b); err != nil {
+		t.Fatalf("%v", err)
+	} else if !reflect.DeepEqual(got, s) {
+		t.Errorf("expected %+v but got %+v", s, got)
+	}
+}
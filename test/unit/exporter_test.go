@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/exporter"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func newExporter(usageErr error) *exporter.Exporter {
+	return &exporter.Exporter{
+		Jails: func() ([]*jail.Jail, error) {
+			return []*jail.Jail{
+				{ID: 3, Name: "db", Hostname: "db.local", SecureLevel: 3, Dying: true},
+				{ID: 1, Name: "web", Hostname: `web "1"`, SecureLevel: 2, ChildrenMax: 2, ChildrenCur: 1},
+				{ID: 2, Name: "web.app", Hostname: "app.local", SecureLevel: -1},
+			}, nil
+		},
+		Usage: func(j *jail.Jail) (map[string]int64, error) {
+			if usageErr != nil && j.ID == 2 {
+				return nil, usageErr
+			}
+			return map[string]int64{
+				"memoryuse": int64(j.ID) << 20,
+				"cputime":   int64(j.ID) * 10,
+				"pcpu":      int64(j.ID),
+				"maxproc":   int64(j.ID) * 4,
+				"openfiles": int64(j.ID) * 64,
+				"nthr":      int64(j.ID) * 8,
+			}, nil
+		},
+	}
+}
+
+func TestExporter(t *testing.T) {
+	tests := map[string]error{
+		"testdata/exporter.golden":           nil,
+		"testdata/exporter_rctl_down.golden": errors.New("rctl: RACCT/RCTL present, but disabled"),
+	}
+	for golden, usageErr := range tests {
+		var buf bytes.Buffer
+		if err := newExporter(usageErr).Write(&buf); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if *update {
+			if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+				t.Fatalf("%v", err)
+			}
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: unexpected output:\n%s", golden, buf.String())
+		}
+	}
+}
+
+func TestExporterHTTP(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newExporter(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != exporter.ContentType {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	e := &exporter.Exporter{Jails: func() ([]*jail.Jail, error) { return nil, errors.New("jail_get: EPERM") }}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 500 {
+		t.Errorf("expected 500 but got %d", rec.Code)
+	}
+}
+
+func TestExporterDying(t *testing.T) {
+	k := jailtest.Use(t)
+	jail.Create(jail.NewSpec("web", "/jails/web"))
+	db, _ := jail.Create(jail.NewSpec("db", "/jails/db"))
+	k.SetDying(db.ID)
+	e := &exporter.Exporter{Usage: func(*jail.Jail) (map[string]int64, error) { return nil, nil }}
+	var buf bytes.Buffer
+	if err := e.Write(&buf); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, want := range []string{
+		`jail_count{state="living"} 1`,
+		`jail_count{state="dying"} 1`,
+		`jail_dying{jid="2",name="db",hostname="db"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %s in:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+// newRoot returns the root of a jail with an etc directory, and a
+// file outside of it that the root has links to
+func newRoot(t *testing.T) (root, outside string) {
+	t.Helper()
+	dir := t.TempDir()
+	root, outside = filepath.Join(dir, "web"), filepath.Join(dir, "master.passwd")
+	os.MkdirAll(filepath.Join(root, "etc"), 0o755)
+	os.WriteFile(filepath.Join(root, "etc", "rc.conf"), []byte("sshd_enable=\"YES\"\n"), 0o644)
+	os.WriteFile(outside, []byte("root:secret\n"), 0o600)
+	return root, outside
+}
+
+func TestFS(t *testing.T) {
+	jailtest.Use(t)
+	root, _ := newRoot(t)
+	j, err := jail.Create(jail.NewSpec("web", root))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fsys := j.FS()
+	if err := fstest.TestFS(fsys, "etc/rc.conf"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := fsys.MkdirAll("usr/local/etc", 0o755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := fsys.MkdirAll("etc/rc.conf/x", 0o755); err == nil {
+		t.Fatalf("expected an error for a file in the path")
+	}
+	if err := fsys.WriteFile("usr/local/etc/nginx.conf", []byte("worker_processes 1;\n"), 0o640); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "usr", "local", "etc", "nginx.conf")); err != nil || fi.Mode().Perm() != 0o640 {
+		t.Fatalf("expected the file to be written in the root but got %v, %v", fi, err)
+	}
+	if err := fsys.Rename("usr/local/etc/nginx.conf", "etc/nginx.conf"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := fsys.Chmod("etc/nginx.conf", 0o600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if fi, err := fsys.Stat("etc/nginx.conf"); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600 but got %v, %v", fi, err)
+	}
+	if err := fsys.Symlink("nginx.conf", "etc/www.conf"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, err := fsys.ReadFile("etc/www.conf"); err != nil || string(b) != "worker_processes 1;\n" {
+		t.Fatalf("expected a link within the root to be followed but got %q, %v", b, err)
+	}
+	if err := fsys.Remove("usr/local/etc"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	entries, err := fsys.ReadDir("etc")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"nginx.conf", "rc.conf", "www.conf"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected %v but got %v", want, names)
+	}
+	for _, name := range []string{"/etc/rc.conf", "../web/etc/rc.conf", "etc/../etc/rc.conf"} {
+		if _, err := fsys.ReadFile(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("expected an invalid path for %s but got %v", name, err)
+		}
+	}
+}
+
+func TestFSEscape(t *testing.T) {
+	root, outside := newRoot(t)
+	fsys := jail.NewFS(root)
+	os.Symlink(outside, filepath.Join(root, "etc", "resolv.conf"))
+	os.Symlink("../../master.passwd", filepath.Join(root, "etc", "hosts"))
+	os.Symlink("../..", filepath.Join(root, "etc", "up"))
+	for _, name := range []string{"etc/resolv.conf", "etc/hosts", "etc/up/master.passwd"} {
+		if _, err := fsys.ReadFile(name); !errors.Is(err, jail.ErrEscape) {
+			t.Errorf("expected %s to escape but got %v", name, err)
+		}
+		if err := fsys.WriteFile(name, []byte("nameserver 10.0.0.1\n"), 0o644); !errors.Is(err, jail.ErrEscape) {
+			t.Errorf("expected %s to escape but got %v", name, err)
+		}
+		if _, err := fsys.Stat(name); !errors.Is(err, jail.ErrEscape) {
+			t.Errorf("expected %s to escape but got %v", name, err)
+		}
+	}
+	if err := fsys.Chmod("etc/resolv.conf", 0o666); !errors.Is(err, jail.ErrEscape) {
+		t.Errorf("expected chmod to escape but got %v", err)
+	}
+	if err := fsys.MkdirAll("etc/up/x", 0o755); !errors.Is(err, jail.ErrEscape) {
+		t.Errorf("expected mkdir to escape but got %v", err)
+	}
+	if b, _ := os.ReadFile(outside); string(b) != "root:secret\n" {
+		t.Fatalf("expected the file outside of the root to be left alone but got %q", b)
+	}
+	if fi, _ := os.Stat(outside); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the mode outside of the root to be left alone but got %v", fi.Mode())
+	}
+	if fi, err := fsys.Lstat("etc/resolv.conf"); err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("expected the link itself but got %v, %v", fi, err)
+	}
+	if link, err := fsys.Readlink("etc/resolv.conf"); err != nil || link != outside {
+		t.Fatalf("expected %s but got %q, %v", outside, link, err)
+	}
+	if err := fsys.Remove("etc/resolv.conf"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := fsys.WriteFile("etc/resolv.conf", []byte("nameserver 10.0.0.1\n"), 0o644); err != nil {
+		t.Fatalf("expected the link to be replaced by a file but got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"golang.org/x/sys/unix"
+)
+
+// useProcs installs a fake kernel with the processes of
+// testdata/kinfo_proc.bin, and creates the jails they run in
+func useProcs(t *testing.T) (*jailtest.Kernel, *jail.Jail) {
+	t.Helper()
+	b, err := os.ReadFile("testdata/kinfo_proc.bin")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	k := jailtest.Use(t)
+	k.SetProcs(b)
+	web, err := jail.Create(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	return k, web
+}
+
+func TestParseKinfoProc(t *testing.T) {
+	b, err := os.ReadFile("testdata/kinfo_proc.bin")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	procs, err := jail.ParseKinfoProc(b)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []jail.Process{
+		{PID: 1, PPID: 0, JID: 0, UID: 0, Command: "init", Start: time.Unix(1767225600, 0)},
+		{PID: 812, PPID: 1, JID: 1, UID: 0, Command: "sh", Start: time.Unix(1767225660, 250000000)},
+		{PID: 640, PPID: 1, JID: 1, UID: 80, Command: "nginx", Start: time.Unix(1767225630, 0)},
+		{PID: 641, PPID: 640, JID: 1, UID: 80, Command: "nginx", Start: time.Unix(1767225631, 500000000)},
+		{PID: 900, PPID: 1, JID: 2, UID: 0, Command: "postgres", Start: time.Unix(1767225700, 0)},
+	}
+	if !reflect.DeepEqual(procs, want) {
+		t.Fatalf("expected %+v but got %+v", want, procs)
+	}
+	if _, err := jail.ParseKinfoProc(b[:len(b)-1]); err == nil {
+		t.Fatalf("expected an error for a truncated record")
+	}
+	if _, err := jail.ParseKinfoProc(make([]byte, 1088)); err == nil {
+		t.Fatalf("expected an error for an unsupported size")
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	_, web := useProcs(t)
+	procs, err := web.Processes()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var pids []int32
+	for _, p := range procs {
+		pids = append(pids, p.PID)
+		if p.JID != web.ID || p.User == "" {
+			t.Errorf("expected a process of jail %d with a user but got %+v", web.ID, p)
+		}
+	}
+	if want := []int32{640, 641, 812}; !reflect.DeepEqual(pids, want) {
+		t.Fatalf("expected pids %v but got %v", want, pids)
+	}
+	if procs[2].User != "root" {
+		t.Fatalf("expected sh to run as root but got %q", procs[2].User)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	k, web := useProcs(t)
+	if err := web.Signal(unix.SIGTERM); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := web.KillAll(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []jailtest.Signal{
+		{PID: 640, Sig: unix.SIGTERM}, {PID: 641, Sig: unix.SIGTERM}, {PID: 812, Sig: unix.SIGTERM},
+		{PID: 640, Sig: unix.SIGKILL}, {PID: 641, Sig: unix.SIGKILL}, {PID: 812, Sig: unix.SIGKILL},
+	}
+	if signals := k.Signals(); !reflect.DeepEqual(signals, want) {
+		t.Fatalf("expected %+v but got %+v", want, signals)
+	}
+}
+
+func TestProcessesUnsupported(t *testing.T) {
+	prev := jail.SetKernel(jailKernel{jailtest.NewKernel()})
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	j, err := jail.Create(jail.NewSpec("web", "/jails/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := j.Processes(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported but got %v", err)
+	}
+	if err := j.KillAll(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported but got %v", err)
+	}
+}
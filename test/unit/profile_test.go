@@ -0,0 +1,76 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+func TestProfiles(t *testing.T) {
+	for _, p := range jail.Profiles() {
+		s := p.Apply(jail.NewSpec("web", "/jails/web"))
+		if err := s.Validate(); err != nil {
+			t.Errorf("%s: %v", p.Name, err)
+		}
+		for _, f := range s.Lint() {
+			if f.Severity >= jail.SeverityMedium {
+				t.Errorf("%s: %s", p.Name, f)
+			}
+		}
+	}
+	p, err := jail.LookupProfile("web-service")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := p.Apply(jail.NewSpec("web", "/jails/web"))
+	if s.SecureLevel != 2 || s.DevFSRuleset != 4 || s.Perms.AllowSetHostname || !s.Perms.AllowReservedPorts {
+		t.Errorf("unexpected spec: %+v", s)
+	}
+	if _, err := jail.LookupProfile("root"); err == nil {
+		t.Errorf("expected an error for an unknown profile")
+	}
+	p.Perms.AllowMount = true
+	if p, _ := jail.LookupProfile("web-service"); p.Perms.AllowMount {
+		t.Errorf("expected the built-in profile to be left unchanged")
+	}
+}
+
+func TestLint(t *testing.T) {
+	jailtest.Use(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.EnforceStatFS = 0
+	s.Perms.AllowRawSockets = true
+	s.Perms.AllowMount = true
+	s.Perms.AllowVMM = true
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var got [][]string
+	for _, f := range jail.Lint(j) {
+		if f.Message == "" {
+			t.Errorf("finding without an explanation: %v", f.Params)
+		}
+		got = append(got, append([]string{f.Severity.String()}, f.Params...))
+	}
+	want := [][]string{
+		{"high", "enforce_statfs"},
+		{"high", "allow.raw_sockets", "allow.mount"},
+		{"high", "allow.vmm", "vnet"},
+		{"medium", "securelevel"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v but got %v", want, got)
+	}
+	minimal, err := jail.LookupProfile("minimal")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s = minimal.Apply(s)
+	s.Vnet = true
+	if findings := s.Lint(); len(findings) != 0 {
+		t.Errorf("expected no findings but got %v", findings)
+	}
+}
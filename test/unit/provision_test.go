@@ -0,0 +1,239 @@
+package test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"git.hardenedbsd.org/0x1eef/jail/provision"
+)
+
+// entry is an entry of a test archive
+type entry struct {
+	name, link, body string
+	typ              byte
+	mode             int64
+	flags            string
+}
+
+// release writes a release with a gzip-compressed base set of
+// entries, and its MANIFEST
+func release(t *testing.T, entries []entry) (*provision.Release, map[string]uint32) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typ, Mode: e.mode, Size: int64(len(e.body)), ModTime: time.Unix(1767225600, 0), Format: tar.FormatPAX}
+		if e.flags != "" {
+			h.PAXRecords = map[string]string{"SCHILY.fflags": e.flags}
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatalf("%v", err)
+		}
+		io.WriteString(tw, e.body)
+	}
+	tw.Close()
+	zw.Close()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "base.txz"), buf.Bytes(), 0o644)
+	sum := sha256.Sum256(buf.Bytes())
+	manifest := fmt.Sprintf("base.txz\t%s\t42\tbase\t\"Base system (MANDATORY)\"\ton\n", hex.EncodeToString(sum[:]))
+	os.WriteFile(filepath.Join(dir, "MANIFEST"), []byte(manifest), 0o644)
+	flags := make(map[string]uint32)
+	return &provision.Release{
+		Dir:        dir,
+		Decompress: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		Chflags: func(path string, f uint32) error {
+			flags[path] = f
+			return nil
+		},
+	}, flags
+}
+
+func TestExtract(t *testing.T) {
+	rel, flags := release(t, []entry{
+		{name: "./", typ: tar.TypeDir, mode: 0o755},
+		{name: "./bin/", typ: tar.TypeDir, mode: 0o755},
+		{name: "./bin/test", typ: tar.TypeReg, mode: 0o555, body: "#!/bin/sh\n"},
+		{name: "./bin/[", typ: tar.TypeLink, link: "./bin/test"},
+		{name: "./usr/bin/su", typ: tar.TypeReg, mode: 0o4555, body: "su", flags: "schg"},
+		{name: "./sys", typ: tar.TypeSymlink, link: "usr/src/sys"},
+		{name: "./var/empty/", typ: tar.TypeDir, mode: 0o555, flags: "schg"},
+	})
+	root := t.TempDir()
+	if err := rel.Extract(root, "base"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	fi, err := os.Stat(filepath.Join(root, "bin", "test"))
+	if err != nil || fi.Mode().Perm() != 0o555 {
+		t.Fatalf("expected bin/test with mode 0555 but got %v, %v", fi, err)
+	}
+	if link, err := os.Stat(filepath.Join(root, "bin", "[")); err != nil || !os.SameFile(fi, link) {
+		t.Fatalf("expected bin/[ to be a hardlink of bin/test: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "usr", "bin", "su")); err != nil || fi.Mode()&fs.ModeSetuid == 0 {
+		t.Fatalf("expected usr/bin/su to be setuid but got %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "sys")); err != nil || target != "usr/src/sys" {
+		t.Fatalf("expected sys -> usr/src/sys but got %q, %v", target, err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "var", "empty")); err != nil || fi.Mode().Perm() != 0o555 || !fi.ModTime().Equal(time.Unix(1767225600, 0)) {
+		t.Fatalf("expected var/empty with mode 0555 and its mtime but got %v, %v", fi, err)
+	}
+	want := map[string]uint32{
+		filepath.Join(root, "usr", "bin", "su"): 0x20000,
+		filepath.Join(root, "var", "empty"):     0x20000,
+	}
+	if !reflect.DeepEqual(flags, want) {
+		t.Fatalf("expected flags %v but got %v", want, flags)
+	}
+}
+
+func TestExtractReplaced(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "rc"), []byte("#!/bin/sh\n"), 0o644)
+	rel, flags := release(t, []entry{
+		{name: "./x", typ: tar.TypeReg, mode: 0o644, body: "x", flags: "schg"},
+		{name: "./x", typ: tar.TypeSymlink, link: filepath.Join(outside, "rc")},
+		{name: "./y", typ: tar.TypeReg, mode: 0o644, body: "y", flags: "uappnd"},
+	})
+	chflags := rel.Chflags
+	rel.Chflags = func(path string, f uint32) error {
+		if fi, err := os.Lstat(path); err != nil || fi.Mode()&fs.ModeSymlink != 0 {
+			t.Errorf("expected chflags on a file of the archive but got %s", path)
+		}
+		return chflags(path, f)
+	}
+	root := t.TempDir()
+	if err := rel.Extract(root, "base"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := map[string]uint32{filepath.Join(root, "y"): 0x4}; !reflect.DeepEqual(flags, want) {
+		t.Fatalf("expected flags %v but got %v", want, flags)
+	}
+}
+
+func TestExtractTraversal(t *testing.T) {
+	tests := map[string][]entry{
+		"dot-dot":  {{name: "../evil", typ: tar.TypeReg, mode: 0o644, body: "x"}},
+		"nested":   {{name: "./etc/../../evil", typ: tar.TypeReg, mode: 0o644, body: "x"}},
+		"absolute": {{name: "/etc/evil", typ: tar.TypeReg, mode: 0o644, body: "x"}},
+		"symlink": {
+			{name: "./etc", typ: tar.TypeSymlink, link: "/tmp"},
+			{name: "./etc/evil", typ: tar.TypeReg, mode: 0o644, body: "x"},
+		},
+		"hardlink": {{name: "./passwd", typ: tar.TypeLink, link: "../../etc/passwd"}},
+		"hardlink to symlink": {
+			{name: "./etc", typ: tar.TypeSymlink, link: "/etc"},
+			{name: "./passwd", typ: tar.TypeLink, link: "./etc/passwd"},
+		},
+	}
+	for name, entries := range tests {
+		rel, _ := release(t, entries)
+		dir := t.TempDir()
+		root := filepath.Join(dir, "root")
+		if err := rel.Extract(root, "base"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "evil")); err == nil {
+			t.Errorf("%s: expected no file outside of the root", name)
+		}
+	}
+}
+
+func TestExtractManifest(t *testing.T) {
+	rel, flags := release(t, []entry{{name: "./COPYRIGHT", typ: tar.TypeReg, mode: 0o444, body: "x", flags: "schg"}})
+	if err := rel.Verify("base"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := rel.Verify("lib32"); err == nil || !strings.Contains(err.Error(), "lib32.txz") {
+		t.Fatalf("expected lib32.txz to be missing from the MANIFEST but got %v", err)
+	}
+	root := filepath.Join(t.TempDir(), "root")
+	if err := rel.Extract(root, "base", "lib32"); err == nil || !strings.Contains(err.Error(), "lib32.txz") {
+		t.Fatalf("expected lib32.txz to be missing from the MANIFEST but got %v", err)
+	}
+	if _, err := os.Stat(root); err == nil {
+		t.Fatalf("expected nothing to be extracted")
+	}
+	// A gzip member appended to the set leaves a valid archive, that
+	// only the sum of the whole file catches
+	f, _ := os.OpenFile(filepath.Join(rel.Dir, "base.txz"), os.O_APPEND|os.O_WRONLY, 0)
+	zw := gzip.NewWriter(f)
+	zw.Write(make([]byte, 1024))
+	zw.Close()
+	f.Close()
+	if err := rel.Extract(root, "base"); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Fatalf("expected a sha256 mismatch but got %v", err)
+	}
+	if len(flags) != 0 {
+		t.Fatalf("expected no flags to be set but got %v", flags)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	if f, err := provision.ParseFlags("schg,uappnd,nodump"); err != nil || f != 0x20005 {
+		t.Fatalf("expected 0x20005 but got %#x, %v", f, err)
+	}
+	if f, err := provision.ParseFlags("schg,noschg"); err != nil || f != 0 {
+		t.Fatalf("expected no flags but got %#x, %v", f, err)
+	}
+	if _, err := provision.ParseFlags("sticky"); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	host := t.TempDir()
+	os.WriteFile(filepath.Join(host, "resolv.conf"), []byte("nameserver 10.0.0.1\n"), 0o644)
+	os.WriteFile(filepath.Join(host, "localtime"), []byte("TZif2"), 0o644)
+	s := provision.DefaultSeed("web.local")
+	s.ResolvConf = filepath.Join(host, "resolv.conf")
+	s.LocalTime = filepath.Join(host, "localtime")
+	root := t.TempDir()
+	if err := s.Apply(root); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "etc", "resolv.conf")); string(b) != "nameserver 10.0.0.1\n" {
+		t.Fatalf("expected resolv.conf to be copied but got %q", b)
+	}
+	want := "clear_tmp_enable=\"YES\"\ncron_flags=\"-J 60\"\nhostname=\"web.local\"\nsendmail_enable=\"NONE\"\nsyslogd_flags=\"-ss\"\n"
+	if b, _ := os.ReadFile(filepath.Join(root, "etc", "rc.conf")); string(b) != want {
+		t.Fatalf("expected %q but got %q", want, b)
+	}
+	s = provision.Seed{RCConf: map[string]string{"motd": "$(id) `id` \\ \""}}
+	root = t.TempDir()
+	if err := s.Apply(root); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want = "motd=\"\\$(id) \\`id\\` \\\\ \\\"\"\n"
+	if b, _ := os.ReadFile(filepath.Join(root, "etc", "rc.conf")); string(b) != want {
+		t.Fatalf("expected %q but got %q", want, b)
+	}
+	s.RCConf = map[string]string{"x;reboot": "YES"}
+	if err := s.Apply(t.TempDir()); err == nil {
+		t.Fatalf("expected an error for an invalid variable name")
+	}
+	s = provision.DefaultSeed("web.local")
+	evil := t.TempDir()
+	root = t.TempDir()
+	os.Symlink(evil, filepath.Join(root, "etc"))
+	if err := s.Apply(root); err == nil {
+		t.Fatalf("expected an error for a symbolic link")
+	}
+	if _, err := os.Stat(filepath.Join(evil, "resolv.conf")); err == nil {
+		t.Fatalf("expected no file outside of the root")
+	}
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail/rcconf"
+)
+
+const rcConf = `# Host configuration
+hostname="host.local"
+sshd_enable=YES   # remote access
+jail_enable="YES"
+jail_list="web db" # started in order
+motd='$USER "x"'
+ifconfig_em0="inet 10.0.0.1
+ netmask 255.255.255.0"
+jail_web_flags="-l \"-U\" \$x"
+[ -r /etc/rc.conf.local ] && . /etc/rc.conf.local
+sshd_enable="NO"
+`
+
+func TestRCConfParse(t *testing.T) {
+	f, err := rcconf.Parse(strings.NewReader(rcConf))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	for key, want := range map[string]string{
+		"hostname":       "host.local",
+		"sshd_enable":    "NO",
+		"jail_list":      "web db",
+		"motd":           `$USER "x"`,
+		"ifconfig_em0":   "inet 10.0.0.1\n netmask 255.255.255.0",
+		"jail_web_flags": `-l "-U" $x`,
+	} {
+		if v, ok := f.Get(key); !ok || v != want {
+			t.Errorf("expected %s=%q but got %q, %v", key, want, v, ok)
+		}
+	}
+	want := []string{"hostname", "sshd_enable", "jail_enable", "jail_list", "motd", "ifconfig_em0", "jail_web_flags"}
+	if keys := f.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected %v but got %v", want, keys)
+	}
+	if string(f.Bytes()) != rcConf {
+		t.Errorf("expected the file to be kept as it is but got:\n%s", f.Bytes())
+	}
+	if _, err := rcconf.Parse(strings.NewReader("a=1\nb=\"open\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error for an unterminated quote but got %v", err)
+	}
+}
+
+func TestRCConfEdit(t *testing.T) {
+	f, _ := rcconf.Parse(strings.NewReader(rcConf))
+	f.Set("jail_list", "web db mail")
+	f.Set("hostname", "host.local")
+	f.Set("cron_flags", `-J "$x"`)
+	f.Delete("sshd_enable")
+	if err := f.Set("jail-list", "x"); err == nil {
+		t.Fatalf("expected an error for an invalid name")
+	}
+	b := string(f.Bytes())
+	for _, want := range []string{
+		"# Host configuration\nhostname=\"host.local\"\njail_enable",
+		"jail_list=\"web db mail\" # started in order\n",
+		"cron_flags=\"-J \\\"\\$x\\\"\"\n",
+	} {
+		if !strings.Contains(b, want) {
+			t.Errorf("expected %q in:\n%s", want, b)
+		}
+	}
+	if strings.Contains(b, "sshd_enable") {
+		t.Errorf("expected sshd_enable to be deleted:\n%s", b)
+	}
+	g, err := rcconf.Parse(strings.NewReader(b))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if v, _ := g.Get("cron_flags"); v != `-J "$x"` {
+		t.Errorf("expected the value to round-trip but got %q", v)
+	}
+}
+
+func TestRCConfWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rc.conf")
+	os.WriteFile(path, []byte(rcConf), 0o600)
+	f, err := rcconf.Read(path)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f.SetParallelStart(true)
+	if err := f.Write(path); err != nil {
+		t.Fatalf("%v", err)
+	}
+	fi, _ := os.Stat(path)
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected the mode to be kept but got %v", fi.Mode())
+	}
+	g, _ := rcconf.Read(path)
+	if !g.ParallelStart() {
+		t.Errorf("expected jail_parallel_start to be written")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("expected no temporary file but got %v", entries)
+	}
+}
+
+func TestRCConfJails(t *testing.T) {
+	f, _ := rcconf.Parse(strings.NewReader(rcConf))
+	if names, all := f.BootJails(); !reflect.DeepEqual(names, []string{"web", "db"}) || all {
+		t.Fatalf("expected [web db] but got %v, %v", names, all)
+	}
+	f.EnableJail("mail")
+	f.EnableJail("web")
+	if list := f.JailList(); !reflect.DeepEqual(list, []string{"web", "db", "mail"}) {
+		t.Fatalf("expected mail to be appended but got %v", list)
+	}
+	for _, name := range []string{"web", "db", "mail"} {
+		f.DisableJail(name)
+	}
+	if f.JailEnabled() {
+		t.Fatalf("expected jail_enable to be turned off with an empty jail_list")
+	}
+	if names, all := f.BootJails(); names != nil || all {
+		t.Fatalf("expected no jail at boot but got %v, %v", names, all)
+	}
+	f.Set("jail_enable", "yes")
+	if _, all := f.BootJails(); !all {
+		t.Fatalf("expected every jail to start with an empty jail_list")
+	}
+	if err := f.DisableJail("web"); !errors.Is(err, rcconf.ErrEveryJail) {
+		t.Fatalf("expected ErrEveryJail but got %v", err)
+	}
+	if !f.JailEnabled() {
+		t.Fatalf("expected jail_enable to be left on")
+	}
+	f.SetJailVar("mail.local", "flags", "-l")
+	if vars := f.JailVars("mail.local"); !reflect.DeepEqual(vars, map[string]string{"flags": "-l"}) {
+		t.Fatalf("unexpected variables: %v", vars)
+	}
+	if vars := f.JailVars("web"); !reflect.DeepEqual(vars, map[string]string{"flags": `-l "-U" $x`}) {
+		t.Fatalf("unexpected variables: %v", vars)
+	}
+}
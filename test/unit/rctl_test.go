@@ -0,0 +1,188 @@
+package test
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/rctl"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// limits is a fake rctl.Backend
+type limits struct {
+	rules []rctl.Rule
+	usage map[string]int64
+}
+
+func (l *limits) Add(r rctl.Rule) error {
+	l.rules = append(l.rules, r)
+	return nil
+}
+
+func (l *limits) Remove(filter rctl.Rule) error {
+	var kept []rctl.Rule
+	for _, r := range l.rules {
+		if !strings.HasPrefix(r.String(), filter.Filter()) {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(l.rules) {
+		return errors.New("no such process")
+	}
+	l.rules = kept
+	return nil
+}
+
+func (l *limits) Rules(filter rctl.Rule) ([]rctl.Rule, error) {
+	var rules []rctl.Rule
+	for _, r := range l.rules {
+		if strings.HasPrefix(r.String(), filter.Filter()) {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+func (l *limits) Usage(subject rctl.Rule) (map[string]int64, error) {
+	return l.usage, nil
+}
+
+func useLimits(t *testing.T) *limits {
+	l := &limits{}
+	prev := rctl.SetBackend(l)
+	t.Cleanup(func() { rctl.SetBackend(prev) })
+	return l
+}
+
+func TestParseRule(t *testing.T) {
+	tests := map[string]rctl.Rule{
+		"jail:web:memoryuse:deny=512m":   {Subject: "jail", SubjectID: "web", Resource: "memoryuse", Action: "deny", Amount: 512 << 20},
+		"jail:web:maxproc:deny=100/user": {Subject: "jail", SubjectID: "web", Resource: "maxproc", Action: "deny", Amount: 100, Per: "user"},
+		"user:1001:pcpu:sigterm=50":      {Subject: "user", SubjectID: "1001", Resource: "pcpu", Action: "sigterm", Amount: 50},
+		"jail:web:openfiles:log=1K":      {Subject: "jail", SubjectID: "web", Resource: "openfiles", Action: "log", Amount: 1024},
+	}
+	for s, want := range tests {
+		r, err := rctl.ParseRule(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if !reflect.DeepEqual(r, want) {
+			t.Errorf("%s: expected %+v but got %+v", s, want, r)
+		} else if r2, _ := rctl.ParseRule(r.String()); r2 != r {
+			t.Errorf("%s: %s does not round-trip", s, r)
+		}
+	}
+	for _, s := range []string{
+		"jail:web:memoryuse:deny",
+		"jail::memoryuse:deny=1",
+		"cell:web:memoryuse:deny=1",
+		"jail:web:memory:deny=1",
+		"jail:web:memoryuse:refuse=1",
+		"jail:web:memoryuse:deny=-1",
+		"jail:web:memoryuse:deny=1x",
+		"jail:web:memoryuse:deny=1/host",
+		"jail:web:memoryuse:deny:x=1",
+	} {
+		if _, err := rctl.ParseRule(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestRuleFilter(t *testing.T) {
+	f, err := rctl.ParseFilter("jail::memoryuse")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if f.Filter() != "jail::memoryuse" {
+		t.Errorf("unexpected filter: %s", f.Filter())
+	}
+	if f := (rctl.Rule{Subject: "jail", SubjectID: "web"}).Filter(); f != "jail:web" {
+		t.Errorf("unexpected filter: %s", f)
+	}
+}
+
+func TestRctlCommand(t *testing.T) {
+	var cmds []string
+	c := rctl.Command{Runner: runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd.Args, " "))
+		switch cmd.Args[1] {
+		case "-n":
+			return []byte("jail:web:memoryuse:deny=536870912\njail:web:maxproc:deny=100\n"), nil
+		case "-u":
+			return []byte("cputime=12\nmemoryuse=1048576\n"), nil
+		}
+		return nil, nil
+	})}
+	web := rctl.Rule{Subject: "jail", SubjectID: "web"}
+	rules, err := c.Rules(web)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if len(rules) != 2 || rules[0].Amount != 512<<20 || rules[1].Resource != "maxproc" {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+	usage, err := c.Usage(web)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if !reflect.DeepEqual(usage, map[string]int64{"cputime": 12, "memoryuse": 1 << 20}) {
+		t.Errorf("unexpected usage: %v", usage)
+	}
+	c.Add(rules[1])
+	c.Remove(web)
+	want := []string{
+		"rctl -n jail:web",
+		"rctl -u jail:web",
+		"rctl -a jail:web:maxproc:deny=100",
+		"rctl -r jail:web",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("expected %q but got %q", want, cmds)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	l := useLimits(t)
+	l.rules = []rctl.Rule{
+		{Subject: "jail", SubjectID: "web", Resource: "maxproc", Action: "deny", Amount: 10},
+		{Subject: "jail", SubjectID: "db", Resource: "maxproc", Action: "deny", Amount: 10},
+	}
+	j := &jail.Jail{Name: "web"}
+	err := j.SetLimits([]rctl.Rule{
+		{Resource: rctl.MemoryUse, Action: rctl.Deny, Amount: 512 << 20},
+		{Resource: rctl.PCPU, Action: rctl.Throttle, Amount: 50},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	rules, _ := j.Limits()
+	want := []string{"jail:web:memoryuse:deny=536870912", "jail:web:pcpu:throttle=50"}
+	if got := ruleStrings(rules); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q but got %q", want, got)
+	}
+	if len(l.rules) != 3 {
+		t.Errorf("expected the rules of other jails to be kept: %v", ruleStrings(l.rules))
+	}
+	if err := (&jail.Jail{Name: "new"}).SetLimits(nil); err != nil {
+		t.Errorf("%v", err)
+	}
+	if err := j.SetLimits([]rctl.Rule{{Subject: "jail", SubjectID: "db", Resource: rctl.MaxProc, Action: rctl.Deny}}); err == nil {
+		t.Errorf("expected an error for a rule of another jail")
+	}
+	if err := j.SetLimits([]rctl.Rule{{Resource: "memory", Action: rctl.Deny}}); err == nil {
+		t.Errorf("expected an error for an unknown resource")
+	}
+	if rules, _ := j.Limits(); len(rules) != 2 {
+		t.Errorf("expected invalid rules to leave the limits alone: %v", ruleStrings(rules))
+	}
+}
+
+func ruleStrings(rules []rctl.Rule) []string {
+	s := make([]string, 0, len(rules))
+	for _, r := range rules {
+		s = append(s, r.String())
+	}
+	return s
+}
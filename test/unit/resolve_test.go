@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+// resolver is a fake jail.Resolver
+type resolver map[string][]netip.Addr
+
+func (r resolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return r[host], nil
+}
+
+var hosts = resolver{
+	"web.local": {netip.MustParseAddr("10.0.0.4"), netip.MustParseAddr("fd00::4")},
+	"www.local": {netip.MustParseAddr("10.0.0.5")},
+}
+
+func TestSpecResolve(t *testing.T) {
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	s.IPHostname = true
+	r, err := s.Resolve(context.Background(), hosts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := hosts["web.local"][:1]; !reflect.DeepEqual(r.IP4, want) {
+		t.Errorf("expected %v but got %v", want, r.IP4)
+	}
+	if want := hosts["web.local"][1:]; !reflect.DeepEqual(r.IP6, want) {
+		t.Errorf("expected %v but got %v", want, r.IP6)
+	}
+}
+
+func TestConfigJailSpec(t *testing.T) {
+	c, err := jail.ParseConfig(strings.NewReader(`
+	web {
+		path = /jails/web;
+		host.hostname = web.local;
+		interface = em0;
+		ip4.addr = 10.0.0.4, lo1|10.1.0.4/24;
+		ip_hostname;
+		securelevel = 3;
+		vnet = new;
+		nopersist;
+		allow.nosuser;
+		allow.raw_sockets;
+		exec.start = "/bin/sh /etc/rc";
+	}`))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s, err := c.Jails[0].Spec()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := jail.NewSpec("web", "/jails/web")
+	want.Hostname = "web.local"
+	want.Interface = "em0"
+	want.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.4")}
+	want.Aliases = []jail.IPAlias{{Interface: "lo1", Prefix: netip.MustParsePrefix("10.1.0.4/24")}}
+	want.IPHostname = true
+	want.SecureLevel = 3
+	want.Vnet = true
+	want.Persist = false
+	want.Perms.AllowRoot = false
+	want.Perms.AllowRawSockets = true
+	want.Exec.Start = []string{"/bin/sh /etc/rc"}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("expected %+v but got %+v", want, s)
+	}
+}
+
+func TestCreateIPHostname(t *testing.T) {
+	jailtest.Use(t)
+	prev := jail.SetResolver(hosts)
+	t.Cleanup(func() { jail.SetResolver(prev) })
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IPHostname = true
+	j, err := jail.Create(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(j.IP4, hosts["web.local"][:1]) || !reflect.DeepEqual(j.IP6, hosts["web.local"][1:]) {
+		t.Fatalf("expected the addresses of web.local but got %v %v", j.IP4, j.IP6)
+	}
+}
+
+func TestSetHostnameIP(t *testing.T) {
+	jailtest.Use(t)
+	prev := jail.SetResolver(hosts)
+	t.Cleanup(func() { jail.SetResolver(prev) })
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IPHostname = true
+	s.IP4 = []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+	j, err := jail.Create(s)
+	if err == nil {
+		err = j.SetHostnameIP(context.Background(), "www.local", hosts)
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	j, err = jail.FindByID(j.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.5")}
+	if j.Hostname != "www.local" || !reflect.DeepEqual(j.IP4, want) || len(j.IP6) != 0 {
+		t.Fatalf("unexpected jail: %+v", j)
+	}
+}
+
+// failingResolver is a jail.Resolver that resolves no hostname
+type failingResolver struct{}
+
+func (failingResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestPlanResolveError(t *testing.T) {
+	jailtest.Use(t)
+	useHost(t)
+	prev := jail.SetResolver(failingResolver{})
+	t.Cleanup(func() { jail.SetResolver(prev) })
+	s := jail.NewSpec("web", "/jails/web")
+	s.Hostname = "web.local"
+	s.IPHostname = true
+	if _, err := jail.NewPlan([]jail.Spec{s}, false); err == nil || !strings.HasPrefix(err.Error(), `jail "web": `) {
+		t.Fatalf("expected the error to name the jail but got %v", err)
+	}
+}
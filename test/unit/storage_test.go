@@ -0,0 +1,159 @@
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+	"git.hardenedbsd.org/0x1eef/jail/storage"
+)
+
+func TestDirStorage(t *testing.T) {
+	var d storage.Driver = storage.Dir{Root: t.TempDir()}
+	root, err := d.Create("base")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.WriteFile(filepath.Join(root, "version"), []byte("14.1"), 0o644)
+	if err := d.Snapshot("base", "p1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	os.WriteFile(filepath.Join(root, "version"), []byte("14.2"), 0o644)
+	if err := d.Snapshot("base", "p2"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := d.Snapshot("base", "p2"); err == nil {
+		t.Fatalf("expected an error for an existing snapshot")
+	}
+	if snaps, err := d.Snapshots("base"); err != nil || !reflect.DeepEqual(snaps, []string{"p1", "p2"}) {
+		t.Fatalf("expected [p1 p2] but got %v, %v", snaps, err)
+	}
+	web, err := d.Clone("base", "p1", "web")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(web, "version")); string(b) != "14.1" {
+		t.Fatalf("expected the clone to hold 14.1 but got %q", b)
+	}
+	if p, err := d.Path("web"); err != nil || p != web {
+		t.Fatalf("expected %s but got %s, %v", web, p, err)
+	}
+	if err := d.Rollback("base", "p1"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "version")); string(b) != "14.1" {
+		t.Fatalf("expected the rollback to restore 14.1 but got %q", b)
+	}
+	if snaps, _ := d.Snapshots("base"); !reflect.DeepEqual(snaps, []string{"p1"}) {
+		t.Fatalf("expected the later snapshots to be destroyed but got %v", snaps)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(root)); len(entries) != 3 {
+		t.Fatalf("expected the rollback to leave no copy behind but got %v", entries)
+	}
+	os.WriteFile(filepath.Join(root, "version"), []byte("14.3"), 0o644)
+	os.RemoveAll(filepath.Join(filepath.Dir(root), ".snapshots", "base", "p1"))
+	if err := d.Rollback("base", "p1"); err == nil {
+		t.Fatalf("expected an error for a missing snapshot")
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "version")); string(b) != "14.3" {
+		t.Fatalf("expected a failed rollback to keep the root but got %q", b)
+	}
+	if err := d.Destroy("base"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := d.Path("base"); err == nil {
+		t.Fatalf("expected an error for a destroyed root")
+	}
+	if snaps, _ := d.Snapshots("base"); len(snaps) != 0 {
+		t.Fatalf("expected no snapshot but got %v", snaps)
+	}
+	if _, err := d.Create("../etc"); err == nil {
+		t.Fatalf("expected an error for an invalid name")
+	}
+}
+
+func TestZFSStorage(t *testing.T) {
+	var cmds []string
+	z := storage.ZFS{Dataset: "zroot/jails", Runner: runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd.Args, " "))
+		switch cmd.Args[1] {
+		case "get":
+			return []byte("/jails/web\n"), nil
+		case "list":
+			return []byte("zroot/jails/web@p1\nzroot/jails/web@p2\n"), nil
+		}
+		return nil, nil
+	})}
+	if p, err := z.Clone("base", "p1", "web"); err != nil || p != "/jails/web" {
+		t.Fatalf("expected /jails/web but got %s, %v", p, err)
+	}
+	if snaps, err := z.Snapshots("web"); err != nil || !reflect.DeepEqual(snaps, []string{"p1", "p2"}) {
+		t.Fatalf("expected [p1 p2] but got %v, %v", snaps, err)
+	}
+	z.Snapshot("web", "p3")
+	z.Rollback("web", "p1")
+	z.DestroySnapshot("web", "p1")
+	z.Destroy("web")
+	if _, err := z.Create("web@p1"); err == nil {
+		t.Fatalf("expected an error for an invalid name")
+	}
+	want := []string{
+		"zfs clone -p zroot/jails/base@p1 zroot/jails/web",
+		"zfs get -H -o value mountpoint zroot/jails/web",
+		"zfs list -H -t snapshot -o name -s createtxg -d 1 zroot/jails/web",
+		"zfs snapshot zroot/jails/web@p3",
+		"zfs rollback -r zroot/jails/web@p1",
+		"zfs destroy zroot/jails/web@p1",
+		"zfs destroy -r zroot/jails/web",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("expected %q but got %q", want, cmds)
+	}
+}
+
+func TestZFSDelegate(t *testing.T) {
+	var cmds []string
+	z := storage.ZFS{Dataset: "zroot/jails", Runner: runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd.Args, " "))
+		return nil, nil
+	})}
+	j := &jail.Jail{ID: 4, Name: "web", EnforceStatFS: 2}
+	if err := z.Delegate(j, "zroot/jails/web/data"); err == nil {
+		t.Fatalf("expected an error for a jail that cannot mount")
+	}
+	j.Perms.AllowMount, j.Perms.AllowMountZfs = true, true
+	if err := z.Delegate(j, "zroot/jails/web/data"); err == nil {
+		t.Fatalf("expected an error for an enforce_statfs of 2")
+	}
+	j.EnforceStatFS = 1
+	for _, dataset := range []string{"", "-r", "zroot/jails/web@p1"} {
+		if err := z.Delegate(j, dataset); err == nil {
+			t.Fatalf("expected an error for dataset %q", dataset)
+		}
+	}
+	if err := z.Delegate(j, "zroot/jails/web/data"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	z.Undelegate(j, "zroot/jails/web/data")
+	want := []string{
+		"zfs set jailed=on zroot/jails/web/data",
+		"zfs jail 4 zroot/jails/web/data",
+		"zfs unjail 4 zroot/jails/web/data",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("expected %q but got %q", want, cmds)
+	}
+}
+
+func TestDatasetConfig(t *testing.T) {
+	s := jail.NewSpec("web", "/jails/web")
+	s.Datasets = []string{"zroot/jails/web/data"}
+	if got := s.ConfigJail().Values("zfs.dataset"); !reflect.DeepEqual(got, s.Datasets) {
+		t.Fatalf("expected %v but got %v", s.Datasets, got)
+	}
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+// mounts is a fake jail.MountDriver
+type mounts struct {
+	mounted []jail.Mount
+	fail    string
+}
+
+func (m *mounts) Mount(mt jail.Mount) error {
+	if mt.Target == m.fail {
+		return errors.New("mount failed")
+	}
+	m.mounted = append(m.mounted, mt)
+	return nil
+}
+
+func (m *mounts) Unmount(target string) error {
+	i := slices.IndexFunc(m.mounted, func(mt jail.Mount) bool { return mt.Target == target })
+	if i == -1 {
+		return errors.New("not mounted: " + target)
+	}
+	m.mounted = slices.Delete(m.mounted, i, i+1)
+	return nil
+}
+
+func (m *mounts) Mounts() ([]jail.Mount, error) {
+	return slices.Clone(m.mounted), nil
+}
+
+// useTemplates installs a fake MountDriver, and a TemplateDir with a
+// "14.1" template
+func useTemplates(t *testing.T) *mounts {
+	t.Helper()
+	m := &mounts{}
+	prev := jail.SetMountDriver(m)
+	t.Cleanup(func() { jail.SetMountDriver(prev) })
+	dir := t.TempDir()
+	prevDir := jail.TemplateDir
+	jail.TemplateDir = dir
+	t.Cleanup(func() { jail.TemplateDir = prevDir })
+	base := filepath.Join(dir, "14.1")
+	for _, d := range []string{"bin", "usr/bin", "etc/rc.d", "var/empty", "tmp", "root"} {
+		os.MkdirAll(filepath.Join(base, d), 0o755)
+	}
+	os.WriteFile(filepath.Join(base, "bin", "sh"), []byte("sh"), 0o555)
+	os.WriteFile(filepath.Join(base, "etc", "rc.conf"), []byte("sshd_enable=\"NO\"\n"), 0o644)
+	os.Symlink("../var/tmp", filepath.Join(base, "etc", "tmp"))
+	os.Chmod(filepath.Join(base, "tmp"), 0o777|os.ModeSticky)
+	os.Chmod(filepath.Join(base, "var", "empty"), 0o555)
+	return m
+}
+
+func TestNewThinRoot(t *testing.T) {
+	useTemplates(t)
+	root := filepath.Join(t.TempDir(), "web")
+	if err := jail.NewThinRoot("14.1", root); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "etc", "rc.conf")); err != nil || !strings.Contains(string(b), "sshd_enable") {
+		t.Fatalf("expected etc to be copied but got %q, %v", b, err)
+	}
+	if link, err := os.Readlink(filepath.Join(root, "etc", "tmp")); err != nil || link != "../var/tmp" {
+		t.Fatalf("expected the symbolic link to be copied but got %q, %v", link, err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "tmp")); err != nil || fi.Mode()&os.ModeSticky == 0 {
+		t.Fatalf("expected tmp to keep its sticky bit but got %v, %v", fi, err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "var", "empty")); err != nil || fi.Mode().Perm() != 0o555 {
+		t.Fatalf("expected var/empty to keep its mode but got %v, %v", fi, err)
+	}
+	for _, dir := range jail.TemplateBase {
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("expected an empty mount point for %s but got %v, %v", dir, entries, err)
+		}
+	}
+	if err := jail.NewThinRoot("14.1", root); err == nil {
+		t.Fatalf("expected an error for a root that is not empty")
+	}
+	if err := jail.NewThinRoot("../14.1", filepath.Join(t.TempDir(), "db")); err == nil {
+		t.Fatalf("expected an error for an invalid template name")
+	}
+}
+
+func TestCreateThin(t *testing.T) {
+	jailtest.Use(t)
+	m := useTemplates(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.Template = "14.1"
+	if _, err := jail.Create(s); err != nil {
+		t.Fatalf("%v", err)
+	}
+	var targets []string
+	for _, mt := range m.mounted {
+		if mt.FSType != "nullfs" || mt.Options != "ro" || mt.Source != filepath.Join(jail.TemplateDir, "14.1", filepath.Base(mt.Target)) {
+			t.Errorf("expected a read-only nullfs mount of the template but got %s", mt)
+		}
+		targets = append(targets, mt.Target)
+	}
+	want := []string{"/jails/web/bin", "/jails/web/lib", "/jails/web/libexec", "/jails/web/sbin", "/jails/web/usr"}
+	if !reflect.DeepEqual(targets, want) {
+		t.Fatalf("expected %v but got %v", want, targets)
+	}
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	templates, err := jail.Templates()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(templates) != 1 || templates[0].Name != "14.1" || !reflect.DeepEqual(templates[0].Jails, []string{"web"}) {
+		t.Fatalf("expected template 14.1 used by web but got %+v", templates)
+	}
+	j, _ := jail.FindByName("web")
+	if err := jail.Destroy(j, s); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(m.mounted) != 0 {
+		t.Fatalf("expected the template to be unmounted but got %v", m.mounted)
+	}
+}
+
+func TestCreateThinRollback(t *testing.T) {
+	jailtest.Use(t)
+	m := useTemplates(t)
+	m.fail = "/jails/web/sbin"
+	s := jail.NewSpec("web", "/jails/web")
+	s.Template = "14.1"
+	if _, err := jail.Create(s); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(m.mounted) != 0 {
+		t.Fatalf("expected the mounts to be rolled back but got %v", m.mounted)
+	}
+	if jails, _ := jail.All(); len(jails) != 0 {
+		t.Fatalf("expected no jail but got %d", len(jails))
+	}
+}
+
+func TestThinConfig(t *testing.T) {
+	useTemplates(t)
+	s := jail.NewSpec("web", "/jails/web")
+	s.Template = "14.1"
+	mounts := s.ConfigJail().Values("mount")
+	if len(mounts) != len(jail.TemplateBase) || mounts[0] != filepath.Join(jail.TemplateDir, "14.1", "bin")+" /jails/web/bin nullfs ro 0 0" {
+		t.Fatalf("expected the mounts of the template but got %q", mounts)
+	}
+}
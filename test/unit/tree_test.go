@@ -0,0 +1,98 @@
+package test
+
+import (
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+func TestHierarchy(t *testing.T) {
+	jailtest.Use(t)
+	host, err := jail.Create(jail.NewSpec("host", "/jails/host"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := host.CreateChild(jail.NewSpec("web", "/jails/host/web")); err == nil {
+		t.Fatalf("expected an error when children.max is 0")
+	}
+	if err := host.SetChildrenMax(2); err != nil {
+		t.Fatalf("%v", err)
+	}
+	web, err := host.CreateChild(jail.NewSpec("web", "/jails/host/web"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if web.Name != "host.web" || web.Parent != host.ID {
+		t.Fatalf("unexpected child: %s (parent %d)", web.Name, web.Parent)
+	}
+	if err := web.SetChildrenMax(1); err != nil {
+		t.Fatalf("%v", err)
+	}
+	app, err := web.CreateChild(jail.NewSpec("host.web.app", "/jails/host/web/app"))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := jail.Create(jail.NewSpec("db", "/jails/db")); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if host, _ = jail.FindByID(host.ID); host.ChildrenCur != 1 || host.ChildrenMax != 2 {
+		t.Errorf("expected 1 of 2 children but got %d of %d", host.ChildrenCur, host.ChildrenMax)
+	}
+	ancestors, err := app.Ancestors()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(ancestors) != 2 || ancestors[0].Name != "host.web" || ancestors[1].Name != "host" {
+		t.Errorf("unexpected ancestors: %v", names(ancestors))
+	}
+	children, err := host.Children()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(children) != 1 || children[0].ID != web.ID {
+		t.Errorf("unexpected children: %v", names(children))
+	}
+	roots, err := jail.Tree()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(roots) != 2 || roots[0].Name != "host" || roots[1].Name != "db" {
+		t.Fatalf("unexpected roots: %+v", roots)
+	}
+	if n := roots[0].Children; len(n) != 1 || len(n[0].Children) != 1 || n[0].Children[0].Name != "host.web.app" {
+		t.Errorf("unexpected tree under host")
+	}
+	if err := host.SetChildrenMax(-1); err == nil {
+		t.Errorf("expected an error for a negative children.max")
+	}
+	if err := host.SetChildrenMax(int32(jail.MaxChildJails + 1)); err == nil {
+		t.Errorf("expected an error above MaxChildJails")
+	}
+}
+
+func TestCreateChildStale(t *testing.T) {
+	jailtest.Use(t)
+	host, _ := jail.Create(jail.NewSpec("host", "/jails/host"))
+	other, _ := jail.FindByID(host.ID)
+	if err := other.SetChildrenMax(1); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := host.CreateChild(jail.NewSpec("web", "/jails/host/web")); err != nil {
+		t.Fatalf("expected children.max to be read again but got %v", err)
+	}
+	if _, err := other.CreateChild(jail.NewSpec("db", "/jails/host/db")); err == nil {
+		t.Fatalf("expected an error once children.cur reaches children.max")
+	}
+	if other.ChildrenCur != 1 {
+		t.Errorf("expected children.cur to be read again but got %d", other.ChildrenCur)
+	}
+}
+
+func names(jails []*jail.Jail) []string {
+	s := make([]string, 0, len(jails))
+	for _, j := range jails {
+		s = append(s, j.Name)
+	}
+	return s
+}
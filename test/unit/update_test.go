@@ -0,0 +1,77 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+func TestUpdate(t *testing.T) {
+	jailtest.Use(t)
+	j, err := jail.NewJail("/jails/web")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	desired := *j
+	desired.Hostname = "web.local"
+	desired.Perms.AllowRoot = false
+	desired.Perms.AllowMount = true
+	if err := j.Update(desired); err != nil {
+		t.Fatalf("%v", err)
+	}
+	jj, err := jail.FindByID(j.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if jj.Hostname != "web.local" || jj.Perms.AllowRoot || !jj.Perms.AllowMount {
+		t.Fatalf("unexpected jail: %+v", jj)
+	}
+	if changes := jail.Diff(j, jj); len(changes) != 0 {
+		t.Fatalf("expected no changes but got %+v", changes)
+	}
+}
+
+func TestUpdateImmutable(t *testing.T) {
+	jailtest.Use(t)
+	j, err := jail.NewJail("/jails/web")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	desired := *j
+	desired.Hostname = "web.local"
+	desired.Vnet = true
+	if err := j.Update(desired); !errors.Is(err, jail.ErrImmutable) {
+		t.Fatalf("expected ErrImmutable but got %v", err)
+	}
+	params := jail.NewParams()
+	params.Add("path", "/jails/www")
+	if err := j.SetParams(params); !errors.Is(err, jail.ErrImmutable) {
+		t.Fatalf("expected ErrImmutable but got %v", err)
+	}
+	if jj, _ := jail.FindByID(j.ID); jj.Hostname != "" {
+		t.Fatalf("expected the jail to be unchanged")
+	}
+}
+
+func TestSetParams(t *testing.T) {
+	jailtest.Use(t)
+	j, err := jail.NewJail("/jails/web")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	params := jail.NewParams()
+	params.Add("host.hostname", "web.local")
+	params.Add("allow.nosuser", int32(1))
+	params.Add("securelevel", int32(2))
+	if err := j.SetParams(params); err != nil {
+		t.Fatalf("%v", err)
+	}
+	jj, err := jail.FindByID(j.ID)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if jj.Hostname != "web.local" || jj.Perms.AllowRoot || jj.SecureLevel != 2 {
+		t.Fatalf("unexpected jail: %+v", jj)
+	}
+}
@@ -0,0 +1,262 @@
+package test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+	"git.hardenedbsd.org/0x1eef/jail/users"
+)
+
+const masterPasswd = `# $FreeBSD$
+#
+root:$6$salt$hash:0:0::0:0:Charlie &:/root:/bin/sh
+toor:*:0:0::0:0:Bourne-again Superuser:/root:
+daemon:*:1:1::0:0:Owner of many system processes:/root:/usr/sbin/nologin
+www:*:80:80::0:0:World Wide Web Owner:/nonexistent:/usr/sbin/nologin
+`
+
+const group = `# $FreeBSD$
+#
+wheel:*:0:root
+daemon:*:1:
+www:*:80:
+`
+
+// newAccounts returns the Accounts of a jail with masterPasswd and
+// group, whose runner records the commands it is given
+func newAccounts(t *testing.T) (a *users.Accounts, root string, cmds *[]string) {
+	t.Helper()
+	jailtest.Use(t)
+	root = filepath.Join(t.TempDir(), "web")
+	os.MkdirAll(filepath.Join(root, "etc"), 0o755)
+	os.MkdirAll(filepath.Join(root, "home"), 0o755)
+	os.WriteFile(filepath.Join(root, "etc", "master.passwd"), []byte(masterPasswd), 0o600)
+	os.WriteFile(filepath.Join(root, "etc", "group"), []byte(group), 0o644)
+	j, err := jail.Create(jail.NewSpec("web", root))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cmds = &[]string{}
+	a = j.Users()
+	a.Runner = runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		*cmds = append(*cmds, strings.Join(cmd.Args, " "))
+		return nil, nil
+	})
+	return a, root, cmds
+}
+
+// testUser returns a user that the test can chown(2) files to: the
+// user of the test, unless it runs as root
+func testUser() users.User {
+	u := users.User{Name: "admin", Password: "*", UID: users.MinUID, GID: users.MinUID, Home: "/home/admin", Shell: "/bin/sh"}
+	if os.Getuid() != 0 {
+		u.UID, u.GID = uint32(os.Getuid()), uint32(os.Getgid())
+	}
+	return u
+}
+
+func TestParsePasswd(t *testing.T) {
+	p, err := users.ParsePasswd(strings.NewReader(masterPasswd))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	root, ok := p.Lookup("root")
+	want := users.User{Name: "root", Password: "$6$salt$hash", Gecos: "Charlie &", Home: "/root", Shell: "/bin/sh"}
+	if !ok || !reflect.DeepEqual(root, want) {
+		t.Fatalf("expected %+v but got %+v", want, root)
+	}
+	if u, ok := p.LookupID(80); !ok || u.Name != "www" {
+		t.Fatalf("expected www but got %+v", u)
+	}
+	if string(p.Bytes()) != masterPasswd {
+		t.Fatalf("expected the file to be kept as it is but got:\n%s", p.Bytes())
+	}
+	if err := p.Add(users.User{Name: "web", UID: 80}); err == nil {
+		t.Fatalf("expected an error for a taken uid")
+	}
+	if err := p.Add(users.User{Name: "we:b", UID: 1001}); err == nil {
+		t.Fatalf("expected an error for an invalid name")
+	}
+	if uid := p.NextUID(0); uid != 2 {
+		t.Fatalf("expected uid 2 but got %d", uid)
+	}
+	for _, line := range []string{"root:*:0:0::0:0:/root:/bin/sh", "root:*:x:0::0:0::/root:/bin/sh"} {
+		if _, err := users.ParsePasswd(strings.NewReader(line)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("expected an error for %q but got %v", line, err)
+		}
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	g, err := users.ParseGroups(strings.NewReader(group))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if wheel, ok := g.Lookup("wheel"); !ok || !reflect.DeepEqual(wheel.Members, []string{"root"}) {
+		t.Fatalf("expected wheel with root but got %+v", wheel)
+	}
+	if string(g.Bytes()) != group {
+		t.Fatalf("expected the file to be kept as it is but got:\n%s", g.Bytes())
+	}
+	g.AddMember("wheel", "admin")
+	g.AddMember("wheel", "admin")
+	g.RemoveMember("root")
+	if !strings.Contains(string(g.Bytes()), "\nwheel:*:0:admin\n") {
+		t.Fatalf("unexpected group file:\n%s", g.Bytes())
+	}
+	if err := g.AddMember("staff", "admin"); err == nil {
+		t.Fatalf("expected an error for a missing group")
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	a, root, cmds := newAccounts(t)
+	u := testUser()
+	if err := a.AddUser(u, "wheel"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := a.AddAuthorizedKey("admin", "ssh-ed25519 AAAAC3Nza admin@host\n"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	a.AddAuthorizedKey("admin", "ssh-ed25519 AAAAC3Nza admin@host")
+	if err := a.AddGroup(users.Group{Name: "staff"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	passwd, _ := a.Passwd()
+	if got, ok := passwd.Lookup("admin"); !ok || got != u {
+		t.Fatalf("expected %+v but got %+v", u, got)
+	}
+	groups, _ := a.Groups()
+	if g, ok := groups.LookupID(u.GID); !ok || g.Name != "admin" {
+		t.Fatalf("expected a group named after the user but got %+v", g)
+	}
+	if g, _ := groups.Lookup("wheel"); !reflect.DeepEqual(g.Members, []string{"root", "admin"}) {
+		t.Fatalf("expected admin in wheel but got %v", g.Members)
+	}
+	if g, _ := groups.Lookup("staff"); g.GID < users.MinUID || g.GID == u.GID {
+		t.Fatalf("expected a free gid from %d but got %d", users.MinUID, g.GID)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "etc", "master.passwd")); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected master.passwd to keep mode 0600 but got %v, %v", fi, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, "home", "admin", ".ssh", "authorized_keys")); string(b) != "ssh-ed25519 AAAAC3Nza admin@host\n" {
+		t.Fatalf("expected a single key but got %q", b)
+	}
+	for name, mode := range map[string]os.FileMode{"home/admin": 0o755, "home/admin/.ssh": 0o700, "home/admin/.ssh/authorized_keys": 0o600} {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != u.UID || st.Gid != u.GID || fi.Mode().Perm() != mode {
+			t.Errorf("expected %s to be owned by admin with mode %v but got %d:%d %v", name, mode, st.Uid, st.Gid, fi.Mode())
+		}
+	}
+	if want := "pwd_mkdb -p -d " + root + "/etc " + root + "/etc/master.passwd"; len(*cmds) != 1 || (*cmds)[0] != want {
+		t.Fatalf("expected %q but got %q", want, *cmds)
+	}
+	if err := a.RemoveUser("admin"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	groups, _ = a.Groups()
+	if g, _ := groups.Lookup("wheel"); !reflect.DeepEqual(g.Members, []string{"root"}) {
+		t.Fatalf("expected admin to be removed from wheel but got %v", g.Members)
+	}
+}
+
+func TestAccountsEscape(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "web")
+	os.MkdirAll(root, 0o755)
+	os.MkdirAll(filepath.Join(dir, "etc"), 0o755)
+	os.WriteFile(filepath.Join(dir, "etc", "master.passwd"), []byte(masterPasswd), 0o600)
+	os.WriteFile(filepath.Join(dir, "etc", "group"), []byte(group), 0o644)
+	os.Symlink("../etc", filepath.Join(root, "etc"))
+	a := users.New(jail.NewFS(root), root)
+	a.Runner = runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		t.Fatalf("expected pwd_mkdb not to run")
+		return nil, nil
+	})
+	if err := a.AddUser(users.User{Name: "admin", Password: "*"}); err == nil {
+		t.Fatalf("expected an error for an etc outside of the root")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "etc", "master.passwd")); string(b) != masterPasswd {
+		t.Fatalf("expected master.passwd outside of the root to be left alone")
+	}
+}
+
+// linkFS replaces etc with a link to another directory once
+// master.passwd has been written, as a process of the jail could
+type linkFS struct {
+	users.FS
+	root, target string
+}
+
+func (f linkFS) Rename(oldname, newname string) error {
+	if err := f.FS.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if newname == users.PasswdFile {
+		os.Rename(filepath.Join(f.root, "etc"), filepath.Join(f.root, "etc.orig"))
+		os.Symlink(f.target, filepath.Join(f.root, "etc"))
+	}
+	return nil
+}
+
+func TestAccountsMkdbRace(t *testing.T) {
+	a, root, cmds := newAccounts(t)
+	a.FS = linkFS{FS: a.FS, root: root, target: t.TempDir()}
+	if err := a.AddUser(users.User{Name: "www2", Password: "*", Home: "/nonexistent"}); err == nil {
+		t.Fatalf("expected an error for an etc replaced by a link")
+	}
+	if len(*cmds) != 0 {
+		t.Fatalf("expected pwd_mkdb not to run but got %q", *cmds)
+	}
+}
+
+func TestAuthorizedKeyLinks(t *testing.T) {
+	a, root, _ := newAccounts(t)
+	u := testUser()
+	if err := a.AddUser(u); err != nil {
+		t.Fatalf("%v", err)
+	}
+	home := filepath.Join(root, "home", "admin")
+	passwd := filepath.Join(root, "etc", "master.passwd")
+	before, _ := os.ReadFile(passwd)
+	for _, link := range []func(){
+		func() { os.Symlink("../../etc", filepath.Join(home, ".ssh")) },
+		func() {
+			os.Mkdir(filepath.Join(home, ".ssh"), 0o700)
+			os.Symlink("../../../etc/master.passwd", filepath.Join(home, ".ssh", "authorized_keys"))
+		},
+		func() {
+			os.Mkdir(filepath.Join(home, ".ssh"), 0o700)
+			os.Link(passwd, filepath.Join(home, ".ssh", "authorized_keys"))
+		},
+	} {
+		os.RemoveAll(filepath.Join(home, ".ssh"))
+		link()
+		if err := a.AddAuthorizedKey("admin", "ssh-ed25519 AAAAC3Nza admin@host"); err == nil {
+			t.Errorf("expected an error for a link in ~/.ssh")
+		}
+	}
+	fi, _ := os.Stat(passwd)
+	if b, _ := os.ReadFile(passwd); string(b) != string(before) || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected master.passwd to be left alone")
+	} else if os.Getuid() == 0 && fi.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Fatalf("expected master.passwd to stay owned by root")
+	}
+	os.RemoveAll(home)
+	os.Symlink("../etc", home)
+	if err := a.AddAuthorizedKey("admin", "ssh-ed25519 AAAAC3Nza admin@host"); err == nil {
+		t.Fatalf("expected an error for a home directory that is a link")
+	}
+}
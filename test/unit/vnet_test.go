@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+	"git.hardenedbsd.org/0x1eef/jail/vnet"
+)
+
+// interfaces is a fake vnet.Interfaces that records its calls
+type interfaces struct {
+	calls []string
+	fail  string
+}
+
+func (f *interfaces) call(s string) error {
+	f.calls = append(f.calls, s)
+	if f.fail != "" && strings.HasPrefix(s, f.fail) {
+		return errors.New("failed: " + s)
+	}
+	return nil
+}
+
+func (f *interfaces) CreateEpair(ctx context.Context) (string, string, error) {
+	return "epair0a", "epair0b", f.call("epair")
+}
+
+func (f *interfaces) CreateBridge(ctx context.Context) (string, error) {
+	return "bridge0", f.call("bridge")
+}
+
+func (f *interfaces) AddMember(ctx context.Context, bridge, iface string) error {
+	return f.call(fmt.Sprintf("addm %s %s", bridge, iface))
+}
+
+func (f *interfaces) Up(ctx context.Context, iface string) error {
+	return f.call("up " + iface)
+}
+
+func (f *interfaces) Move(ctx context.Context, iface string, jid int32) error {
+	return f.call(fmt.Sprintf("move %s %d", iface, jid))
+}
+
+func (f *interfaces) Destroy(ctx context.Context, iface string) error {
+	return f.call("destroy " + iface)
+}
+
+func (f *interfaces) SetAddr(ctx context.Context, jid int32, iface string, addr netip.Prefix) error {
+	return f.call(fmt.Sprintf("addr %d %s %s", jid, iface, addr))
+}
+
+func (f *interfaces) AddRoute(ctx context.Context, jid int32, gw netip.Addr) error {
+	return f.call(fmt.Sprintf("route %d %s", jid, gw))
+}
+
+func TestVnetAttach(t *testing.T) {
+	ifs := &interfaces{}
+	n := vnet.New(ifs)
+	j := &jail.Jail{ID: 5, Vnet: true}
+	l, err := n.Attach(context.Background(), j, vnet.Config{
+		Bridge:   "bridge0",
+		Addrs:    []netip.Prefix{netip.MustParsePrefix("10.0.0.5/24")},
+		Gateways: []netip.Addr{netip.MustParseAddr("10.0.0.1")},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := n.Detach(context.Background(), l); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{
+		"epair",
+		"addm bridge0 epair0a",
+		"up epair0a",
+		"move epair0b 5",
+		"addr 5 lo0 127.0.0.1/8",
+		"addr 5 epair0b 10.0.0.5/24",
+		"route 5 10.0.0.1",
+		"destroy epair0a",
+	}
+	if !reflect.DeepEqual(ifs.calls, want) {
+		t.Fatalf("expected %v but got %v", want, ifs.calls)
+	}
+}
+
+func TestVnetAttachCleanup(t *testing.T) {
+	ifs := &interfaces{fail: "move"}
+	_, err := vnet.New(ifs).Attach(context.Background(), &jail.Jail{ID: 5, Vnet: true}, vnet.Config{})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if last := ifs.calls[len(ifs.calls)-1]; last != "destroy epair0a" {
+		t.Fatalf("expected the epair to be destroyed but got %v", ifs.calls)
+	}
+	if _, err := vnet.New(ifs).Attach(context.Background(), &jail.Jail{ID: 5}, vnet.Config{}); err == nil {
+		t.Fatalf("expected an error for a non-VNET jail")
+	}
+}
+
+func TestIfconfig(t *testing.T) {
+	var cmds []string
+	ifs := vnet.Ifconfig{Runner: runner.Func(func(cmd *exec.Cmd) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd.Args, " "))
+		return []byte("epair3a\n"), nil
+	})}
+	ctx := context.Background()
+	a, b, err := ifs.CreateEpair(ctx)
+	if err != nil {
+		t.Fatalf("%v", err)
+	} else if a != "epair3a" || b != "epair3b" {
+		t.Fatalf("unexpected epair: %s %s", a, b)
+	}
+	ifs.Move(ctx, b, 5)
+	ifs.SetAddr(ctx, 5, b, netip.MustParsePrefix("fd00::5/64"))
+	ifs.AddRoute(ctx, 5, netip.MustParseAddr("10.0.0.1"))
+	want := []string{
+		"ifconfig epair create",
+		"ifconfig epair3b vnet 5",
+		"jexec 5 ifconfig epair3b inet6 fd00::5/64 alias up",
+		"jexec 5 route add -inet default 10.0.0.1",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("expected %v but got %v", want, cmds)
+	}
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/jailtest"
+)
+
+// events returns the events as strings
+func events(t *testing.T, w *jail.Watcher) []string {
+	t.Helper()
+	evs, err := w.Poll()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := make([]string, 0, len(evs))
+	for _, e := range evs {
+		s = append(s, e.String())
+	}
+	return s
+}
+
+func TestWatcher(t *testing.T) {
+	k := jailtest.Use(t)
+	web, _ := jail.Create(jail.NewSpec("web", "/jails/web"))
+	w := jail.NewWatcher()
+	if got, want := events(t, w), []string{"created 1 web"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+	if got := events(t, w); len(got) != 0 {
+		t.Fatalf("expected no events but got %q", got)
+	}
+	db, _ := jail.Create(jail.NewSpec("db", "/jails/db"))
+	params := jail.NewParams()
+	params.Add("securelevel", int32(3))
+	params.Add("host.hostname", "www.local")
+	if err := web.SetParams(params); err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []string{
+		`param-changed 1 web: ~ host.hostname: "web" => "www.local"`,
+		`param-changed 1 web: ~ securelevel: "-1" => "3"`,
+		"created 2 db",
+	}
+	if got := events(t, w); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+	k.SetDying(db.ID)
+	jail.Remove(web.ID)
+	want = []string{"removed 1 web", "dying 2 db"}
+	if got := events(t, w); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+}
+
+func TestWatchParamChanged(t *testing.T) {
+	jailtest.Use(t)
+	j, _ := jail.Create(jail.NewSpec("web", "/jails/web"))
+	w := jail.NewWatcher()
+	w.Poll()
+	j.AllowMount()
+	evs, _ := w.Poll()
+	want := jail.Change{Kind: jail.Changed, Param: "allow.mount", Old: "false", New: "true"}
+	if len(evs) != 1 || evs[0].Kind != jail.EventParamChanged || evs[0].Change != want {
+		t.Fatalf("expected %+v but got %+v", want, evs)
+	}
+	if !evs[0].Jail.Perms.AllowMount {
+		t.Fatalf("expected the event to carry the new state of the jail")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	jailtest.Use(t)
+	jail.Create(jail.NewSpec("web", "/jails/web"))
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := jail.Watch(ctx, time.Millisecond)
+	if e := <-ch; e.Kind != jail.EventCreated || e.Jail.Name != "web" {
+		t.Fatalf("expected web to be created but got %s", e)
+	}
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	if e := <-ch; e.Kind != jail.EventCreated || e.Jail.Name != "db" {
+		t.Fatalf("expected db to be created but got %s", e)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+// countingKernel counts the calls to jail_get(2)
+type countingKernel struct {
+	*jailtest.Kernel
+	gets int
+}
+
+func (k *countingKernel) Get(params jail.Params, flags uintptr) (int32, error) {
+	k.gets++
+	return k.Kernel.Get(params, flags)
+}
+
+func TestWatcherReads(t *testing.T) {
+	k := &countingKernel{Kernel: jailtest.NewKernel()}
+	prev := jail.SetKernel(k)
+	t.Cleanup(func() { jail.SetKernel(prev) })
+	jail.Create(jail.NewSpec("web", "/jails/web"))
+	w := jail.NewWatcher()
+	k.gets = 0
+	w.Poll()
+	one := k.gets
+	jail.Create(jail.NewSpec("db", "/jails/db"))
+	jail.Create(jail.NewSpec("dns", "/jails/dns"))
+	k.gets = 0
+	w.Poll()
+	// Each jail takes a lastjid read, a read of its parameters, and
+	// a read of each address family
+	if got, want := k.gets-one, 2*4; got != want {
+		t.Fatalf("expected %d more reads for 2 more jails but got %d", want, got)
+	}
+}
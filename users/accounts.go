@@ -0,0 +1,336 @@
+package users
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+	"golang.org/x/sys/unix"
+)
+
+// FS is the filesystem of a root that accounts are edited in. Names
+// are slash-separated and relative to the root. jail.FS implements
+// it, and confines the files to the root of a jail.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// The files that hold the accounts, relative to the root
+const (
+	PasswdFile = "etc/master.passwd"
+	GroupFile  = "etc/group"
+)
+
+// MinUID is the first UID (and GID) that AddUser gives out, as
+// adduser(8) does
+const MinUID = 1001
+
+// Accounts edits the accounts of a root (eg the root of a jail).
+// After master.passwd(5) is written, its databases (pwd.db and
+// spwd.db) and etc/passwd are rebuilt by the pwd_mkdb(8) of the host.
+type Accounts struct {
+	FS FS
+	// Root is the path of the root on the host, for pwd_mkdb(8)
+	Root string
+	// Runner runs pwd_mkdb(8) (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+// Returns the Accounts of a root
+func New(fsys FS, root string) *Accounts {
+	return &Accounts{FS: fsys, Root: root}
+}
+
+// Reads etc/master.passwd
+func (a *Accounts) Passwd() (*Passwd, error) {
+	b, err := a.FS.ReadFile(PasswdFile)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePasswd(bytes.NewReader(b))
+}
+
+// Reads etc/group
+func (a *Accounts) Groups() (*Groups, error) {
+	b, err := a.FS.ReadFile(GroupFile)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGroups(bytes.NewReader(b))
+}
+
+// Writes etc/master.passwd, and rebuilds its databases
+func (a *Accounts) WritePasswd(p *Passwd) error {
+	if err := a.replace(PasswdFile, p.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return a.mkdb()
+}
+
+// Writes etc/group
+func (a *Accounts) WriteGroups(g *Groups) error {
+	return a.replace(GroupFile, g.Bytes(), 0o644)
+}
+
+// Adds a user, and makes it a member of groups. A UID of 0 is
+// replaced by the next free UID from MinUID. When no group has the
+// GID of the user, a group named after the user is created with it
+// (a GID of 0 then takes the UID). The home directory is created
+// and owned by the user, unless it is empty or /nonexistent.
+func (a *Accounts) AddUser(u User, groups ...string) error {
+	passwd, err := a.Passwd()
+	if err != nil {
+		return err
+	}
+	grp, err := a.Groups()
+	if err != nil {
+		return err
+	}
+	if u.UID == 0 {
+		u.UID = passwd.NextUID(MinUID)
+	}
+	if u.GID == 0 {
+		u.GID = u.UID
+	}
+	if _, ok := grp.LookupID(u.GID); !ok {
+		if err := grp.Add(Group{Name: u.Name, Password: "*", GID: u.GID}); err != nil {
+			return err
+		}
+	}
+	for _, name := range groups {
+		if err := grp.AddMember(name, u.Name); err != nil {
+			return err
+		}
+	}
+	if err := passwd.Add(u); err != nil {
+		return err
+	}
+	if err := a.WriteGroups(grp); err != nil {
+		return err
+	}
+	if err := a.WritePasswd(passwd); err != nil {
+		return err
+	}
+	return a.mkhome(u)
+}
+
+// Removes a user, and its membership of groups. The group named
+// after the user, and its home directory, are kept.
+func (a *Accounts) RemoveUser(name string) error {
+	passwd, err := a.Passwd()
+	if err != nil {
+		return err
+	}
+	grp, err := a.Groups()
+	if err != nil {
+		return err
+	}
+	if !passwd.Remove(name) {
+		return fmt.Errorf("user %s does not exist", name)
+	}
+	grp.RemoveMember(name)
+	if err := a.WriteGroups(grp); err != nil {
+		return err
+	}
+	return a.WritePasswd(passwd)
+}
+
+// Adds a group. A GID of 0 is replaced by the next free GID from
+// MinUID.
+func (a *Accounts) AddGroup(g Group) error {
+	grp, err := a.Groups()
+	if err != nil {
+		return err
+	}
+	if g.GID == 0 {
+		g.GID = grp.NextGID(MinUID)
+	}
+	if g.Password == "" {
+		g.Password = "*"
+	}
+	if err := grp.Add(g); err != nil {
+		return err
+	}
+	return a.WriteGroups(grp)
+}
+
+// Adds an SSH public key (a line of authorized_keys, eg
+// "ssh-ed25519 AAAA... admin@host") to ~/.ssh/authorized_keys of a
+// user. A key that is already there is not added again. The user
+// owns ~/.ssh and could replace its entries: ~/.ssh and the file are
+// opened without following symbolic links, relative to the home
+// directory, and a file with several links is refused.
+func (a *Accounts) AddAuthorizedKey(user, key string) error {
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("invalid authorized key: %q", key)
+	}
+	passwd, err := a.Passwd()
+	if err != nil {
+		return err
+	}
+	u, ok := passwd.Lookup(user)
+	if !ok {
+		return fmt.Errorf("user %s does not exist", user)
+	} else if !hasHome(u) {
+		return fmt.Errorf("user %s has no home directory", user)
+	}
+	home, err := a.FS.OpenFile(homeName(u), os.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer home.Close()
+	if err := unix.Mkdirat(int(home.Fd()), ".ssh", 0o700); err != nil && err != unix.EEXIST {
+		return &fs.PathError{Op: "mkdir", Path: ".ssh", Err: err}
+	}
+	dir, err := openAt(home, ".ssh", os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := own(dir, u, 0o700); err != nil {
+		return err
+	}
+	f, err := openAt(dir, "authorized_keys", os.O_RDWR|os.O_CREATE|unix.O_NONBLOCK, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", f.Name())
+	} else if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Nlink != 1 {
+		return fmt.Errorf("%s: has several links", f.Name())
+	}
+	if err := own(f, u, 0o600); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.TrimSpace(line) == key {
+			return nil
+		}
+	}
+	line := key + "\n"
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		line = "\n" + line
+	}
+	_, err = f.WriteString(line)
+	return err
+}
+
+// mkhome creates the home directory of a user. An existing home
+// directory is left as it is.
+func (a *Accounts) mkhome(u User) error {
+	if !hasHome(u) {
+		return nil
+	}
+	name := homeName(u)
+	if _, err := a.FS.Lstat(name); err == nil {
+		return nil
+	}
+	if err := a.FS.MkdirAll(name, 0o755); err != nil {
+		return err
+	}
+	home, err := a.FS.OpenFile(name, os.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer home.Close()
+	return own(home, u, 0o755)
+}
+
+// replace writes a file through a temporary file that is renamed
+// over it. A stale temporary file is removed first, and the new one
+// is created exclusively, so that it cannot be a symbolic link.
+func (a *Accounts) replace(name string, data []byte, mode fs.FileMode) error {
+	tmp := path.Join(path.Dir(name), "."+path.Base(name)+".tmp")
+	if err := a.FS.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	f, err := a.FS.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return a.FS.Rename(tmp, name)
+}
+
+// mkdb runs the pwd_mkdb(8) of the host on etc/master.passwd. The
+// pwd_mkdb(8) of the root is not run: root in a jail could replace it
+// (or the libraries it loads), and chroot(8) would not confine it. etc
+// and master.passwd are checked through FS first, so that pwd_mkdb(8)
+// is not led outside of the root by a symbolic link.
+func (a *Accounts) mkdb() error {
+	etc := path.Dir(PasswdFile)
+	if fi, err := a.FS.Lstat(etc); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("users: %s is not a directory", etc)
+	}
+	if fi, err := a.FS.Lstat(PasswdFile); err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("users: %s is not a regular file", PasswdFile)
+	}
+	cmd := exec.Command("pwd_mkdb", "-p", "-d", filepath.Join(a.Root, etc), filepath.Join(a.Root, PasswdFile))
+	_, err := runner.Or(a.Runner).Run(cmd)
+	return err
+}
+
+// own sets the owner and mode of an open file
+func own(f *os.File, u User, mode fs.FileMode) error {
+	if err := f.Chown(int(u.UID), int(u.GID)); err != nil {
+		return err
+	}
+	return f.Chmod(mode)
+}
+
+// openAt opens a single entry of an open directory, without
+// following a symbolic link
+func openAt(dir *os.File, name string, flag int, perm uint32) (*os.File, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, flag|unix.O_NOFOLLOW|unix.O_CLOEXEC, perm)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: filepath.Join(dir.Name(), name), Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name)), nil
+}
+
+// homeName returns the home directory of a user, relative to the
+// root
+func homeName(u User) string {
+	return strings.TrimPrefix(path.Clean(u.Home), "/")
+}
+
+// hasHome reports whether a user has a home directory to create
+func hasHome(u User) bool {
+	return u.Home != "" && u.Home != "/" && u.Home != "/nonexistent"
+}
@@ -0,0 +1,395 @@
+// Package users reads and edits the accounts of a FreeBSD system,
+// such as the root of a jail: etc/master.passwd(5) and etc/group(5),
+// whose databases are rebuilt through pwd_mkdb(8).
+package users
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// User is an entry of master.passwd(5)
+type User struct {
+	Name string
+	// Password is the encrypted password: "*" disables password
+	// logins, and "*LOCKED*" locks the account
+	Password string
+	UID      uint32
+	GID      uint32
+	// Class is a login class of login.conf(5)
+	Class string
+	// Change is when the password must be changed (seconds since
+	// the epoch), or 0
+	Change int64
+	// Expire is when the account expires (seconds since the epoch),
+	// or 0
+	Expire int64
+	Gecos  string
+	Home   string
+	Shell  string
+}
+
+// Parses a line of master.passwd(5)
+func ParseUser(line string) (User, error) {
+	f := strings.Split(line, ":")
+	if len(f) != 10 {
+		return User{}, fmt.Errorf("expected 10 fields but got %d: %q", len(f), line)
+	}
+	uid, err := strconv.ParseUint(f[2], 10, 32)
+	if err != nil {
+		return User{}, fmt.Errorf("invalid uid: %q", f[2])
+	}
+	gid, err := strconv.ParseUint(f[3], 10, 32)
+	if err != nil {
+		return User{}, fmt.Errorf("invalid gid: %q", f[3])
+	}
+	change, err := parseTime(f[5])
+	if err != nil {
+		return User{}, fmt.Errorf("invalid change time: %q", f[5])
+	}
+	expire, err := parseTime(f[6])
+	if err != nil {
+		return User{}, fmt.Errorf("invalid expire time: %q", f[6])
+	}
+	return User{
+		Name:     f[0],
+		Password: f[1],
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		Class:    f[4],
+		Change:   change,
+		Expire:   expire,
+		Gecos:    f[7],
+		Home:     f[8],
+		Shell:    f[9],
+	}, nil
+}
+
+// Returns the user as a line of master.passwd(5)
+func (u User) String() string {
+	return strings.Join([]string{
+		u.Name, u.Password,
+		strconv.FormatUint(uint64(u.UID), 10), strconv.FormatUint(uint64(u.GID), 10),
+		u.Class, strconv.FormatInt(u.Change, 10), strconv.FormatInt(u.Expire, 10),
+		u.Gecos, u.Home, u.Shell,
+	}, ":")
+}
+
+// Validate reports a user that would corrupt master.passwd(5)
+func (u User) Validate() error {
+	if err := validateName("user", u.Name); err != nil {
+		return err
+	}
+	for _, field := range []string{u.Password, u.Class, u.Gecos, u.Home, u.Shell} {
+		if strings.ContainsAny(field, ":\n") {
+			return fmt.Errorf("user %s: invalid field: %q", u.Name, field)
+		}
+	}
+	return nil
+}
+
+// Group is an entry of group(5)
+type Group struct {
+	Name     string
+	Password string
+	GID      uint32
+	Members  []string
+}
+
+// Parses a line of group(5)
+func ParseGroup(line string) (Group, error) {
+	f := strings.Split(line, ":")
+	if len(f) != 4 {
+		return Group{}, fmt.Errorf("expected 4 fields but got %d: %q", len(f), line)
+	}
+	gid, err := strconv.ParseUint(f[2], 10, 32)
+	if err != nil {
+		return Group{}, fmt.Errorf("invalid gid: %q", f[2])
+	}
+	g := Group{Name: f[0], Password: f[1], GID: uint32(gid)}
+	if f[3] != "" {
+		g.Members = strings.Split(f[3], ",")
+	}
+	return g, nil
+}
+
+// Returns the group as a line of group(5)
+func (g Group) String() string {
+	return strings.Join([]string{g.Name, g.Password, strconv.FormatUint(uint64(g.GID), 10), strings.Join(g.Members, ",")}, ":")
+}
+
+// Validate reports a group that would corrupt group(5)
+func (g Group) Validate() error {
+	if err := validateName("group", g.Name); err != nil {
+		return err
+	}
+	if strings.ContainsAny(g.Password, ":\n") {
+		return fmt.Errorf("group %s: invalid password", g.Name)
+	}
+	for _, m := range g.Members {
+		if err := validateName("member", m); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
+
+// Passwd is a master.passwd(5) file. Comments, blank lines and NIS
+// entries (those that start with + or -) are kept as they are.
+type Passwd struct {
+	lines []entry[User]
+}
+
+// Groups is a group(5) file. Comments, blank lines and NIS entries
+// are kept as they are.
+type Groups struct {
+	lines []entry[Group]
+}
+
+// entry is a line of a file: an account, or raw text
+type entry[T any] struct {
+	raw     string
+	account *T
+}
+
+// Parses a master.passwd(5) file
+func ParsePasswd(r io.Reader) (*Passwd, error) {
+	lines, err := parse(r, ParseUser)
+	if err != nil {
+		return nil, fmt.Errorf("master.passwd: %w", err)
+	}
+	return &Passwd{lines: lines}, nil
+}
+
+// Parses a group(5) file
+func ParseGroups(r io.Reader) (*Groups, error) {
+	lines, err := parse(r, ParseGroup)
+	if err != nil {
+		return nil, fmt.Errorf("group: %w", err)
+	}
+	return &Groups{lines: lines}, nil
+}
+
+// Returns the users, in the order of the file
+func (p *Passwd) Users() []User {
+	return accounts(p.lines)
+}
+
+// Returns a user by name
+func (p *Passwd) Lookup(name string) (User, bool) {
+	return lookup(p.lines, func(u User) bool { return u.Name == name })
+}
+
+// Returns a user by UID
+func (p *Passwd) LookupID(uid uint32) (User, bool) {
+	return lookup(p.lines, func(u User) bool { return u.UID == uid })
+}
+
+// Appends a user. The name and UID must not be taken.
+func (p *Passwd) Add(u User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	} else if _, ok := p.Lookup(u.Name); ok {
+		return fmt.Errorf("user %s already exists", u.Name)
+	} else if other, ok := p.LookupID(u.UID); ok {
+		return fmt.Errorf("uid %d is taken by %s", u.UID, other.Name)
+	}
+	p.lines = append(p.lines, entry[User]{account: &u})
+	return nil
+}
+
+// Replaces a user of the same name
+func (p *Passwd) Update(u User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	return update(p.lines, &u, func(v User) bool { return v.Name == u.Name }, "user "+u.Name)
+}
+
+// Removes a user, and reports whether it was found
+func (p *Passwd) Remove(name string) bool {
+	return remove(&p.lines, func(u User) bool { return u.Name == name })
+}
+
+// Returns the smallest free UID from min (eg 1001, as adduser(8)
+// does)
+func (p *Passwd) NextUID(min uint32) uint32 {
+	return next(p.Users(), min, func(u User) uint32 { return u.UID })
+}
+
+// Returns the file in the master.passwd(5) format
+func (p *Passwd) Bytes() []byte {
+	return format(p.lines)
+}
+
+// Returns the groups, in the order of the file
+func (g *Groups) Groups() []Group {
+	return accounts(g.lines)
+}
+
+// Returns a group by name
+func (g *Groups) Lookup(name string) (Group, bool) {
+	return lookup(g.lines, func(gr Group) bool { return gr.Name == name })
+}
+
+// Returns a group by GID
+func (g *Groups) LookupID(gid uint32) (Group, bool) {
+	return lookup(g.lines, func(gr Group) bool { return gr.GID == gid })
+}
+
+// Appends a group. The name and GID must not be taken.
+func (g *Groups) Add(gr Group) error {
+	if err := gr.Validate(); err != nil {
+		return err
+	} else if _, ok := g.Lookup(gr.Name); ok {
+		return fmt.Errorf("group %s already exists", gr.Name)
+	} else if other, ok := g.LookupID(gr.GID); ok {
+		return fmt.Errorf("gid %d is taken by %s", gr.GID, other.Name)
+	}
+	g.lines = append(g.lines, entry[Group]{account: &gr})
+	return nil
+}
+
+// Replaces a group of the same name
+func (g *Groups) Update(gr Group) error {
+	if err := gr.Validate(); err != nil {
+		return err
+	}
+	return update(g.lines, &gr, func(v Group) bool { return v.Name == gr.Name }, "group "+gr.Name)
+}
+
+// Removes a group, and reports whether it was found
+func (g *Groups) Remove(name string) bool {
+	return remove(&g.lines, func(gr Group) bool { return gr.Name == name })
+}
+
+// Adds a user to the members of a group
+func (g *Groups) AddMember(group, user string) error {
+	gr, ok := g.Lookup(group)
+	if !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	} else if slices.Contains(gr.Members, user) {
+		return nil
+	}
+	gr.Members = append(slices.Clone(gr.Members), user)
+	return g.Update(gr)
+}
+
+// Removes a user from the members of every group
+func (g *Groups) RemoveMember(user string) {
+	for _, e := range g.lines {
+		if e.account != nil {
+			e.account.Members = slices.DeleteFunc(slices.Clone(e.account.Members), func(m string) bool { return m == user })
+		}
+	}
+}
+
+// Returns the smallest free GID from min
+func (g *Groups) NextGID(min uint32) uint32 {
+	return next(g.Groups(), min, func(gr Group) uint32 { return gr.GID })
+}
+
+// Returns the file in the group(5) format
+func (g *Groups) Bytes() []byte {
+	return format(g.lines)
+}
+
+func parse[T any](r io.Reader, parseLine func(string) (T, error)) ([]entry[T], error) {
+	var lines []entry[T]
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if t := strings.TrimSpace(line); t == "" || t[0] == '#' || t[0] == '+' || t[0] == '-' {
+			lines = append(lines, entry[T]{raw: line})
+			continue
+		}
+		account, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		lines = append(lines, entry[T]{account: &account})
+	}
+	return lines, s.Err()
+}
+
+func accounts[T any](lines []entry[T]) []T {
+	var all []T
+	for _, e := range lines {
+		if e.account != nil {
+			all = append(all, *e.account)
+		}
+	}
+	return all
+}
+
+func lookup[T any](lines []entry[T], match func(T) bool) (T, bool) {
+	for _, e := range lines {
+		if e.account != nil && match(*e.account) {
+			return *e.account, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+func update[T any](lines []entry[T], account *T, match func(T) bool, what string) error {
+	for i, e := range lines {
+		if e.account != nil && match(*e.account) {
+			lines[i].account = account
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not exist", what)
+}
+
+func remove[T any](lines *[]entry[T], match func(T) bool) bool {
+	n := len(*lines)
+	*lines = slices.DeleteFunc(*lines, func(e entry[T]) bool { return e.account != nil && match(*e.account) })
+	return len(*lines) != n
+}
+
+func next[T any](all []T, min uint32, id func(T) uint32) uint32 {
+	taken := make(map[uint32]bool, len(all))
+	for _, a := range all {
+		taken[id(a)] = true
+	}
+	for taken[min] {
+		min++
+	}
+	return min
+}
+
+func format[T fmt.Stringer](lines []entry[T]) []byte {
+	var b bytes.Buffer
+	for _, e := range lines {
+		if e.account != nil {
+			b.WriteString((*e.account).String())
+		} else {
+			b.WriteString(e.raw)
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// parseTime parses the change and expire fields, which are empty or
+// 0 when unset
+func parseTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// validateName reports a user or group name that pw(8) would refuse
+func validateName(kind, name string) error {
+	if name == "" || strings.ContainsAny(name, ":,\n \t") || name[0] == '-' || name[0] == '+' || name[0] == '#' {
+		return fmt.Errorf("invalid %s name: %q", kind, name)
+	}
+	return nil
+}
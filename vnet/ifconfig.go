@@ -0,0 +1,82 @@
+package vnet
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+	"git.hardenedbsd.org/0x1eef/jail/runner"
+)
+
+// Ifconfig implements Interfaces through ifconfig(8) and route(8).
+// Commands inside a jail run through jail.Command.
+type Ifconfig struct {
+	// Runner runs the commands (runner.Exec when nil)
+	Runner runner.Runner
+}
+
+func (i Ifconfig) CreateEpair(ctx context.Context) (string, string, error) {
+	a, err := i.host(ctx, "epair", "create")
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.HasSuffix(a, "a") {
+		return "", "", errors.New("vnet: unexpected epair name: " + a)
+	}
+	return a, strings.TrimSuffix(a, "a") + "b", nil
+}
+
+func (i Ifconfig) CreateBridge(ctx context.Context) (string, error) {
+	return i.host(ctx, "bridge", "create")
+}
+
+func (i Ifconfig) AddMember(ctx context.Context, bridge, iface string) error {
+	_, err := i.host(ctx, bridge, "addm", iface)
+	return err
+}
+
+func (i Ifconfig) Up(ctx context.Context, iface string) error {
+	_, err := i.host(ctx, iface, "up")
+	return err
+}
+
+func (i Ifconfig) Move(ctx context.Context, iface string, jid int32) error {
+	_, err := i.host(ctx, iface, "vnet", strconv.Itoa(int(jid)))
+	return err
+}
+
+func (i Ifconfig) Destroy(ctx context.Context, iface string) error {
+	_, err := i.host(ctx, iface, "destroy")
+	return err
+}
+
+func (i Ifconfig) SetAddr(ctx context.Context, jid int32, iface string, addr netip.Prefix) error {
+	family := "inet"
+	if addr.Addr().Is6() {
+		family = "inet6"
+	}
+	_, err := i.run(jail.Command(ctx, jid, "ifconfig", iface, family, addr.String(), "alias", "up"))
+	return err
+}
+
+func (i Ifconfig) AddRoute(ctx context.Context, jid int32, gateway netip.Addr) error {
+	family := "-inet"
+	if gateway.Is6() {
+		family = "-inet6"
+	}
+	_, err := i.run(jail.Command(ctx, jid, "route", "add", family, "default", gateway.String()))
+	return err
+}
+
+func (i Ifconfig) host(ctx context.Context, arg ...string) (string, error) {
+	return i.run(exec.CommandContext(ctx, "ifconfig", arg...))
+}
+
+func (i Ifconfig) run(cmd *exec.Cmd) (string, error) {
+	out, err := runner.Or(i.Runner).Run(cmd)
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,150 @@
+// Package vnet provides networking for VNET jails: an epair(4) is
+// created for each jail, one end is moved into the jail (as the
+// vnet.interface parameter of jail(8) does), and the jail end is
+// configured from inside the jail.
+package vnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"git.hardenedbsd.org/0x1eef/jail"
+)
+
+// Interfaces performs the interface operations that VNET networking
+// needs. Ifconfig implements it through ifconfig(8) and route(8),
+// and tests can provide a fake.
+type Interfaces interface {
+	// CreateEpair creates an epair(4) and returns the names of
+	// its two ends
+	CreateEpair(ctx context.Context) (string, string, error)
+	// CreateBridge creates an if_bridge(4) and returns its name
+	CreateBridge(ctx context.Context) (string, error)
+	// AddMember adds an interface to a bridge
+	AddMember(ctx context.Context, bridge, iface string) error
+	// Up brings a host interface up
+	Up(ctx context.Context, iface string) error
+	// Move moves a host interface into a jail
+	Move(ctx context.Context, iface string, jid int32) error
+	// Destroy destroys a host interface
+	Destroy(ctx context.Context, iface string) error
+	// SetAddr adds an address to an interface inside a jail, and
+	// brings the interface up
+	SetAddr(ctx context.Context, jid int32, iface string, addr netip.Prefix) error
+	// AddRoute adds a default route inside a jail
+	AddRoute(ctx context.Context, jid int32, gateway netip.Addr) error
+}
+
+// Config describes the network of a VNET jail
+type Config struct {
+	// Bridge is the bridge the host end of the epair joins. It
+	// is left out of a bridge when empty.
+	Bridge string
+	// Addrs are the addresses of the jail end of the epair
+	Addrs []netip.Prefix
+	// Gateways are the default routes of the jail (at most one
+	// per address family)
+	Gateways []netip.Addr
+}
+
+// Link is an epair with one end moved into a jail
+type Link struct {
+	JID int32
+	// Host is the end of the epair that stays on the host
+	Host string
+	// Jail is the end of the epair that was moved into the jail
+	Jail string
+}
+
+// Network creates and destroys the links of VNET jails
+type Network struct {
+	ifs Interfaces
+}
+
+// Returns a Network that uses ifs for interface operations
+func New(ifs Interfaces) *Network {
+	return &Network{ifs: ifs}
+}
+
+// Creates a bridge for jails to share
+func (n *Network) CreateBridge(ctx context.Context) (string, error) {
+	return n.ifs.CreateBridge(ctx)
+}
+
+// Connects a VNET jail: an epair is created, its host end is brought
+// up (and joins the bridge), and its jail end is moved into the jail
+// and configured with the addresses and routes of the config. The
+// epair is destroyed again when a step fails.
+func (n *Network) Attach(ctx context.Context, j *jail.Jail, c Config) (*Link, error) {
+	if !j.Vnet {
+		return nil, fmt.Errorf("vnet: jail %d is not a VNET jail", j.ID)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	host, peer, err := n.ifs.CreateEpair(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l := &Link{JID: j.ID, Host: host, Jail: peer}
+	if err := n.setup(ctx, l, c); err != nil {
+		return nil, errors.Join(err, n.ifs.Destroy(ctx, host))
+	}
+	return l, nil
+}
+
+func (n *Network) setup(ctx context.Context, l *Link, c Config) error {
+	if c.Bridge != "" {
+		if err := n.ifs.AddMember(ctx, c.Bridge, l.Host); err != nil {
+			return err
+		}
+	}
+	if err := n.ifs.Up(ctx, l.Host); err != nil {
+		return err
+	}
+	if err := n.ifs.Move(ctx, l.Jail, l.JID); err != nil {
+		return err
+	}
+	if err := n.ifs.SetAddr(ctx, l.JID, "lo0", netip.MustParsePrefix("127.0.0.1/8")); err != nil {
+		return err
+	}
+	for _, addr := range c.Addrs {
+		if err := n.ifs.SetAddr(ctx, l.JID, l.Jail, addr); err != nil {
+			return err
+		}
+	}
+	for _, gw := range c.Gateways {
+		if err := n.ifs.AddRoute(ctx, l.JID, gw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Disconnects a VNET jail by destroying its epair. Both ends are
+// destroyed, including the end inside the jail.
+func (n *Network) Detach(ctx context.Context, l *Link) error {
+	return n.ifs.Destroy(ctx, l.Host)
+}
+
+func (c Config) validate() error {
+	var v4, v6 int
+	for _, gw := range c.Gateways {
+		if gw.Is4() {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	if v4 > 1 || v6 > 1 {
+		return errors.New("vnet: at most one gateway per address family")
+	}
+	for _, addr := range c.Addrs {
+		if !addr.IsValid() {
+			return fmt.Errorf("vnet: invalid address: %s", addr)
+		}
+	}
+	return nil
+}